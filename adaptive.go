@@ -0,0 +1,30 @@
+package netplus
+
+import "time"
+
+// adaptiveWindow is how many seconds of throughput WithAdaptiveTimeout goes by
+const adaptiveWindow = 5
+
+// WithAdaptiveTimeout replaces the idle timeout of the sessions with one between
+// min and max, in proportion to the bytes per second copied over the last
+// seconds relative to throughputThreshold, a busy session gets max and a nearly
+// idle one min
+func WithAdaptiveTimeout(min, max time.Duration, throughputThreshold float64) Option {
+	return func(p *Piper) {
+		p.adaptiveTimeout = &adaptiveTimeout{min: min, max: max, threshold: throughputThreshold}
+	}
+}
+
+type adaptiveTimeout struct {
+	min, max  time.Duration
+	threshold float64
+}
+
+// timeout interpolates between min and max for bps bytes per second
+func (a *adaptiveTimeout) timeout(bps float64) time.Duration {
+	f := 1.0
+	if a.threshold > 0 && bps < a.threshold {
+		f = bps / a.threshold
+	}
+	return a.min + time.Duration(f*float64(a.max-a.min))
+}
@@ -0,0 +1,59 @@
+package netplus
+
+import "net/http"
+
+// Aggregator sums the statistics of several AutoProxy instances, for setups
+// with one proxy per listener that need a global view
+type Aggregator struct {
+	instances []*AutoProxy
+}
+
+// NewAggregator returns an Aggregator over instances
+func NewAggregator(instances ...*AutoProxy) *Aggregator {
+	return &Aggregator{instances: instances}
+}
+
+// Stats returns the sum of the Stats of every instance
+func (a *Aggregator) Stats() ProxyStats {
+	var total ProxyStats
+	for _, ap := range a.instances {
+		total.add(ap.Stats())
+	}
+	return total
+}
+
+// TotalActiveConnections returns the sessions being piped by all instances
+func (a *Aggregator) TotalActiveConnections() int64 {
+	return a.Stats().ActiveConnections
+}
+
+// TotalBytesTransferred returns the bytes of the finished sessions of all instances
+func (a *Aggregator) TotalBytesTransferred() int64 {
+	return a.Stats().BytesTransferred
+}
+
+// TotalErrors returns the errors counted by all instances
+func (a *Aggregator) TotalErrors() int64 {
+	return a.Stats().Errors
+}
+
+// ServeHTTP renders the totals and the stats of every instance as JSON
+func (a *Aggregator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	view := struct {
+		Total     ProxyStats   `json:"total"`
+		Instances []ProxyStats `json:"instances"`
+	}{Instances: make([]ProxyStats, 0, len(a.instances))}
+	for _, ap := range a.instances {
+		s := ap.Stats()
+		view.Instances = append(view.Instances, s)
+		view.Total.add(s)
+	}
+	writeJSON(w, view)
+}
+
+func (s *ProxyStats) add(o ProxyStats) {
+	s.DroppedConnections += o.DroppedConnections
+	s.ActiveConnections += o.ActiveConnections
+	s.BytesTransferred += o.BytesTransferred
+	s.Errors += o.Errors
+}
@@ -0,0 +1,53 @@
+package netplus_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestAggregator(t *testing.T) {
+	upstream := echoServer(t)
+	defer upstream.Close()
+	a, aln, _ := startAutoProxy(t, upstream, nil)
+	defer a.Stop(context.Background())
+	b, bln, _ := startAutoProxy(t, upstream, nil)
+	defer b.Stop(context.Background())
+	agg := netplus.NewAggregator(a, b)
+
+	var conns []net.Conn
+	for _, addr := range []string{aln.Addr().String(), aln.Addr().String(), bln.Addr().String()} {
+		c, err := net.Dial("tcp", addr)
+		assert.Nil(t, err)
+		defer c.Close()
+		echoOnce(t, c, "ping")
+		conns = append(conns, c)
+	}
+	assert.Equal(t, agg.TotalActiveConnections(), int64(3))
+	assert.Equal(t, agg.TotalBytesTransferred(), int64(0))
+
+	conns[0].Close()
+	conns[1].Close()
+	for agg.TotalActiveConnections() > 1 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, agg.TotalBytesTransferred(), int64(16))
+
+	rec := httptest.NewRecorder()
+	agg.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	var view struct {
+		Total     netplus.ProxyStats   `json:"total"`
+		Instances []netplus.ProxyStats `json:"instances"`
+	}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &view))
+	assert.Len(t, view.Instances, 2)
+	assert.Equal(t, view.Total.ActiveConnections, int64(1))
+	assert.Equal(t, view.Instances[1].ActiveConnections, int64(1))
+	assert.Equal(t, view.Total.Errors, agg.TotalErrors())
+}
@@ -0,0 +1,151 @@
+package netplus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// apmPeekLen is how many bytes of each direction a span is annotated with
+// when WithAPMPayload is on
+const apmPeekLen = 200
+
+// APMTracer starts spans for NewAPMMiddleware, implement it on top of the
+// OpenTelemetry or the Datadog tracer to pick where the spans go
+type APMTracer interface {
+	StartSpan(ctx context.Context, name string) (context.Context, APMSpan)
+}
+
+// APMSpan is a span started by an APMTracer
+type APMSpan interface {
+	SetAttribute(key string, value interface{})
+	// End finishes the span, err is nil when the direction ended cleanly
+	End(err error)
+}
+
+// APMOption configures a middleware created by NewAPMMiddleware
+type APMOption func(*apmOptions)
+
+type apmOptions struct {
+	payload bool
+}
+
+// WithAPMPayload annotates the spans with the first 200 bytes of each direction
+// as netplus.data, it is off by default since the payload may hold credentials
+// or personal data that should not end up in a tracing backend
+func WithAPMPayload(payload bool) APMOption {
+	return func(o *apmOptions) {
+		o.payload = payload
+	}
+}
+
+// NewAPMMiddleware traces both copy directions of every connection with a
+// span started from the context of the connection, so under the span of the
+// caller of Run, a span carries the bytes copied, see WithAPMPayload for the data
+func NewAPMMiddleware(tracer APMTracer, opts ...APMOption) ConnectionMiddleware {
+	var o apmOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+		c := &apmConn{Conn: conn}
+		for dir := range c.dirs {
+			_, c.dirs[dir].span = tracer.StartSpan(ctx, "netplus.copy."+Direction(dir).String())
+			c.dirs[dir].payload = o.payload
+		}
+		return c, nil
+	}
+}
+
+// apmConn records what is read from the connection on the upstream span and
+// what is written to it on the downstream span
+type apmConn struct {
+	net.Conn
+	dirs [2]apmDirection
+}
+
+type apmDirection struct {
+	mux     sync.Mutex
+	span    APMSpan
+	payload bool // peek is only kept with WithAPMPayload
+	bytes   int64
+	peek    []byte
+	err     error
+	// busy counts the calls in progress, a span closed meanwhile is ended by
+	// the last of them so their bytes are on it
+	busy   int
+	closed bool
+	ended  bool
+}
+
+func (c *apmConn) Read(b []byte) (int, error) {
+	d := &c.dirs[DirectionUpstream]
+	d.start()
+	n, err := c.Conn.Read(b)
+	d.record(b[:n], err)
+	return n, err
+}
+
+func (c *apmConn) Write(b []byte) (int, error) {
+	d := &c.dirs[DirectionDownstream]
+	d.start()
+	n, err := c.Conn.Write(b)
+	d.record(b[:n], err)
+	return n, err
+}
+
+func (c *apmConn) Close() error {
+	err := c.Conn.Close()
+	for dir := range c.dirs {
+		c.dirs[dir].end()
+	}
+	return err
+}
+
+func (d *apmDirection) start() {
+	d.mux.Lock()
+	d.busy++
+	d.mux.Unlock()
+}
+
+func (d *apmDirection) record(b []byte, err error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.busy--
+	if d.ended {
+		return
+	}
+	d.bytes += int64(len(b))
+	if d.payload && len(d.peek) < apmPeekLen {
+		if left := apmPeekLen - len(d.peek); len(b) > left {
+			b = b[:left]
+		}
+		d.peek = append(d.peek, b...)
+	}
+	if err != nil && d.err == nil && !errors.Is(err, io.EOF) && !isClosedErr(err) {
+		d.err = err
+	}
+	if d.closed && d.busy == 0 {
+		d.finish()
+	}
+}
+
+func (d *apmDirection) end() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.closed = true
+	if d.busy == 0 && !d.ended {
+		d.finish()
+	}
+}
+
+func (d *apmDirection) finish() {
+	d.ended = true
+	d.span.SetAttribute("netplus.bytes", d.bytes)
+	if d.payload {
+		d.span.SetAttribute("netplus.data", string(d.peek))
+	}
+	d.span.End(d.err)
+}
@@ -0,0 +1,98 @@
+package netplus_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+type ctxKey struct{}
+
+type testSpan struct {
+	name   string
+	parent interface{}
+	attrs  map[string]interface{}
+	ended  bool
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+func (s *testSpan) End(err error)                              { s.ended = true }
+
+type testTracer struct {
+	mux   sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) StartSpan(ctx context.Context, name string) (context.Context, netplus.APMSpan) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	s := &testSpan{name: name, parent: ctx.Value(ctxKey{}), attrs: map[string]interface{}{}}
+	t.spans = append(t.spans, s)
+	return ctx, s
+}
+
+func TestAPMMiddleware(t *testing.T) {
+	tracer := &testTracer{}
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+
+	client, conn := net.Pipe()
+	upstream, server := net.Pipe()
+	ctx := context.WithValue(context.Background(), ctxKey{}, "run")
+	downstream, err := netplus.NewAPMMiddleware(tracer, netplus.WithAPMPayload(true))(ctx, conn)
+	assert.Nil(t, err)
+	done := piper.RunAsync(ctx, downstream, upstream)
+
+	req := bytes.Repeat([]byte("q"), 300)
+	go client.Write(req)
+	_, err = io.ReadFull(server, make([]byte, len(req)))
+	assert.Nil(t, err)
+	go server.Write([]byte("resp"))
+	_, err = io.ReadFull(client, make([]byte, 4))
+	assert.Nil(t, err)
+	client.Close()
+	<-done
+
+	assert.Len(t, tracer.spans, 2)
+	up, down := tracer.spans[0], tracer.spans[1]
+	assert.Equal(t, up.name, "netplus.copy.upstream")
+	assert.Equal(t, down.name, "netplus.copy.downstream")
+	for _, s := range tracer.spans {
+		assert.True(t, s.ended)
+		assert.Equal(t, s.parent, "run")
+	}
+	assert.Equal(t, up.attrs["netplus.bytes"], int64(300))
+	assert.Equal(t, up.attrs["netplus.data"], string(req[:200]))
+	assert.Equal(t, down.attrs["netplus.bytes"], int64(4))
+	assert.Equal(t, down.attrs["netplus.data"], "resp")
+}
+
+func TestAPMMiddlewareNoPayload(t *testing.T) {
+	tracer := &testTracer{}
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+
+	client, conn := net.Pipe()
+	upstream, server := net.Pipe()
+	downstream, err := netplus.NewAPMMiddleware(tracer)(context.Background(), conn)
+	assert.Nil(t, err)
+	done := piper.RunAsync(context.Background(), downstream, upstream)
+
+	go client.Write([]byte("secret"))
+	_, err = io.ReadFull(server, make([]byte, 6))
+	assert.Nil(t, err)
+	client.Close()
+	<-done
+
+	// the payload stays out of the spans unless asked for
+	assert.Len(t, tracer.spans, 2)
+	up := tracer.spans[0]
+	assert.Equal(t, up.attrs["netplus.bytes"], int64(6))
+	_, ok := up.attrs["netplus.data"]
+	assert.False(t, ok)
+}
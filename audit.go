@@ -0,0 +1,148 @@
+package netplus
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// auditRecord is one JSON line of the audit log
+type auditRecord struct {
+	Event     string    `json:"event"`
+	ID        string    `json:"id"`
+	Src       string    `json:"src,omitempty"`
+	Dst       string    `json:"dst,omitempty"`
+	BytesUp   *int64    `json:"bytesUp,omitempty"`
+	BytesDown *int64    `json:"bytesDown,omitempty"`
+	Duration  string    `json:"duration,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// auditLog writes the records, either straight to w or through lines
+type auditLog struct {
+	mux   sync.Mutex
+	w     io.Writer
+	lines chan []byte
+	// sendMux guards the sends on lines against close, which sets closed
+	sendMux sync.RWMutex
+	closed  bool
+}
+
+// WithAuditLog writes a JSON line to w when a session opens and when it closes
+// every line is written before the session goes on, so none is lost on a crash
+func WithAuditLog(w io.Writer) Option {
+	return func(p *Piper) {
+		p.audit = &auditLog{w: w}
+	}
+}
+
+// WithAuditLogAsync is WithAuditLog with the lines queued for a background
+// writer, sessions only wait for it when bufSize lines are already queued
+func WithAuditLogAsync(w io.Writer, bufSize int) Option {
+	return func(p *Piper) {
+		a := &auditLog{w: w, lines: make(chan []byte, bufSize)}
+		go a.writeLoop(p)
+		p.audit = a
+	}
+}
+
+func (a *auditLog) writeLoop(p *Piper) {
+	for line := range a.lines {
+		a.write(p, line)
+	}
+}
+
+func (a *auditLog) write(p *Piper, line []byte) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if _, err := a.w.Write(line); err != nil {
+		p.logError("netplus: writing audit log:", err)
+	}
+}
+
+func (p *Piper) auditRecord(r auditRecord) {
+	if p.audit == nil {
+		return
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		p.logError("netplus: encoding audit log:", err)
+		return
+	}
+	line = append(line, '\n')
+	if p.audit.send(line) {
+		return
+	}
+	p.audit.write(p, line)
+}
+
+// send queues line for writeLoop and reports whether it did, the lines of the
+// sessions still ending after Piper.Close are written right away
+func (a *auditLog) send(line []byte) bool {
+	if a.lines == nil {
+		return false
+	}
+	a.sendMux.RLock()
+	defer a.sendMux.RUnlock()
+	if a.closed {
+		return false
+	}
+	a.lines <- line
+	return true
+}
+
+// close ends writeLoop once the lines queued are written
+func (a *auditLog) close() {
+	if a.lines == nil {
+		return
+	}
+	a.sendMux.Lock()
+	defer a.sendMux.Unlock()
+	if !a.closed {
+		a.closed = true
+		close(a.lines)
+	}
+}
+
+func (p *Piper) auditOpen(s *Session) {
+	if p.audit == nil {
+		return
+	}
+	p.auditRecord(auditRecord{
+		Event: "open",
+		ID:    s.id,
+		Src:   s.src,
+		Dst:   s.dst,
+		Time:  s.start,
+	})
+}
+
+func (p *Piper) auditClose(s *Session, stats RunStats) {
+	if p.audit == nil {
+		return
+	}
+	reason := "eof"
+	if stats.Err != nil {
+		reason = stats.Err.Error()
+	}
+	p.auditRecord(auditRecord{
+		Event:     "close",
+		ID:        s.id,
+		BytesUp:   &stats.BytesUpstream,
+		BytesDown: &stats.BytesDownstream,
+		Duration:  stats.Duration.String(),
+		Reason:    reason,
+		Time:      time.Now(),
+	})
+}
+
+// remoteAddr returns the remote address of c when it is a connection
+func remoteAddr(c io.ReadWriteCloser) string {
+	if conn, ok := c.(interface{ RemoteAddr() net.Addr }); ok && conn.RemoteAddr() != nil {
+		return conn.RemoteAddr().String()
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package netplus_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+// lockedBuffer is a bytes.Buffer safe for the async audit writer
+type lockedBuffer struct {
+	mux sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.buf.String()
+}
+
+func auditLines(t *testing.T, s string) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, l := range strings.Split(strings.TrimSpace(s), "\n") {
+		var m map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(l), &m), l)
+		lines = append(lines, m)
+	}
+	return lines
+}
+
+func TestAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithAuditLog(&buf))
+
+	client, downstream := tcpPair(t)
+	upstream, server := tcpPair(t)
+	defer server.Close()
+	go func() {
+		client.Write([]byte("hello"))
+		client.Close()
+	}()
+	_, err := piper.Run(context.Background(), downstream, upstream)
+	assert.Nil(t, err)
+
+	lines := auditLines(t, buf.String())
+	assert.Len(t, lines, 2)
+	assert.Equal(t, lines[0]["event"], "open")
+	assert.Equal(t, lines[0]["id"], "1")
+	assert.Equal(t, lines[0]["src"], client.LocalAddr().String())
+	assert.Equal(t, lines[0]["dst"], server.LocalAddr().String())
+	assert.Equal(t, lines[1]["event"], "close")
+	assert.Equal(t, lines[1]["bytesUp"], float64(5))
+	assert.Equal(t, lines[1]["bytesDown"], float64(0))
+	assert.Equal(t, lines[1]["reason"], "eof")
+}
+
+func TestAuditLogAsync(t *testing.T) {
+	buf := &lockedBuffer{}
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithAuditLogAsync(buf, 16))
+
+	client, downstream := tcpPair(t)
+	upstream, server := tcpPair(t)
+	defer server.Close()
+	client.Close()
+	piper.Run(context.Background(), downstream, upstream)
+
+	for strings.Count(buf.String(), "\n") < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	lines := auditLines(t, buf.String())
+	assert.Equal(t, lines[0]["event"], "open")
+	assert.Equal(t, lines[1]["event"], "close")
+}
@@ -0,0 +1,345 @@
+package netplus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrProxyStopped is returned by AutoProxy.Serve once Stop has been called
+var ErrProxyStopped = errors.New("auto proxy stopped")
+
+// DialFunc dials the upstream for the downstream connection conn
+// conn is the connection as returned by the middleware chain so the target can be
+// chosen from its remote address, TLS state or any other attribute
+type DialFunc func(ctx context.Context, conn net.Conn) (net.Conn, error)
+
+// AutoProxy accepts connections from a listener and pipes each of them to a
+// freshly dialled upstream
+type AutoProxy struct {
+	Piper *Piper
+	// PerConnectionTimeout bounds the lifetime of every session, zero means no bound
+	// when it expires the sockets are shut down as well as closed, in case Close hangs
+	PerConnectionTimeout time.Duration
+	// MaxRetries is the number of times a failed upstream dial is retried, zero disables retries
+	MaxRetries int
+	// MaxBackoff caps the wait between retries and is how long accepting pauses
+	// once all retries failed, 5s when zero
+	MaxBackoff time.Duration
+
+	dialUpstream DialFunc
+	group        *PipeGroup
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mux         sync.Mutex
+	listeners   []net.Listener
+	serving     bool
+	served      chan error // receives the error that ends Serve
+	stopped     bool
+	acceptRate  float64
+	acceptBurst int
+	acceptLimit tokenBucket
+	dropped     int64
+	active      int64
+	bytes       int64
+	errors      int64
+	middleware  []ConnectionMiddleware
+
+	unhealthy       int32
+	stopHealthCheck context.CancelFunc
+	reconnect       reconnectState
+	sniRoutes       atomic.Value // map[string]DialFunc
+	fingerprintHook func(fingerprint string, conn net.Conn) error
+	dpiHook         func(ctx context.Context, peek []byte) (allow bool, reason string)
+}
+
+// ProxyStats is a snapshot of the counters kept by an AutoProxy
+type ProxyStats struct {
+	// DroppedConnections counts the connections closed by the accept rate limit
+	DroppedConnections int64 `json:"dropped_connections"`
+	// ActiveConnections counts the sessions being piped
+	ActiveConnections int64 `json:"active_connections"`
+	// BytesTransferred sums both directions of the sessions that have finished
+	BytesTransferred int64 `json:"bytes_transferred"`
+	// Errors counts failed upstream dials and sessions that ended with an error
+	Errors int64 `json:"errors"`
+}
+
+// NewAutoProxy returns an AutoProxy accepting from l, dialling an upstream for every
+// connection with dialUpstream and piping with p, call Serve to start it
+func NewAutoProxy(l net.Listener, dialUpstream DialFunc, p *Piper, opts ...ProxyOption) *AutoProxy {
+	ctx, cancel := context.WithCancel(context.Background())
+	ap := &AutoProxy{
+		Piper:        p,
+		listeners:    []net.Listener{l},
+		served:       make(chan error, 1),
+		dialUpstream: dialUpstream,
+		group:        NewPipeGroup(p),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	for _, opt := range opts {
+		opt(ap)
+	}
+	return ap
+}
+
+// Serve accepts connections on every listener until Stop is called or one of
+// them is closed, every accepted connection is piped in its own goroutine
+// other accept errors are retried with a backoff of up to a second
+// it returns ErrProxyStopped after Stop, when a listener is closed elsewhere
+// the error is returned and the other listeners keep accepting until Stop
+func (ap *AutoProxy) Serve() error {
+	ap.mux.Lock()
+	ap.serving = true
+	for _, l := range ap.listeners {
+		go ap.acceptLoop(l)
+	}
+	ap.mux.Unlock()
+	return <-ap.served
+}
+
+// acceptLoop accepts from l until it is closed by Stop or RemoveListener or fails
+func (ap *AutoProxy) acceptLoop(l net.Listener) {
+	var delay time.Duration
+	for {
+		if d := ap.reconnect.pauseRemaining(); d > 0 {
+			sleepContext(ap.ctx, d)
+		}
+		conn, err := l.Accept()
+		if err != nil {
+			if ap.isStopped() {
+				ap.serveDone(ErrProxyStopped)
+				return
+			}
+			if !ap.hasListener(l) {
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				ap.serveDone(err)
+				return
+			}
+			// back off on every other error such as running out of file
+			// descriptors or a connection reset before it was accepted
+			delay = acceptDelay(delay)
+			sleepContext(ap.ctx, delay)
+			continue
+		}
+		delay = 0
+		if !ap.allowAccept() {
+			atomic.AddInt64(&ap.dropped, 1)
+			resetConn(conn)
+			continue
+		}
+		go ap.handle(conn)
+	}
+}
+
+// serveDone hands err to Serve unless an earlier error already ended it
+func (ap *AutoProxy) serveDone(err error) {
+	select {
+	case ap.served <- err:
+	default:
+	}
+}
+
+// AddListener accepts from l as well, the connections share the dial function,
+// Piper, limits and sessions of the other listeners
+// after Stop l is closed right away
+func (ap *AutoProxy) AddListener(l net.Listener) {
+	ap.mux.Lock()
+	defer ap.mux.Unlock()
+	if ap.stopped {
+		l.Close()
+		return
+	}
+	ap.listeners = append(ap.listeners, l)
+	if ap.serving {
+		go ap.acceptLoop(l)
+	}
+}
+
+// RemoveListener stops accepting on l and closes it
+// the sessions of connections accepted from it keep running
+func (ap *AutoProxy) RemoveListener(l net.Listener) {
+	ap.mux.Lock()
+	found := false
+	for i, ln := range ap.listeners {
+		if ln == l {
+			ap.listeners = append(ap.listeners[:i:i], ap.listeners[i+1:]...)
+			found = true
+			break
+		}
+	}
+	ap.mux.Unlock()
+	if found {
+		l.Close()
+	}
+}
+
+// Listeners returns the listeners the proxy accepts from
+func (ap *AutoProxy) Listeners() []net.Listener {
+	ap.mux.Lock()
+	defer ap.mux.Unlock()
+	return append([]net.Listener(nil), ap.listeners...)
+}
+
+func (ap *AutoProxy) hasListener(l net.Listener) bool {
+	ap.mux.Lock()
+	defer ap.mux.Unlock()
+	for _, ln := range ap.listeners {
+		if ln == l {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptDelay doubles the wait after a failed Accept, from 5ms up to one second
+func acceptDelay(d time.Duration) time.Duration {
+	if d == 0 {
+		return 5 * time.Millisecond
+	}
+	if d *= 2; d > time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+func (ap *AutoProxy) handle(conn net.Conn) {
+	remote := conn.RemoteAddr()
+	conn, err := ap.applyMiddleware(ap.ctx, conn)
+	if err != nil {
+		if !errors.Is(err, ErrNotAllowed) {
+			ap.Piper.logError("netplus: middleware failed for", remote, ":", err)
+		} else if ap.Piper.debugEnabled(0) {
+			ap.Piper.debug("netplus: connection from", remote, "rejected:", err)
+		}
+		return
+	}
+
+	if !ap.upstreamAvailable() {
+		if ap.Piper.debugEnabled(0) {
+			ap.Piper.debug("netplus: closing connection from", remote, ":", ErrUpstreamUnavailable)
+		}
+		conn.Close()
+		return
+	}
+	conn, ok := ap.inspect(conn)
+	if !ok {
+		return
+	}
+	hello, conn := ap.peekClientHello(conn)
+	if hello != nil && ap.fingerprintHook != nil {
+		if err := ap.fingerprintHook(hello.ja3(), conn); err != nil {
+			if ap.Piper.debugEnabled(0) {
+				ap.Piper.debug("netplus: connection from", remote, "rejected by fingerprint:", err)
+			}
+			conn.Close()
+			return
+		}
+	}
+	upstream, err := ap.dialWithBackoff(ap.sniRoute(hello), conn)
+	if err != nil {
+		ap.Piper.logError("netplus: dialling upstream for", conn.RemoteAddr(), "failed:", err)
+		atomic.AddInt64(&ap.errors, 1)
+		conn.Close()
+		return
+	}
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if ap.PerConnectionTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, ap.PerConnectionTimeout)
+		go func() {
+			<-ctx.Done()
+			if ctx.Err() == context.DeadlineExceeded {
+				shutdownConn(conn)
+				shutdownConn(upstream)
+			}
+		}()
+	}
+	atomic.AddInt64(&ap.active, 1)
+	done := func(stats RunStats, err error) {
+		cancel()
+		atomic.AddInt64(&ap.bytes, stats.BytesUpstream+stats.BytesDownstream)
+		if err != nil {
+			atomic.AddInt64(&ap.errors, 1)
+		}
+		atomic.AddInt64(&ap.active, -1)
+	}
+	if err := ap.group.add(ctx, conn, upstream, done); err != nil {
+		atomic.AddInt64(&ap.active, -1)
+		cancel()
+		conn.Close()
+		upstream.Close()
+	}
+}
+
+// SetAcceptRateLimit allows at most rps new connections per second with bursts of burst
+// connections over the limit are accepted and reset right away without dialling upstream
+// rps <= 0 removes the limit
+func (ap *AutoProxy) SetAcceptRateLimit(rps float64, burst int) {
+	ap.mux.Lock()
+	defer ap.mux.Unlock()
+	if burst < 1 {
+		burst = 1
+	}
+	ap.acceptRate = rps
+	ap.acceptBurst = burst
+	ap.acceptLimit.reset()
+}
+
+func (ap *AutoProxy) allowAccept() bool {
+	ap.mux.Lock()
+	rate, burst := ap.acceptRate, ap.acceptBurst
+	ap.mux.Unlock()
+	if rate <= 0 {
+		return true
+	}
+	return ap.acceptLimit.allow(rate, float64(burst))
+}
+
+// Stats returns a snapshot of the proxy counters
+func (ap *AutoProxy) Stats() ProxyStats {
+	return ProxyStats{
+		DroppedConnections: atomic.LoadInt64(&ap.dropped),
+		ActiveConnections:  atomic.LoadInt64(&ap.active),
+		BytesTransferred:   atomic.LoadInt64(&ap.bytes),
+		Errors:             atomic.LoadInt64(&ap.errors),
+	}
+}
+
+// resetConn closes conn, with a TCP RST when it is a TCP connection
+func resetConn(conn net.Conn) {
+	if tc, ok := conn.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+	conn.Close()
+}
+
+func (ap *AutoProxy) isStopped() bool {
+	ap.mux.Lock()
+	defer ap.mux.Unlock()
+	return ap.stopped
+}
+
+// Stop closes the listeners and waits for the active sessions to finish
+// it returns ctx.Err() if ctx is done first, the sessions are left running
+func (ap *AutoProxy) Stop(ctx context.Context) error {
+	ap.mux.Lock()
+	if !ap.stopped {
+		ap.stopped = true
+		ap.cancel()
+		for _, l := range ap.listeners {
+			l.Close()
+		}
+		// Serve may have no listener left to report it
+		ap.serveDone(ErrProxyStopped)
+	}
+	ap.mux.Unlock()
+	return ap.group.Shutdown(ctx)
+}
@@ -0,0 +1,333 @@
+package netplus_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+// echoServer echoes everything sent to it until it is closed
+func echoServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return ln
+}
+
+// startAutoProxy serves an AutoProxy to upstream, setup runs before Serve when not nil
+func startAutoProxy(t *testing.T, upstream net.Listener, setup func(ap *netplus.AutoProxy)) (*netplus.AutoProxy, net.Listener, chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	ap := netplus.NewAutoProxy(ln, func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", upstream.Addr().String())
+	}, piper)
+	if setup != nil {
+		setup(ap)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- ap.Serve()
+	}()
+	return ap, ln, served
+}
+
+// failingListener fails the first fails Accept calls with a connection reset
+type failingListener struct {
+	net.Listener
+	fails int32
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	if atomic.AddInt32(&l.fails, -1) >= 0 {
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: syscall.ECONNRESET}
+	}
+	return l.Listener.Accept()
+}
+
+func TestAutoProxyAcceptErrorBackoff(t *testing.T) {
+	upstream := echoServer(t)
+	defer upstream.Close()
+	var ln *failingListener
+	ap, _, served := startAutoProxy(t, upstream, func(ap *netplus.AutoProxy) {
+		l := ap.Listeners()[0]
+		ap.RemoveListener(l)
+		inner, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		ln = &failingListener{Listener: inner, fails: 3}
+		ap.AddListener(ln)
+	})
+
+	start := time.Now()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+	_, err = c.Write([]byte("ping"))
+	assert.Nil(t, err)
+	_, err = io.ReadFull(c, make([]byte, 4))
+	assert.Nil(t, err)
+	// 5ms, 10ms and 20ms between the failed accepts
+	assert.Ge(t, time.Since(start), 35*time.Millisecond)
+	c.Close()
+
+	assert.Nil(t, ap.Stop(context.Background()))
+	assert.Equal(t, <-served, netplus.ErrProxyStopped)
+}
+
+func TestAutoProxy(t *testing.T) {
+	upstream := echoServer(t)
+	defer upstream.Close()
+	ap, ln, served := startAutoProxy(t, upstream, nil)
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	_, err = c.Write([]byte("ping"))
+	assert.Nil(t, err)
+	b := make([]byte, 4)
+	_, err = io.ReadFull(c, b)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "ping")
+
+	// Stop waits for the active session
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, ap.Stop(ctx), context.DeadlineExceeded)
+	assert.Equal(t, <-served, netplus.ErrProxyStopped)
+
+	c.Close()
+	assert.Nil(t, ap.Stop(context.Background()))
+}
+
+func TestAutoProxyAcceptRateLimit(t *testing.T) {
+	upstream := echoServer(t)
+	defer upstream.Close()
+	ap, ln, _ := startAutoProxy(t, upstream, func(ap *netplus.AutoProxy) {
+		ap.SetAcceptRateLimit(0.001, 2)
+	})
+	defer ap.Stop(context.Background())
+
+	var ok, dropped int
+	for i := 0; i < 4; i++ {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		assert.Nil(t, err)
+		c.Write([]byte("x"))
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(c, make([]byte, 1)); err == nil {
+			ok++
+		} else {
+			dropped++
+		}
+		c.Close()
+	}
+	assert.Equal(t, ok, 2)
+	assert.Equal(t, dropped, 2)
+	assert.Equal(t, ap.Stats().DroppedConnections, int64(2))
+}
+
+func TestAutoProxyAcceptRateLimitReset(t *testing.T) {
+	upstream := echoServer(t)
+	defer upstream.Close()
+	ap, ln, _ := startAutoProxy(t, upstream, func(ap *netplus.AutoProxy) {
+		ap.SetAcceptRateLimit(1000, 10)
+	})
+	defer ap.Stop(context.Background())
+
+	// the limit changes while connections are accepted
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			ap.SetAcceptRateLimit(1000, 10)
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		// connections over the limit may be reset before the dial returns
+		if c, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+			c.Close()
+		}
+	}
+	<-done
+}
+
+func TestAutoProxyPerConnectionTimeout(t *testing.T) {
+	upstream := echoServer(t)
+	defer upstream.Close()
+	ap, ln, _ := startAutoProxy(t, upstream, func(ap *netplus.AutoProxy) {
+		ap.PerConnectionTimeout = 100 * time.Millisecond
+	})
+	defer ap.Stop(context.Background())
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer c.Close()
+
+	// the session keeps being active but still ends after the timeout
+	start := time.Now()
+	b := make([]byte, 1)
+	for err == nil && time.Since(start) < 5*time.Second {
+		if _, err = c.Write(b); err == nil {
+			_, err = io.ReadFull(c, b)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NotNil(t, err)
+	assert.Lt(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestAutoProxyMiddleware(t *testing.T) {
+	upstream := echoServer(t)
+	defer upstream.Close()
+
+	order := make(chan string, 2)
+	reject := errors.New("rejected")
+	ap, ln, _ := startAutoProxy(t, upstream, func(ap *netplus.AutoProxy) {
+		ap.Use(func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+			order <- "first"
+			return conn, nil
+		}, func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+			order <- "second"
+			return nil, reject
+		})
+	})
+	defer ap.Stop(context.Background())
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = c.Read(make([]byte, 1))
+	assert.Equal(t, err, io.EOF)
+	assert.Equal(t, <-order, "first")
+	assert.Equal(t, <-order, "second")
+}
+
+func TestAutoProxyDialFunc(t *testing.T) {
+	upstream := echoServer(t)
+	defer upstream.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	remotes := make(chan string, 1)
+	ap := netplus.NewAutoProxy(ln, func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+		remotes <- conn.RemoteAddr().String()
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", upstream.Addr().String())
+	}, netplus.NewPiper(&recordingLogger{}, time.Minute))
+	go ap.Serve()
+	defer ap.Stop(context.Background())
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer c.Close()
+	assert.Equal(t, <-remotes, c.LocalAddr().String())
+	_, err = c.Write([]byte("ping"))
+	assert.Nil(t, err)
+	b := make([]byte, 4)
+	_, err = io.ReadFull(c, b)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "ping")
+}
+
+func TestAutoProxyRetries(t *testing.T) {
+	upstream := echoServer(t)
+	defer upstream.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	var attempts int32
+	ap := netplus.NewAutoProxy(ln, func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Addr: upstream.Addr(), Err: errors.New("refused")}
+		}
+		var d net.Dialer
+		c, err := d.DialContext(ctx, "tcp", upstream.Addr().String())
+		// an upstream without a remote address is fine too
+		return noAddrConn{c}, err
+	}, netplus.NewPiper(&recordingLogger{}, time.Minute))
+	ap.MaxRetries = 3
+	ap.MaxBackoff = 20 * time.Millisecond
+	go ap.Serve()
+	defer ap.Stop(context.Background())
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer c.Close()
+	_, err = c.Write([]byte("ping"))
+	assert.Nil(t, err)
+	b := make([]byte, 4)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadFull(c, b)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "ping")
+	assert.Equal(t, atomic.LoadInt32(&attempts), int32(3))
+}
+
+// noAddrConn is a connection that does not know its remote address
+type noAddrConn struct {
+	net.Conn
+}
+
+func (noAddrConn) RemoteAddr() net.Addr {
+	return nil
+}
+
+// echoOnce writes msg to c and checks that it comes back
+func echoOnce(t *testing.T, c net.Conn, msg string) {
+	_, err := c.Write([]byte(msg))
+	assert.Nil(t, err)
+	b := make([]byte, len(msg))
+	_, err = io.ReadFull(c, b)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), msg)
+}
+
+func TestAutoProxyListeners(t *testing.T) {
+	upstream := echoServer(t)
+	defer upstream.Close()
+	ap, ln, served := startAutoProxy(t, upstream, nil)
+
+	alias, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	ap.AddListener(alias)
+	assert.Equal(t, ap.Listeners(), []net.Listener{ln, alias})
+
+	c, err := net.Dial("tcp", alias.Addr().String())
+	assert.Nil(t, err)
+	defer c.Close()
+	echoOnce(t, c, "ping")
+
+	// the session outlives the listener it was accepted from
+	ap.RemoveListener(alias)
+	assert.Equal(t, ap.Listeners(), []net.Listener{ln})
+	echoOnce(t, c, "pong")
+	_, err = net.Dial("tcp", alias.Addr().String())
+	assert.NotNil(t, err)
+
+	main, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	echoOnce(t, main, "main")
+	main.Close()
+
+	c.Close()
+	assert.Nil(t, ap.Stop(context.Background()))
+	assert.Equal(t, <-served, netplus.ErrProxyStopped)
+}
@@ -0,0 +1,56 @@
+package netplus
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// BufferPool hands out byte slices of one size, the Piper copies with one and
+// other packages needing the same buffers can share it
+type BufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// defaultPool is the pool of the Pipers without WithBufferPool
+var defaultPool = NewBufferPool(defaultBufferSize)
+
+// NewBufferPool returns a BufferPool of size byte buffers
+func NewBufferPool(size int) *BufferPool {
+	bp := &BufferPool{size: size}
+	bp.pool.New = func() interface{} {
+		atomic.AddUint64(&poolMisses, 1)
+		return make([]byte, size)
+	}
+	return bp
+}
+
+// WithBufferPool makes the copies of the Piper take their buffers from bp
+// instead of the package pool, a nil bp keeps the package pool
+func WithBufferPool(bp *BufferPool) Option {
+	return func(p *Piper) {
+		if bp != nil {
+			p.bufPool = bp
+		}
+	}
+}
+
+// BufferSize returns the size of the buffers of the pool
+func (bp *BufferPool) BufferSize() int {
+	return bp.size
+}
+
+// Get returns a buffer of BufferSize bytes
+func (bp *BufferPool) Get() []byte {
+	atomic.AddUint64(&poolGets, 1)
+	return bp.get()
+}
+
+// Put gives buf back to the pool, buf must not be used afterwards
+// buffers smaller than BufferSize are dropped
+func (bp *BufferPool) Put(buf []byte) {
+	if cap(buf) < bp.size {
+		return
+	}
+	bp.put(buf[:bp.size])
+}
@@ -0,0 +1,42 @@
+package netplus_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestBufferPool(t *testing.T) {
+	bp := netplus.NewBufferPool(1024)
+	assert.Equal(t, bp.BufferSize(), 1024)
+	buf := bp.Get()
+	assert.Len(t, buf, 1024)
+	bp.Put(buf[:10])
+	assert.Len(t, bp.Get(), 1024)
+	bp.Put(make([]byte, 10))
+	assert.Len(t, bp.Get(), 1024)
+}
+
+func TestWithBufferPool(t *testing.T) {
+	piper := netplus.NewPiper(nil, time.Minute, netplus.WithBufferPool(netplus.NewBufferPool(8)))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer server.Close()
+	c := piper.RunAsync(context.Background(), downstream, upstream)
+	go func() {
+		client.Write(make([]byte, 32))
+		client.Close()
+	}()
+	_, err := io.ReadFull(server, make([]byte, 32))
+	assert.Nil(t, err)
+
+	// the copies read into the 8 byte buffers of the pool
+	stats := <-c
+	assert.Equal(t, stats.IterationsUpstream, int64(4))
+}
@@ -0,0 +1,11 @@
+//go:build !nopiperpool
+
+package netplus
+
+func (bp *BufferPool) get() []byte {
+	return bp.pool.Get().([]byte)
+}
+
+func (bp *BufferPool) put(buf []byte) {
+	bp.pool.Put(buf)
+}
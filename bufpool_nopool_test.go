@@ -0,0 +1,14 @@
+//go:build nopiperpool
+
+package netplus
+
+import "testing"
+
+func TestNoPiperPool(t *testing.T) {
+	buf := defaultPool.Get()
+	buf[0] = 1
+	defaultPool.Put(buf)
+	if again := defaultPool.Get(); &again[0] == &buf[0] || again[0] != 0 {
+		t.Fatal("buffer reused with nopiperpool")
+	}
+}
@@ -0,0 +1,72 @@
+package netplus
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Transform wraps the reader of one stage of a PipeChain
+type Transform func(r io.Reader) io.Reader
+
+// PipeChain is a sequence of transforms applied in order on data flowing in one direction
+type PipeChain []Transform
+
+// Reader returns src with every transform of the chain applied, the first one reads src
+func (c PipeChain) Reader(src io.Reader) io.Reader {
+	for _, t := range c {
+		src = t(src)
+	}
+	return src
+}
+
+// NewPipeChain returns src with transforms applied in order, to be used as
+// the source of RunOnce
+func NewPipeChain(src io.Reader, transforms ...Transform) io.Reader {
+	return PipeChain(transforms).Reader(src)
+}
+
+// RunOnce copies src to dst in one direction with the copy loop of Run until
+// src ends, either side fails or ctx is done
+// the idle Timeout and ctx close src and dst when they are io.Closers to
+// unblock the copy, a copy that ends on its own leaves both open
+func (p *Piper) RunOnce(ctx context.Context, src io.Reader, dst io.Writer) (int64, error) {
+	if p.isClosed() {
+		return 0, ErrPiperClosed
+	}
+	ctx, cancel := p.withClose(ctx)
+	defer cancel()
+	s := p.newSession()
+	defer close(s.done)
+	cfg := p.Config()
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 2 * time.Hour
+	}
+	reset := make(chan struct{}, 1)
+	go func() {
+		timer := time.NewTimer(cfg.Timeout)
+		defer timer.Stop()
+		for {
+			select {
+			case _, ok := <-reset:
+				if !ok {
+					return
+				}
+				timer.Reset(cfg.Timeout)
+				continue
+			case <-ctx.Done():
+			case <-timer.C:
+				p.emit(IdleTimeout, s.id, nil)
+			}
+			closeIfCloser(src)
+			closeIfCloser(dst)
+			return
+		}
+	}()
+	written, err := p.copy(ctx, s, src, dst, cfg.BufferSize, reset, DirectionUpstream)
+	if ctx.Err() != nil {
+		// the copy failed on the close
+		err = ctx.Err()
+	}
+	return written, err
+}
@@ -0,0 +1,47 @@
+package netplus_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+// upperReader upper cases ASCII letters
+type upperReader struct {
+	io.Reader
+}
+
+func (r upperReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	copy(b, bytes.ToUpper(b[:n]))
+	return n, err
+}
+
+func TestPipeChain(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	src := netplus.NewPipeChain(strings.NewReader("hello world"),
+		func(r io.Reader) io.Reader { return io.LimitReader(r, 5) },
+		func(r io.Reader) io.Reader { return upperReader{r} },
+	)
+	var dst bytes.Buffer
+	written, err := piper.RunOnce(context.Background(), src, &dst)
+	assert.Nil(t, err)
+	assert.Equal(t, written, int64(5))
+	assert.Equal(t, dst.String(), "HELLO")
+}
+
+func TestRunOnceIdleTimeout(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, 20*time.Millisecond)
+	r, w := io.Pipe()
+	defer w.Close()
+	start := time.Now()
+	_, err := piper.RunOnce(context.Background(), r, io.Discard)
+	assert.NotNil(t, err)
+	assert.Lt(t, int64(time.Since(start)), int64(time.Second))
+}
@@ -0,0 +1,172 @@
+package netplus
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// errNotClientHello is returned when a connection does not start with a TLS ClientHello
+var errNotClientHello = errors.New("not a TLS ClientHello")
+
+// maxTLSRecordLen is the largest TLS plaintext record
+const maxTLSRecordLen = 16384
+
+// clientHello holds the ClientHello fields used by the package
+type clientHello struct {
+	serverName string
+	// the fields of the JA3 fingerprint, in the order they were sent
+	version      uint16
+	ciphers      []uint16
+	extensions   []uint16
+	curves       []uint16
+	pointFormats []uint8
+}
+
+// readClientHello reads the first TLS record from r and parses it as a ClientHello
+// it returns the bytes read even on error so they can be replayed
+// a ClientHello split over several records is parsed up to the end of the first one
+func readClientHello(r io.Reader) (*clientHello, []byte, error) {
+	header := make([]byte, 5)
+	if n, err := io.ReadFull(r, header); err != nil {
+		return nil, header[:n], err
+	}
+	if header[0] != 0x16 {
+		return nil, header, errNotClientHello
+	}
+	length := int(binary.BigEndian.Uint16(header[3:5]))
+	if length == 0 || length > maxTLSRecordLen {
+		return nil, header, errNotClientHello
+	}
+	record := make([]byte, 5+length)
+	copy(record, header)
+	if n, err := io.ReadFull(r, record[5:]); err != nil {
+		return nil, record[:5+n], err
+	}
+	hello, err := parseClientHello(record[5:])
+	return hello, record, err
+}
+
+// parseClientHello parses a handshake message holding a ClientHello
+func parseClientHello(b []byte) (*clientHello, error) {
+	// handshake type and length
+	if len(b) < 4 || b[0] != 0x01 {
+		return nil, errNotClientHello
+	}
+	s := byteString(b[4:])
+	hello := &clientHello{}
+	// version and random
+	var ok bool
+	if hello.version, ok = s.uint16(); !ok || !s.skip(32) {
+		return nil, errNotClientHello
+	}
+	// session id, cipher suites and compression methods
+	if _, ok := s.vector(1); !ok {
+		return nil, errNotClientHello
+	}
+	ciphers, ok := s.vector(2)
+	if !ok {
+		return nil, errNotClientHello
+	}
+	hello.ciphers = ciphers.uint16s()
+	if _, ok := s.vector(1); !ok {
+		return nil, errNotClientHello
+	}
+	if len(s) == 0 {
+		// no extensions
+		return hello, nil
+	}
+	exts, ok := s.vector(2)
+	if !ok && len(s) >= 2 {
+		// truncated by the end of the record
+		exts = s[2:]
+	}
+	for len(exts) >= 4 {
+		typ, _ := exts.uint16()
+		data, ok := exts.vector(2)
+		if !ok {
+			break
+		}
+		hello.extensions = append(hello.extensions, typ)
+		switch typ {
+		case 0:
+			hello.serverName = parseServerName(data)
+		case 10:
+			if groups, ok := data.vector(2); ok {
+				hello.curves = groups.uint16s()
+			}
+		case 11:
+			if formats, ok := data.vector(1); ok {
+				hello.pointFormats = formats
+			}
+		}
+	}
+	return hello, nil
+}
+
+// parseServerName returns the host name of a server_name extension
+func parseServerName(b byteString) string {
+	list, ok := b.vector(2)
+	if !ok {
+		return ""
+	}
+	for len(list) > 0 {
+		typ := list[0]
+		list = list[1:]
+		name, ok := list.vector(2)
+		if !ok {
+			return ""
+		}
+		if typ == 0 {
+			return string(name)
+		}
+	}
+	return ""
+}
+
+// byteString is a cursor over a TLS message
+type byteString []byte
+
+func (s *byteString) skip(n int) bool {
+	if len(*s) < n {
+		return false
+	}
+	*s = (*s)[n:]
+	return true
+}
+
+func (s *byteString) uint16() (uint16, bool) {
+	if len(*s) < 2 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint16(*s)
+	*s = (*s)[2:]
+	return v, true
+}
+
+// uint16s reads the rest of s as a list of uint16
+func (s byteString) uint16s() []uint16 {
+	v := make([]uint16, 0, len(s)/2)
+	for len(s) >= 2 {
+		n, _ := s.uint16()
+		v = append(v, n)
+	}
+	return v
+}
+
+// vector reads a field prefixed by its lenLen bytes long length
+func (s *byteString) vector(lenLen int) (byteString, bool) {
+	if len(*s) < lenLen {
+		return nil, false
+	}
+	var n int
+	for _, c := range (*s)[:lenLen] {
+		n = n<<8 | int(c)
+	}
+	if len(*s) < lenLen+n {
+		return nil, false
+	}
+	v := (*s)[lenLen : lenLen+n]
+	*s = (*s)[lenLen+n:]
+	return v, true
+}
@@ -0,0 +1,38 @@
+package netplus
+
+import "time"
+
+// Clock tells the time to the idle timer of a Piper, see WithClock
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the part of time.Timer the idle timer uses
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// WithClock keeps the idle time of a Piper with c instead of the real clock, for
+// tests that move time on their own, LastActivity and the idle timers go by c
+// and the deadlines of RunConn get the idle window left on c since the
+// connections enforce them on the real clock
+func WithClock(c Clock) Option {
+	return func(p *Piper) {
+		p.clock = c
+	}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
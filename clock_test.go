@@ -0,0 +1,158 @@
+package netplus_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+// FakeClock is a netplus.Clock whose time only moves with Advance
+type FakeClock struct {
+	mux    sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func NewFakeClock() *FakeClock {
+	return &FakeClock{now: time.Now()}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) netplus.Timer {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1), when: c.now.Add(d), active: true}
+	c.timers = append(c.timers, t)
+	t.fire(c.now)
+	return t
+}
+
+// Advance moves the clock by d and fires the timers that are due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.timers {
+		t.fire(c.now)
+	}
+}
+
+// waitTimers waits until n timers have been created
+func (c *FakeClock) waitTimers(n int) {
+	for {
+		c.mux.Lock()
+		created := len(c.timers)
+		c.mux.Unlock()
+		if created >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	c      chan time.Time
+	when   time.Time
+	active bool
+}
+
+// fire sends on the channel of a due timer, the clock has to be locked
+func (t *fakeTimer) fire(now time.Time) {
+	if t.active && !now.Before(t.when) {
+		t.active = false
+		t.c <- now
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+	was := t.active
+	t.active = false
+	return was
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+	was := t.active
+	t.when, t.active = t.clock.now.Add(d), true
+	t.fire(t.clock.now)
+	return was
+}
+
+func TestWithClock(t *testing.T) {
+	clock := NewFakeClock()
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithClock(clock))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	done := piper.RunAsync(context.Background(), downstream, upstream)
+
+	// the idle minute passes on the fake clock only
+	clock.waitTimers(1)
+	clock.Advance(59 * time.Second)
+	select {
+	case <-done:
+		t.Fatal("timed out before the idle timeout")
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.Advance(2 * time.Second)
+	select {
+	case stats := <-done:
+		assert.Lt(t, stats.Duration, time.Second)
+	case <-time.After(time.Second):
+		t.Fatal("no timeout after the idle timeout")
+	}
+}
+
+func TestWithClockRunConn(t *testing.T) {
+	// the fake time is an hour behind, the deadlines of RunConn still leave the idle window
+	clock := NewFakeClock()
+	clock.Advance(-time.Hour)
+	piper := netplus.NewPiper(&recordingLogger{}, 100*time.Millisecond, netplus.WithClock(clock))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	done := make(chan struct{})
+	go func() {
+		piper.RunConn(context.Background(), downstream, upstream)
+		close(done)
+	}()
+	go client.Write([]byte("ping"))
+	b := make([]byte, 4)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := server.Read(b)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "ping")
+
+	// the idle time passes on the fake clock only
+	select {
+	case <-done:
+		t.Fatal("timed out before the idle timeout")
+	case <-time.After(300 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("no timeout after the idle timeout")
+	}
+}
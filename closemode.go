@@ -0,0 +1,171 @@
+package netplus
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// CloseMode selects what happens to one side of a pipe once the other side
+// has finished sending, see WithAsymmetricClose
+type CloseMode int
+
+const (
+	// CloseImmediately closes the side as soon as the other one finishes
+	CloseImmediately CloseMode = iota
+	// CloseAfterDrain keeps reading the side until its EOF and drops what it
+	// sends, the other side has nothing left to receive it
+	CloseAfterDrain
+	// CloseOnEOF half closes the side with CloseWrite and keeps forwarding what
+	// it sends until its EOF, sides without CloseWrite are closed immediately
+	CloseOnEOF
+)
+
+func (m CloseMode) String() string {
+	switch m {
+	case CloseImmediately:
+		return "close immediately"
+	case CloseAfterDrain:
+		return "close after drain"
+	case CloseOnEOF:
+		return "close on eof"
+	}
+	return "unknown close mode"
+}
+
+// WithAsymmetricClose sets the CloseMode of each side, a mode applies when the
+// other side finishes sending without error, the session then ends with the
+// EOF of the side that was kept open or with the idle timeout
+func WithAsymmetricClose(upstreamCloseMode, downstreamCloseMode CloseMode) Option {
+	return func(p *Piper) {
+		p.upstreamCloseMode = upstreamCloseMode
+		p.downstreamCloseMode = downstreamCloseMode
+	}
+}
+
+// WithCloseFunc replaces the closing of both sides at the end of a session,
+// fn gets the upstream as src and the downstream as dst and may hand them back
+// to a pool, send a protocol level goodbye or time the close instead
+// connections with deadlines are handed over once both copies stopped reading
+// and writing them, the others right away with the copies still blocked on them
+func WithCloseFunc(fn func(src, dst io.ReadWriteCloser)) Option {
+	return func(p *Piper) {
+		p.closeFunc = fn
+	}
+}
+
+// WithFlushOnClose calls fn with the downstream right before it is closed at
+// the end of a session so a protocol can finish what it was sending, such as
+// the terminating chunk of an HTTP response, the close goes on when fn fails
+// and waits for it, so fn should bound its writes with a deadline
+// fn waits for a write of the copy to downstream in progress, up to a second,
+// and the copy writes nothing to downstream after it
+func WithFlushOnClose(fn func(conn io.ReadWriteCloser) error) Option {
+	return func(p *Piper) {
+		p.flushOnClose = fn
+	}
+}
+
+// flushWait bounds the wait of WithFlushOnClose for a write to downstream in progress
+const flushWait = time.Second
+
+// errFlushWriteStuck is the error of a WithFlushOnClose hook skipped for a stuck write
+var errFlushWriteStuck = errors.New("write to downstream still in progress")
+
+// flushDownstream runs the WithFlushOnClose hook on the downstream dst once it
+// is its turn to write, see downstreamWriter
+func (p *Piper) flushDownstream(s *Session, dst io.ReadWriteCloser) {
+	if p.flushOnClose == nil {
+		return
+	}
+	t := time.NewTimer(flushWait)
+	defer t.Stop()
+	select {
+	case s.downstreamTurn <- struct{}{}:
+	case <-t.C:
+		p.logError(s.logArgs("netplus: flushing downstream before close:", errFlushWriteStuck)...)
+		s.nonFatal(errFlushWriteStuck)
+		return
+	}
+	s.flushed = true
+	err := p.flushOnClose(dst)
+	<-s.downstreamTurn
+	if err != nil {
+		p.logError(s.logArgs("netplus: flushing downstream before close:", err)...)
+		s.nonFatal(err)
+	}
+}
+
+// downstreamWriter is the write path of the copy to downstream with
+// WithFlushOnClose, its writes take turns with the hook and fail once it ran
+type downstreamWriter struct {
+	w io.Writer
+	s *Session
+}
+
+func (d *downstreamWriter) Write(b []byte) (int, error) {
+	d.s.downstreamTurn <- struct{}{}
+	defer func() { <-d.s.downstreamTurn }()
+	if d.s.flushed {
+		return 0, io.ErrClosedPipe
+	}
+	return d.w.Write(b)
+}
+
+// holdOpen applies the CloseMode of the side that is still sending after the
+// copy in direction finished cleanly, it returns false when both sides have to
+// be closed right away
+func (p *Piper) holdOpen(s *Session, finished Direction, upstream, downstream io.ReadWriteCloser) bool {
+	mode, side, remaining := p.downstreamCloseMode, downstream, DirectionUpstream
+	if finished == DirectionUpstream {
+		mode, side, remaining = p.upstreamCloseMode, upstream, DirectionDownstream
+	}
+	switch mode {
+	case CloseAfterDrain:
+		atomic.StoreInt32(&s.draining[remaining], 1)
+		return true
+	case CloseOnEOF:
+		cw, ok := side.(interface{ CloseWrite() error })
+		return ok && cw.CloseWrite() == nil
+	}
+	return false
+}
+
+// drains reports whether the copy in dir keeps reading with CloseAfterDrain
+// once the side it writes to is gone
+func (p *Piper) drains(dir Direction) bool {
+	if dir == DirectionUpstream {
+		return p.downstreamCloseMode == CloseAfterDrain
+	}
+	return p.upstreamCloseMode == CloseAfterDrain
+}
+
+// deadliner is a connection whose reads and writes can be stopped with a deadline
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// stopCopies expires the deadlines of conns to stop the copies of s on them
+// without closing, it reports false when a connection has no deadlines
+// a connection refusing the deadline because it is closed ends its copies anyway
+func (s *Session) stopCopies(conns ...io.ReadWriteCloser) bool {
+	for _, c := range conns {
+		if _, ok := c.(deadliner); !ok {
+			return false
+		}
+	}
+	atomic.StoreInt32(&s.stopping, 1)
+	atomic.StoreInt32(&s.stoppingWrites, 1)
+	for i, c := range conns {
+		if err := c.(deadliner).SetDeadline(hijackDeadline); err != nil && !isClosedErr(err) {
+			for _, c := range conns[:i] {
+				c.(deadliner).SetDeadline(time.Time{})
+			}
+			atomic.StoreInt32(&s.stopping, 0)
+			atomic.StoreInt32(&s.stoppingWrites, 0)
+			return false
+		}
+	}
+	return true
+}
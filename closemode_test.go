@@ -0,0 +1,212 @@
+package netplus_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestCloseAfterDrain(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute,
+		netplus.WithAsymmetricClose(netplus.CloseImmediately, netplus.CloseAfterDrain))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	go func() {
+		server.Write([]byte("resp"))
+		server.Close()
+	}()
+	done := piper.RunAsync(context.Background(), downstream, upstream)
+
+	_, err := io.ReadFull(client, make([]byte, 4))
+	assert.Nil(t, err)
+	// the downstream is still open for the acknowledgement
+	_, err = client.Write([]byte("ack"))
+	assert.Nil(t, err)
+	client.Close()
+
+	stats := <-done
+	assert.Nil(t, stats.Err)
+	assert.Equal(t, stats.BytesDownstream, int64(4))
+	assert.Equal(t, stats.BytesUpstream, int64(0))
+}
+
+func TestCloseOnEOF(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute,
+		netplus.WithAsymmetricClose(netplus.CloseImmediately, netplus.CloseOnEOF))
+
+	client, downstream := tcpPair(t)
+	upstream, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+	done := piper.RunAsync(context.Background(), downstream, upstream)
+
+	server.Write([]byte("resp"))
+	server.(*net.TCPConn).CloseWrite()
+
+	// the upstream EOF reaches the client as a half close
+	b, err := io.ReadAll(client)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "resp")
+	client.Write([]byte("ack"))
+	client.(*net.TCPConn).CloseWrite()
+
+	b, err = io.ReadAll(server)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "ack")
+
+	stats := <-done
+	assert.Nil(t, stats.Err)
+	assert.Equal(t, stats.BytesUpstream, int64(3))
+}
+
+func TestCloseFunc(t *testing.T) {
+	var src, dst io.ReadWriteCloser
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute,
+		netplus.WithCloseFunc(func(s, d io.ReadWriteCloser) {
+			src, dst = s, d
+			d.Write([]byte("bye"))
+			s.Close()
+			d.Close()
+		}))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	done := piper.RunAsync(context.Background(), downstream, upstream)
+	server.Close()
+
+	// the goodbye of the close func is the last thing the client reads
+	b, err := io.ReadAll(client)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "bye")
+
+	<-done
+	assert.Equal(t, src, upstream)
+	assert.Equal(t, dst, downstream)
+}
+
+func TestCloseFuncKeepsConns(t *testing.T) {
+	handed := make(chan struct{})
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute,
+		netplus.WithCloseFunc(func(s, d io.ReadWriteCloser) {
+			close(handed)
+		}))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	done := piper.RunAsync(context.Background(), downstream, upstream)
+	go func() {
+		client.Write([]byte("ping"))
+		client.Close()
+	}()
+	b := make([]byte, 4)
+	_, err := io.ReadFull(server, b)
+	assert.Nil(t, err)
+	stats := <-done
+	assert.Nil(t, stats.Err)
+	<-handed
+
+	// no copy is left reading the upstream fn kept open
+	go server.Write([]byte("late"))
+	upstream.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadFull(upstream, b)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "late")
+}
+
+func TestFlushOnClose(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute,
+		netplus.WithFlushOnClose(func(conn io.ReadWriteCloser) error {
+			_, err := conn.Write([]byte("0\r\n\r\n"))
+			return err
+		}))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	done := piper.RunAsync(context.Background(), downstream, upstream)
+	go func() {
+		server.Write([]byte("4\r\ndata\r\n"))
+		server.Close()
+	}()
+
+	b, err := io.ReadAll(client)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "4\r\ndata\r\n0\r\n\r\n")
+	<-done
+}
+
+// overlapConn records whether two of its writes ever ran at the same time
+type overlapConn struct {
+	net.Conn
+	inflight   int32
+	overlapped int32
+}
+
+func (c *overlapConn) Write(b []byte) (int, error) {
+	if atomic.AddInt32(&c.inflight, 1) > 1 {
+		atomic.StoreInt32(&c.overlapped, 1)
+	}
+	defer atomic.AddInt32(&c.inflight, -1)
+	time.Sleep(time.Millisecond)
+	return c.Conn.Write(b)
+}
+
+func TestFlushOnCloseSerialized(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute,
+		netplus.WithFlushOnClose(func(conn io.ReadWriteCloser) error {
+			_, err := conn.Write([]byte("END"))
+			return err
+		}))
+
+	for i := 0; i < 10; i++ {
+		client, pipe := net.Pipe()
+		downstream := &overlapConn{Conn: pipe}
+		upstream, server := net.Pipe()
+		go func() {
+			for {
+				if _, err := server.Write([]byte("data")); err != nil {
+					return
+				}
+			}
+		}()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := piper.RunAsync(ctx, downstream, upstream)
+		time.AfterFunc(20*time.Millisecond, cancel)
+
+		// the hook writes last and alone
+		b, _ := io.ReadAll(client)
+		<-done
+		assert.Equal(t, atomic.LoadInt32(&downstream.overlapped), int32(0))
+		assert.True(t, strings.HasSuffix(string(b), "dataEND"), string(b))
+		server.Close()
+	}
+}
+
+func TestFlushOnCloseError(t *testing.T) {
+	fail := errors.New("flush failed")
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute,
+		netplus.WithFlushOnClose(func(conn io.ReadWriteCloser) error {
+			return fail
+		}))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	done, errs := piper.RunAsyncWithErrors(context.Background(), downstream, upstream)
+	server.Close()
+
+	// the downstream is closed all the same
+	_, err := io.ReadAll(client)
+	assert.Nil(t, err)
+	assert.Equal(t, <-errs, fail)
+	<-done
+}
@@ -0,0 +1,125 @@
+package netplus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidConfig is returned by UpdateConfig for configurations that cannot be applied
+var ErrInvalidConfig = errors.New("invalid piper config")
+
+// PiperConfig holds the Piper settings that can be changed while it is running
+type PiperConfig struct {
+	// Timeout is the idle timeout, zero means two hours
+	Timeout time.Duration `json:"timeout"`
+	// BufferSize is the size of the copy buffer, zero means 32 KB
+	BufferSize int `json:"buffer_size"`
+	// UpstreamRateLimit caps the bytes per second copied from downstream to upstream, zero means unlimited
+	UpstreamRateLimit float64 `json:"upstream_rate_limit"`
+	// DownstreamRateLimit caps the bytes per second copied from upstream to downstream, zero means unlimited
+	DownstreamRateLimit float64 `json:"downstream_rate_limit"`
+}
+
+// UnmarshalJSON accepts the timeout either as nanoseconds or as a duration string like "30s"
+func (c *PiperConfig) UnmarshalJSON(b []byte) error {
+	type plain PiperConfig
+	var v struct {
+		plain
+		Timeout json.RawMessage `json:"timeout"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*c = PiperConfig(v.plain)
+	if len(v.Timeout) == 0 {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(v.Timeout, &s); err != nil {
+		return json.Unmarshal(v.Timeout, &c.Timeout)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	c.Timeout = d
+	return nil
+}
+
+// UpdateConfig applies cfg to every future Run call
+// sessions that are already running keep their timeout and buffer size
+// but pick up the new rate limits immediately
+func (p *Piper) UpdateConfig(cfg PiperConfig) error {
+	switch {
+	case cfg.Timeout < 0:
+		return fmt.Errorf("%w: negative timeout", ErrInvalidConfig)
+	case cfg.BufferSize < 0:
+		return fmt.Errorf("%w: negative buffer size", ErrInvalidConfig)
+	case cfg.UpstreamRateLimit < 0 || cfg.DownstreamRateLimit < 0:
+		return fmt.Errorf("%w: negative rate limit", ErrInvalidConfig)
+	}
+	p.config.Store(&cfg)
+	return nil
+}
+
+// Config returns the configuration used by new Run calls
+// until UpdateConfig is called it is derived from the Piper fields
+func (p *Piper) Config() PiperConfig {
+	if cfg, _ := p.config.Load().(*PiperConfig); cfg != nil {
+		return *cfg
+	}
+	return PiperConfig{
+		Timeout: p.Timeout,
+	}
+}
+
+func (c *PiperConfig) rateLimit(dir Direction) float64 {
+	if dir == DirectionUpstream {
+		return c.UpstreamRateLimit
+	}
+	return c.DownstreamRateLimit
+}
+
+type contextKey struct{}
+
+// WithSession returns a copy of ctx carrying cfg, Run calls with that context use
+// the non-zero fields of cfg instead of the Piper configuration
+func WithSession(ctx context.Context, cfg PiperConfig) context.Context {
+	return context.WithValue(ctx, contextKey{}, cfg)
+}
+
+// SessionFromContext returns the configuration stored in ctx by WithSession
+func SessionFromContext(ctx context.Context) (PiperConfig, bool) {
+	cfg, ok := ctx.Value(contextKey{}).(PiperConfig)
+	return cfg, ok
+}
+
+// merge returns c with every non-zero field of o applied on top
+func (c PiperConfig) merge(o PiperConfig) PiperConfig {
+	if o.Timeout > 0 {
+		c.Timeout = o.Timeout
+	}
+	if o.BufferSize > 0 {
+		c.BufferSize = o.BufferSize
+	}
+	if o.UpstreamRateLimit > 0 {
+		c.UpstreamRateLimit = o.UpstreamRateLimit
+	}
+	if o.DownstreamRateLimit > 0 {
+		c.DownstreamRateLimit = o.DownstreamRateLimit
+	}
+	return c
+}
+
+// sessionConfig returns the configuration whose rate limits apply to s, the
+// override of s merged over the live config so UpdateConfig still reaches it
+func (p *Piper) sessionConfig(s *Session) PiperConfig {
+	cfg := p.Config()
+	if s.override != nil {
+		cfg = cfg.merge(*s.override)
+	}
+	return cfg
+}
@@ -0,0 +1,129 @@
+package netplus_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/log"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestUpdateConfig(t *testing.T) {
+	logger := log.NewZero(os.Stderr)
+	piper := netplus.NewPiper(logger, time.Minute)
+	assert.Equal(t, piper.Config().Timeout, time.Minute)
+
+	err := piper.UpdateConfig(netplus.PiperConfig{Timeout: -time.Second})
+	assert.True(t, errors.Is(err, netplus.ErrInvalidConfig))
+
+	err = piper.UpdateConfig(netplus.PiperConfig{Timeout: time.Second, DownstreamRateLimit: 64 * 1024})
+	assert.Nil(t, err)
+	assert.Equal(t, piper.Config().Timeout, time.Second)
+}
+
+func TestUpdateConfigRateLimit(t *testing.T) {
+	// a session with its own timeout still gets the rate limits of UpdateConfig
+	for _, ctx := range []context.Context{
+		context.Background(),
+		netplus.WithSession(context.Background(), netplus.PiperConfig{Timeout: time.Minute}),
+	} {
+		logger := log.NewZero(os.Stderr)
+		piper := netplus.NewPiper(logger, time.Minute)
+
+		client, downstream := net.Pipe()
+		upstream, server := net.Pipe()
+		go piper.Run(ctx, downstream, upstream)
+
+		go func() {
+			b := make([]byte, 1024)
+			for {
+				if _, err := server.Write(b); err != nil {
+					return
+				}
+			}
+		}()
+
+		// unlimited at first, then throttled while the session is running
+		_, err := io.ReadFull(client, make([]byte, 256*1024))
+		assert.Nil(t, err)
+
+		err = piper.UpdateConfig(netplus.PiperConfig{Timeout: time.Minute, DownstreamRateLimit: 32 * 1024})
+		assert.Nil(t, err)
+
+		// the first second worth of bytes is the burst
+		start := time.Now()
+		_, err = io.ReadFull(client, make([]byte, 64*1024))
+		assert.Nil(t, err)
+		elapsed := time.Since(start)
+		assert.Ge(t, int64(elapsed), int64(500*time.Millisecond), elapsed)
+		client.Close()
+	}
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	logger := log.NewZero(os.Stderr)
+	piper := netplus.NewPiper(logger, time.Minute)
+
+	path := t.TempDir() + "/piper.json"
+	err := os.WriteFile(path, []byte(`{"timeout":"30s"}`), 0o600)
+	assert.Nil(t, err)
+
+	reloaded := make(chan netplus.PiperConfig, 4)
+	piper.OnConfigReloaded = func(old, new netplus.PiperConfig) {
+		reloaded <- new
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- piper.WatchConfigFile(path, ctx)
+	}()
+
+	cfg := <-reloaded
+	assert.Equal(t, cfg.Timeout, 30*time.Second)
+
+	// a broken file keeps the current config
+	err = os.WriteFile(path, []byte(`{"timeout":`), 0o600)
+	assert.Nil(t, err)
+	time.Sleep(1500 * time.Millisecond)
+	assert.Equal(t, piper.Config().Timeout, 30*time.Second)
+
+	err = os.WriteFile(path, []byte(`{"timeout":"1m","buffer_size":4096}`), 0o600)
+	assert.Nil(t, err)
+	cfg = <-reloaded
+	assert.Equal(t, cfg.Timeout, time.Minute)
+	assert.Equal(t, cfg.BufferSize, 4096)
+
+	cancel()
+	assert.Equal(t, <-done, context.Canceled)
+}
+
+func TestSessionFromContext(t *testing.T) {
+	_, ok := netplus.SessionFromContext(context.Background())
+	assert.False(t, ok)
+
+	ctx := netplus.WithSession(context.Background(), netplus.PiperConfig{Timeout: 50 * time.Millisecond})
+	cfg, ok := netplus.SessionFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, cfg.Timeout, 50*time.Millisecond)
+
+	logger := log.NewZero(os.Stderr)
+	piper := netplus.NewPiper(logger, time.Minute)
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// the context timeout replaces the one minute Piper timeout
+	start := time.Now()
+	piper.Run(ctx, downstream, upstream)
+	assert.Lt(t, int64(time.Since(start)), int64(time.Second))
+	assert.Equal(t, piper.Config().Timeout, time.Minute)
+}
@@ -0,0 +1,68 @@
+package netplus
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// configPollInterval is how often WatchConfigFile checks the file for changes
+var configPollInterval = time.Second
+
+// WatchConfigFile loads a JSON PiperConfig from path and reloads it with
+// UpdateConfig every time the file changes, until ctx is done
+// the file is polled for modification time and size changes
+// a file that cannot be read or parsed on reload is logged and the current config is kept
+// it returns the error of the initial load, or ctx.Err() once ctx is done
+func (p *Piper) WatchConfigFile(path string, ctx context.Context) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := p.reloadConfigFile(path); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		nfi, err := os.Stat(path)
+		if err != nil {
+			p.logWarn("netplus: config file", path, "stat failed:", err)
+			continue
+		}
+		if nfi.ModTime().Equal(fi.ModTime()) && nfi.Size() == fi.Size() {
+			continue
+		}
+		fi = nfi
+		if err := p.reloadConfigFile(path); err != nil {
+			p.logWarn("netplus: config file", path, "not reloaded:", err)
+		}
+	}
+}
+
+func (p *Piper) reloadConfigFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg PiperConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return err
+	}
+	old := p.Config()
+	if err := p.UpdateConfig(cfg); err != nil {
+		return err
+	}
+	if p.OnConfigReloaded != nil {
+		p.OnConfigReloaded(old, cfg)
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package netplus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is returned by ConnPool.Get once the pool is closed
+var ErrPoolClosed = errors.New("connection pool closed")
+
+// ErrConnUnhealthy is returned by ConnPool.Put for connections that failed the health check
+var ErrConnUnhealthy = errors.New("connection unhealthy")
+
+// healthCheckTimeout is how long the default health check waits for a read to block
+const healthCheckTimeout = time.Millisecond
+
+// ConnPool keeps idle connections created by a factory for reuse
+// at most size connections exist at once, idle or handed out by Get
+type ConnPool struct {
+	factory func(ctx context.Context) (io.ReadWriteCloser, error)
+	slots   chan struct{}
+	idle    chan idleConn
+	healthy func(c io.ReadWriteCloser) bool
+
+	mux        sync.Mutex
+	closed     bool
+	done       chan struct{}
+	maxIdleAge time.Duration
+	stopEvict  chan struct{}
+}
+
+// idleConn is a connection waiting in the pool since it was put back
+type idleConn struct {
+	c     io.ReadWriteCloser
+	since time.Time
+}
+
+// PoolOption configures a ConnPool created by NewConnPool
+type PoolOption func(*ConnPool)
+
+// WithHealthCheck replaces the default health check, a read with a short deadline,
+// with fn, for instance an application level ping
+// fn returns false for connections that must not be reused
+func WithHealthCheck(fn func(c io.ReadWriteCloser) bool) PoolOption {
+	return func(cp *ConnPool) {
+		cp.healthy = fn
+	}
+}
+
+// NewConnPool returns a ConnPool creating connections with factory, up to size at once
+func NewConnPool(factory func(ctx context.Context) (io.ReadWriteCloser, error), size int, opts ...PoolOption) *ConnPool {
+	if size < 1 {
+		size = 1
+	}
+	cp := &ConnPool{
+		factory: factory,
+		slots:   make(chan struct{}, size),
+		idle:    make(chan idleConn, size),
+		healthy: connHealthy,
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(cp)
+	}
+	return cp
+}
+
+// Get returns an idle connection, or a new one when there is none and the pool is not full
+// otherwise it blocks until a connection is put back or freed, or ctx is done
+// idle connections are health checked first, the ones failing are closed and
+// replaced, such as half-open connections whose peer closed while they were idle
+func (cp *ConnPool) Get(ctx context.Context) (io.ReadWriteCloser, error) {
+	// prefer idle connections over new ones while there are any
+	for len(cp.idle) > 0 {
+		select {
+		case ic := <-cp.idle:
+			if cp.checkIdle(ic) {
+				return ic.c, nil
+			}
+		default:
+		}
+	}
+	for {
+		select {
+		case ic := <-cp.idle:
+			if cp.checkIdle(ic) {
+				return ic.c, nil
+			}
+		case cp.slots <- struct{}{}:
+			if cp.isClosed() {
+				<-cp.slots
+				return nil, ErrPoolClosed
+			}
+			c, err := cp.factory(ctx)
+			if err != nil {
+				<-cp.slots
+				return nil, err
+			}
+			return c, nil
+		case <-cp.done:
+			return nil, ErrPoolClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// checkIdle reports whether the idle connection ic can be handed out, it has
+// to be healthy and younger than the max idle age
+// it is closed and its slot freed when it cannot
+func (cp *ConnPool) checkIdle(ic idleConn) bool {
+	if !cp.expired(ic, time.Now()) && cp.healthy(ic.c) {
+		return true
+	}
+	ic.c.Close()
+	<-cp.slots
+	return false
+}
+
+func (cp *ConnPool) expired(ic idleConn, now time.Time) bool {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+	return cp.maxIdleAge > 0 && now.Sub(ic.since) > cp.maxIdleAge
+}
+
+// Put returns c to the pool after checking it is still healthy
+// unhealthy connections are closed and their slot is freed, Put then returns ErrConnUnhealthy
+// c must have been returned by Get, a Piper created WithKeepUpstreamOpen leaves it
+// open after Run when the downstream finished cleanly
+func (cp *ConnPool) Put(c io.ReadWriteCloser) error {
+	if !cp.healthy(c) {
+		c.Close()
+		<-cp.slots
+		return ErrConnUnhealthy
+	}
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+	if cp.closed {
+		<-cp.slots
+		return c.Close()
+	}
+	cp.idle <- idleConn{c, time.Now()}
+	return nil
+}
+
+// SetMaxIdleAge closes the connections idle for longer than d, they are looked
+// for every d/2 in the background, d <= 0 stops it
+func (cp *ConnPool) SetMaxIdleAge(d time.Duration) {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+	if cp.stopEvict != nil {
+		close(cp.stopEvict)
+		cp.stopEvict = nil
+	}
+	cp.maxIdleAge = d
+	if d <= 0 || cp.closed {
+		return
+	}
+	stop := make(chan struct{})
+	cp.stopEvict = stop
+	go func() {
+		t := time.NewTicker(d / 2)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				cp.evict(now)
+			case <-stop:
+				return
+			case <-cp.done:
+				return
+			}
+		}
+	}()
+}
+
+// evict closes the idle connections that are too old as of now, the others go
+// back to the pool unless it was closed
+func (cp *ConnPool) evict(now time.Time) {
+	for n := len(cp.idle); n > 0; n-- {
+		select {
+		case ic := <-cp.idle:
+			if cp.expired(ic, now) {
+				ic.c.Close()
+				<-cp.slots
+				continue
+			}
+			// Close may have drained the pool meanwhile, as in Put
+			cp.mux.Lock()
+			if cp.closed {
+				ic.c.Close()
+				<-cp.slots
+			} else {
+				cp.idle <- ic
+			}
+			cp.mux.Unlock()
+		default:
+			return
+		}
+	}
+}
+
+// Len returns the number of connections of the pool, idle or handed out by Get
+func (cp *ConnPool) Len() int {
+	return len(cp.slots)
+}
+
+// IdleLen returns the number of idle connections in the pool
+func (cp *ConnPool) IdleLen() int {
+	return len(cp.idle)
+}
+
+// Close closes the idle connections, Get fails with ErrPoolClosed afterwards
+// and connections put back are closed
+func (cp *ConnPool) Close() error {
+	cp.mux.Lock()
+	if cp.closed {
+		cp.mux.Unlock()
+		return nil
+	}
+	cp.closed = true
+	close(cp.done)
+	cp.mux.Unlock()
+	for {
+		select {
+		case ic := <-cp.idle:
+			ic.c.Close()
+			<-cp.slots
+		default:
+			return nil
+		}
+	}
+}
+
+// take removes a healthy idle connection from the pool for good and frees its slot
+func (cp *ConnPool) take() (io.ReadWriteCloser, bool) {
+	for {
+		select {
+		case ic := <-cp.idle:
+			if cp.checkIdle(ic) {
+				<-cp.slots
+				return ic.c, true
+			}
+		default:
+			return nil, false
+		}
+	}
+}
+
+func (cp *ConnPool) isClosed() bool {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+	return cp.closed
+}
+
+// connHealthy reads from c with a short deadline, a connection is healthy when
+// the read times out, data or any other error means the peer closed or is out of sync
+// connections without read deadlines are assumed healthy
+func connHealthy(c io.ReadWriteCloser) bool {
+	rd, ok := c.(interface{ SetReadDeadline(time.Time) error })
+	if !ok {
+		return true
+	}
+	if err := rd.SetReadDeadline(time.Now().Add(healthCheckTimeout)); err != nil {
+		return errors.Is(err, os.ErrNoDeadline)
+	}
+	defer rd.SetReadDeadline(time.Time{})
+	var b [1]byte
+	n, err := c.Read(b[:])
+	if n > 0 {
+		return false
+	}
+	var ne interface{ Timeout() bool }
+	return errors.As(err, &ne) && ne.Timeout()
+}
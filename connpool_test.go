@@ -0,0 +1,226 @@
+package netplus_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestConnPool(t *testing.T) {
+	upstream := echoServer(t)
+	defer upstream.Close()
+	dials := 0
+	pool := netplus.NewConnPool(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		dials++
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", upstream.Addr().String())
+	}, 1)
+	defer pool.Close()
+
+	c, err := pool.Get(context.Background())
+	assert.Nil(t, err)
+
+	// the pool is full
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = pool.Get(ctx)
+	assert.Equal(t, err, context.DeadlineExceeded)
+
+	assert.Nil(t, pool.Put(c))
+	c2, err := pool.Get(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, c2, c)
+	assert.Equal(t, dials, 1)
+
+	// a closed connection is not reused
+	c2.Close()
+	assert.Equal(t, pool.Put(c2), netplus.ErrConnUnhealthy)
+	c3, err := pool.Get(context.Background())
+	assert.Nil(t, err)
+	assert.NotEqual(t, c3, c2)
+	assert.Equal(t, dials, 2)
+
+	pool.Close()
+	assert.Nil(t, pool.Put(c3))
+	_, err = pool.Get(context.Background())
+	assert.Equal(t, err, netplus.ErrPoolClosed)
+}
+
+func TestConnPoolHealthCheck(t *testing.T) {
+	upstream := echoServer(t)
+	defer upstream.Close()
+	dials := 0
+	healthy := true
+	pool := netplus.NewConnPool(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		dials++
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", upstream.Addr().String())
+	}, 1, netplus.WithHealthCheck(func(c io.ReadWriteCloser) bool {
+		return healthy
+	}))
+	defer pool.Close()
+
+	c, err := pool.Get(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, pool.Put(c))
+
+	// the idle connection turns unhealthy while in the pool
+	healthy = false
+	c2, err := pool.Get(context.Background())
+	assert.Nil(t, err)
+	assert.NotEqual(t, c2, c)
+	assert.Equal(t, dials, 2)
+	c2.Close()
+}
+
+func TestConnPoolNoDeadlines(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	pool := netplus.NewConnPool(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		return netplus.WrapAsConn(rwc{a}, nil, nil), nil
+	}, 1)
+	defer pool.Close()
+
+	// a connection without deadlines is assumed healthy instead of read from
+	c, err := pool.Get(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, pool.Put(c))
+}
+
+func TestConnPoolHalfOpen(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+	pool := netplus.NewConnPool(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", ln.Addr().String())
+	}, 1)
+	defer pool.Close()
+
+	c, err := pool.Get(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, pool.Put(c))
+
+	// the peer closes while the connection is idle
+	(<-accepted).Close()
+	time.Sleep(20 * time.Millisecond)
+	c2, err := pool.Get(context.Background())
+	assert.Nil(t, err)
+	assert.NotEqual(t, c2, c)
+	c2.Close()
+}
+
+func TestConnPoolRun(t *testing.T) {
+	upstream := echoServer(t)
+	defer upstream.Close()
+	dials := 0
+	pool := netplus.NewConnPool(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		dials++
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", upstream.Addr().String())
+	}, 1)
+	defer pool.Close()
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithKeepUpstreamOpen(true))
+
+	for _, msg := range []string{"ping", "pong"} {
+		up, err := pool.Get(context.Background())
+		assert.Nil(t, err)
+		client, downstream := net.Pipe()
+		go func() {
+			client.Write([]byte(msg))
+			b := make([]byte, len(msg))
+			io.ReadFull(client, b)
+			client.Close()
+		}()
+		_, err = piper.Run(context.Background(), downstream, up)
+		assert.Nil(t, err)
+		assert.Nil(t, pool.Put(up))
+	}
+	assert.Equal(t, dials, 1)
+}
+
+// brokenUpstream fails its read once the read is stopped with a deadline
+type brokenUpstream struct {
+	net.Conn
+	stopped chan struct{}
+	once    sync.Once
+	closed  int32
+}
+
+func (c *brokenUpstream) Read(p []byte) (int, error) {
+	<-c.stopped
+	return 0, errors.New("upstream broken")
+}
+
+func (c *brokenUpstream) SetReadDeadline(t time.Time) error {
+	if !t.IsZero() && t.Before(time.Now()) {
+		c.once.Do(func() { close(c.stopped) })
+	}
+	return nil
+}
+
+func (c *brokenUpstream) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return c.Conn.Close()
+}
+
+func TestKeepUpstreamOpenFailed(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithKeepUpstreamOpen(true))
+	up, server := net.Pipe()
+	go io.Copy(io.Discard, server)
+	upstream := &brokenUpstream{Conn: up, stopped: make(chan struct{})}
+
+	client, downstream := net.Pipe()
+	go func() {
+		client.Write([]byte("ping"))
+		client.Close()
+	}()
+
+	// an upstream failing for another reason than the deadline is not left open
+	_, err := piper.Run(context.Background(), downstream, upstream)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "upstream broken")
+	assert.Equal(t, atomic.LoadInt32(&upstream.closed), int32(1))
+}
+
+func TestConnPoolMaxIdleAge(t *testing.T) {
+	pool := netplus.NewConnPool(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		c, _ := net.Pipe()
+		return c, nil
+	}, 2, netplus.WithHealthCheck(func(c io.ReadWriteCloser) bool { return true }))
+	defer pool.Close()
+	pool.SetMaxIdleAge(40 * time.Millisecond)
+
+	c, err := pool.Get(context.Background())
+	assert.Nil(t, err)
+	_, err = pool.Get(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, pool.Put(c))
+	assert.Equal(t, pool.Len(), 2)
+	assert.Equal(t, pool.IdleLen(), 1)
+
+	// the idle one is closed, the one handed out is left alone
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, pool.Len(), 1)
+	assert.Equal(t, pool.IdleLen(), 0)
+	_, err = c.Write([]byte("x"))
+	assert.Equal(t, err, io.ErrClosedPipe)
+}
@@ -0,0 +1,10 @@
+//go:build darwin || dragonfly || freebsd || openbsd
+
+package netplus
+
+import "syscall"
+
+// TCP_NOPUSH is the BSD counterpart of TCP_CORK
+func setCorkFD(fd uintptr, on bool) error {
+	return setsockoptIntFD(fd, syscall.IPPROTO_TCP, syscall.TCP_NOPUSH, boolInt(on))
+}
@@ -0,0 +1,7 @@
+package netplus
+
+import "syscall"
+
+func setCorkFD(fd uintptr, on bool) error {
+	return setsockoptIntFD(fd, syscall.IPPROTO_TCP, syscall.TCP_CORK, boolInt(on))
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !openbsd
+
+package netplus
+
+func setCorkFD(fd uintptr, on bool) error {
+	return errSockoptUnsupported
+}
@@ -117,3 +117,27 @@ func (cc *CounterConn) Write(b []byte) (int, error) {
 	atomic.AddInt64(&cc.Cap, -1)
 	return n, err
 }
+
+// NewCountingConn returns inner adding the bytes of every Read and Write to
+// *counter atomically, connections sharing counter add up to one quota such as
+// the traffic of a tenant
+func NewCountingConn(inner net.Conn, counter *int64) net.Conn {
+	return &countingConn{Conn: inner, counter: counter}
+}
+
+type countingConn struct {
+	net.Conn
+	counter *int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(c.counter, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(c.counter, int64(n))
+	return n, err
+}
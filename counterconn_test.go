@@ -3,6 +3,7 @@ package netplus
 import (
 	"net"
 	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -81,3 +82,25 @@ func TestCounterListener_Accept(t *testing.T) {
 		})
 	}
 }
+
+func TestCountingConn(t *testing.T) {
+	var tenant int64
+	a1, b1 := net.Pipe()
+	a2, b2 := net.Pipe()
+	defer b1.Close()
+	defer b2.Close()
+	c1 := NewCountingConn(a1, &tenant)
+	c2 := NewCountingConn(a2, &tenant)
+
+	go b1.Write([]byte("hello"))
+	go b2.Read(make([]byte, 3))
+	if _, err := c1.Read(make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c2.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt64(&tenant); got != 8 {
+		t.Errorf("shared counter = %d, want 8", got)
+	}
+}
@@ -0,0 +1,44 @@
+package netplus
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// SetDeadlockTimeout closes sessions with a write that has not returned for d
+// and makes their Run fail with ErrDeadlockDetected, a last resort for peers
+// that stop reading while the other direction keeps the idle timer going
+// it applies to the sessions started afterwards, zero turns it off
+func (p *Piper) SetDeadlockTimeout(d time.Duration) {
+	atomic.StoreInt64(&p.deadlockTimeout, int64(d))
+}
+
+// watchDeadlock checks the writes of s every quarter of d until ctx is done or
+// the returned stop is called, it calls onDeadlock once for a stuck write
+func (p *Piper) watchDeadlock(ctx context.Context, s *Session, d time.Duration, onDeadlock func()) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(d / 4)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				for dir := range s.writeStart {
+					start := atomic.LoadInt64(&s.writeStart[dir])
+					if start == 0 || now.Sub(time.Unix(0, start)) < d {
+						continue
+					}
+					p.logError(s.logArgs("netplus: write", Direction(dir), "stuck for", d, "possibly deadlocked")...)
+					onDeadlock()
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,29 @@
+package netplus_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestDeadlockTimeout(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	piper.SetDeadlockTimeout(100 * time.Millisecond)
+
+	// the server never reads, so the write to it never returns
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go client.Write([]byte("stuck"))
+
+	start := time.Now()
+	_, err := piper.Run(context.Background(), downstream, upstream)
+	assert.True(t, errors.Is(err, netplus.ErrDeadlockDetected))
+	assert.Lt(t, time.Since(start), time.Second)
+}
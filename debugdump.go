@@ -0,0 +1,140 @@
+package netplus
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// SessionDump is one active session in the snapshot of DebugDumpOnSignal
+type SessionDump struct {
+	ID              string            `json:"id"`
+	Src             string            `json:"src,omitempty"`
+	Dst             string            `json:"dst,omitempty"`
+	BytesUpstream   int64             `json:"bytes_upstream"`
+	BytesDownstream int64             `json:"bytes_downstream"`
+	Duration        string            `json:"duration"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	// Stacks are the stack traces of the copy goroutines of the session, the
+	// sessions started before DebugDumpOnSignal have none
+	Stacks []string `json:"stacks,omitempty"`
+}
+
+// DebugDumpOnSignal writes a JSON array of the active sessions of the Piper of
+// the group, oldest first, to w every time the process receives sig, SIGUSR1
+// when nil, like a thread dump for stuck or leaking connections, stop ends it
+// there is no default signal on windows, plan9 and js, a nil sig does nothing there
+func (g *PipeGroup) DebugDumpOnSignal(w io.Writer, sig os.Signal) (stop func()) {
+	if sig == nil {
+		sig = dumpSignal
+	}
+	if sig == nil {
+		return func() {}
+	}
+	atomic.StoreInt32(&g.Piper.trackGoroutines, 1)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sig)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-c:
+				if err := json.NewEncoder(w).Encode(g.Piper.dumpSessions()); err != nil {
+					g.Piper.logError("netplus: writing the session dump:", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		close(done)
+	}
+}
+
+// dumpSessions snapshots the active sessions with the stacks of their copies
+func (p *Piper) dumpSessions() []SessionDump {
+	stacks := goroutineStacks()
+	now := time.Now()
+	list := []SessionDump{}
+	var starts []time.Time
+	p.sessions.Range(func(_, v interface{}) bool {
+		s := v.(*Session)
+		s.stateMux.Lock()
+		d := SessionDump{
+			ID:              s.id,
+			Src:             s.src,
+			Dst:             s.dst,
+			BytesUpstream:   s.BytesWritten(DirectionUpstream),
+			BytesDownstream: s.BytesWritten(DirectionDownstream),
+			Duration:        now.Sub(s.start).String(),
+			Labels:          s.labels,
+		}
+		start := s.start
+		s.stateMux.Unlock()
+		for dir := range s.goroutines {
+			if stack, ok := stacks[atomic.LoadInt64(&s.goroutines[dir])]; ok {
+				d.Stacks = append(d.Stacks, stack)
+			}
+		}
+		list = append(list, d)
+		starts = append(starts, start)
+		return true
+	})
+	sort.Sort(dumpByStart{list, starts})
+	return list
+}
+
+type dumpByStart struct {
+	list   []SessionDump
+	starts []time.Time
+}
+
+func (b dumpByStart) Len() int           { return len(b.list) }
+func (b dumpByStart) Less(i, j int) bool { return b.starts[i].Before(b.starts[j]) }
+func (b dumpByStart) Swap(i, j int) {
+	b.list[i], b.list[j] = b.list[j], b.list[i]
+	b.starts[i], b.starts[j] = b.starts[j], b.starts[i]
+}
+
+// goroutineID returns the ID of the calling goroutine from its stack header
+func goroutineID() int64 {
+	var buf [64]byte
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
+
+// goroutineStacks returns the stack traces of all the goroutines by ID
+func goroutineStacks() map[int64]string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	stacks := map[int64]string{}
+	for _, g := range bytes.Split(buf, []byte("\n\n")) {
+		header := bytes.TrimPrefix(g, []byte("goroutine "))
+		if i := bytes.IndexByte(header, ' '); i > 0 {
+			if id, err := strconv.ParseInt(string(header[:i]), 10, 64); err == nil {
+				stacks[id] = string(g)
+			}
+		}
+	}
+	return stacks
+}
@@ -0,0 +1,8 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+
+package netplus
+
+import "os"
+
+// dumpSignal is the default signal of DebugDumpOnSignal, there is none here
+var dumpSignal os.Signal
@@ -0,0 +1,42 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package netplus_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestDebugDumpOnSignal(t *testing.T) {
+	group := netplus.NewPipeGroup(netplus.NewPiper(nil, time.Minute))
+	r, w := io.Pipe()
+	stop := group.DebugDumpOnSignal(w, nil)
+	defer stop()
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	assert.Nil(t, group.Add(context.Background(), downstream, upstream))
+	go client.Write([]byte("hello"))
+	_, err := io.ReadFull(server, make([]byte, 5))
+	assert.Nil(t, err)
+
+	assert.Nil(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR1))
+	var dump []netplus.SessionDump
+	assert.Nil(t, json.NewDecoder(r).Decode(&dump))
+	assert.Len(t, dump, 1)
+	assert.Equal(t, dump[0].BytesUpstream, int64(5))
+	assert.Len(t, dump[0].Stacks, 2)
+	for _, stack := range dump[0].Stacks {
+		assert.Contains(t, stack, "netplus.(*Piper).copy")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package netplus
+
+import (
+	"os"
+	"syscall"
+)
+
+// dumpSignal is the default signal of DebugDumpOnSignal
+var dumpSignal os.Signal = syscall.SIGUSR1
@@ -0,0 +1,32 @@
+package netplus
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"go.ideatocode.tech/log"
+)
+
+// DefaultPiper is the Piper used by Run, like http.DefaultClient it is
+// configured once at start up, before the first Run
+var DefaultPiper = NewPiper(NopLogger{}, 2*time.Hour)
+
+// SetDefaultTimeout sets the idle timeout of DefaultPiper
+func SetDefaultTimeout(d time.Duration) {
+	DefaultPiper.Timeout = d
+}
+
+// SetDefaultLogger sets the Logger of DefaultPiper, nil discards the lines
+func SetDefaultLogger(l log.Logger) {
+	if l == nil {
+		l = NopLogger{}
+	}
+	DefaultPiper.Logger = l
+}
+
+// Run pipes downstream and upstream with DefaultPiper until one side is done
+// or ctx is cancelled, it is not named Pipe as that is the name of a type
+func Run(ctx context.Context, downstream, upstream io.ReadWriteCloser) (int64, error) {
+	return DefaultPiper.Run(ctx, downstream, upstream)
+}
@@ -0,0 +1,36 @@
+package netplus_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestDefaultPiper(t *testing.T) {
+	assert.Equal(t, netplus.DefaultPiper.Timeout, 2*time.Hour)
+	assert.Equal(t, netplus.DefaultPiper.Logger, netplus.NopLogger{})
+
+	logger := &recordingLogger{}
+	netplus.SetDefaultTimeout(20 * time.Millisecond)
+	netplus.SetDefaultLogger(logger)
+	defer netplus.SetDefaultTimeout(2 * time.Hour)
+	defer netplus.SetDefaultLogger(nil)
+	assert.Equal(t, netplus.DefaultPiper.Logger, logger)
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	start := time.Now()
+	_, err := netplus.Run(context.Background(), downstream, upstream)
+	assert.NotNil(t, err)
+	assert.Lt(t, time.Since(start), time.Second)
+
+	netplus.SetDefaultLogger(nil)
+	assert.Equal(t, netplus.DefaultPiper.Logger, netplus.NopLogger{})
+}
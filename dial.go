@@ -0,0 +1,148 @@
+package netplus
+
+import (
+	"context"
+	"math"
+	"net"
+	"time"
+)
+
+// BackoffStrategy decides how long to wait between dial attempts
+type BackoffStrategy interface {
+	// Backoff returns the wait before retry number attempt, starting at 1
+	Backoff(attempt int) time.Duration
+}
+
+// ConstantBackoff waits the same duration before every retry
+type ConstantBackoff time.Duration
+
+// Backoff implements BackoffStrategy
+func (b ConstantBackoff) Backoff(attempt int) time.Duration {
+	return time.Duration(b)
+}
+
+// ExponentialBackoff doubles the wait after every retry, starting at Initial
+// and never waiting longer than Max, a zero Max means no maximum
+type ExponentialBackoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// Backoff implements BackoffStrategy
+func (b ExponentialBackoff) Backoff(attempt int) time.Duration {
+	d := b.Initial
+	for i := 1; i < attempt && d > 0 && (b.Max == 0 || d < b.Max); i++ {
+		if d > math.MaxInt64/2 {
+			// doubling again would overflow
+			return math.MaxInt64
+		}
+		d *= 2
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	return d
+}
+
+// DialOption configures Dial
+type DialOption func(*dialOptions)
+
+type dialOptions struct {
+	retries           int
+	backoff           BackoffStrategy
+	perAttemptTimeout time.Duration
+}
+
+// WithRetries makes Dial retry up to n times after the first attempt fails
+// n <= 0 means a single attempt
+func WithRetries(n int) DialOption {
+	return func(o *dialOptions) {
+		o.retries = n
+	}
+}
+
+// WithBackoff sets the wait between retries, 100ms doubling up to 5s by default
+func WithBackoff(strategy BackoffStrategy) DialOption {
+	return func(o *dialOptions) {
+		o.backoff = strategy
+	}
+}
+
+// WithPerAttemptTimeout bounds every single dial attempt
+func WithPerAttemptTimeout(d time.Duration) DialOption {
+	return func(o *dialOptions) {
+		o.perAttemptTimeout = d
+	}
+}
+
+// DialStats describes the attempts of one DialWithStats call
+type DialStats struct {
+	Attempts int
+	// TotalDialDuration includes the backoff between the attempts
+	TotalDialDuration  time.Duration
+	LastAttemptLatency time.Duration
+	// Errors holds the error of every failed attempt in order
+	Errors []error
+}
+
+// Dial connects to address on network like net.Dial, retrying failed attempts
+// as configured by opts until ctx is done
+// it returns the error of the last attempt when all of them fail, or the error
+// of ctx once it is done
+func Dial(ctx context.Context, network, address string, opts ...DialOption) (net.Conn, error) {
+	conn, _, err := DialWithStats(ctx, network, address, opts...)
+	return conn, err
+}
+
+// DialWithStats is Dial also returning how many attempts were needed and how
+// long they took, to tell a flaky upstream from a slow one
+func DialWithStats(ctx context.Context, network, address string, opts ...DialOption) (net.Conn, DialStats, error) {
+	o := dialOptions{
+		backoff: ExponentialBackoff{Initial: 100 * time.Millisecond, Max: 5 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.retries < 0 {
+		o.retries = 0
+	}
+
+	var d net.Dialer
+	var stats DialStats
+	var lastErr error
+	start := time.Now()
+	for attempt := 0; attempt <= o.retries; attempt++ {
+		if attempt > 0 {
+			t := time.NewTimer(o.backoff.Backoff(attempt))
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				stats.TotalDialDuration = time.Since(start)
+				return nil, stats, ctx.Err()
+			}
+		}
+
+		actx, cancel := ctx, context.CancelFunc(func() {})
+		if o.perAttemptTimeout > 0 {
+			actx, cancel = context.WithTimeout(ctx, o.perAttemptTimeout)
+		}
+		attemptStart := time.Now()
+		conn, err := d.DialContext(actx, network, address)
+		cancel()
+		stats.Attempts++
+		stats.LastAttemptLatency = time.Since(attemptStart)
+		if err == nil {
+			stats.TotalDialDuration = time.Since(start)
+			return conn, stats, nil
+		}
+		stats.Errors = append(stats.Errors, err)
+		lastErr = err
+		if ctx.Err() != nil {
+			stats.TotalDialDuration = time.Since(start)
+			return nil, stats, ctx.Err()
+		}
+	}
+	stats.TotalDialDuration = time.Since(start)
+	return nil, stats, lastErr
+}
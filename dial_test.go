@@ -0,0 +1,111 @@
+package netplus_test
+
+import (
+	"context"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := netplus.ExponentialBackoff{Initial: 100 * time.Millisecond, Max: time.Second}
+	assert.Equal(t, b.Backoff(1), 100*time.Millisecond)
+	assert.Equal(t, b.Backoff(2), 200*time.Millisecond)
+	assert.Equal(t, b.Backoff(4), 800*time.Millisecond)
+	assert.Equal(t, b.Backoff(5), time.Second)
+	assert.Equal(t, b.Backoff(100), time.Second)
+
+	// without a maximum the wait stops growing before it overflows
+	b.Max = 0
+	assert.Equal(t, b.Backoff(11), 1024*100*time.Millisecond)
+	assert.Equal(t, b.Backoff(1000), time.Duration(math.MaxInt64))
+}
+
+func TestDialCancelled(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = netplus.Dial(ctx, "tcp", addr, netplus.WithRetries(100), netplus.WithBackoff(netplus.ConstantBackoff(10*time.Millisecond)))
+	assert.Equal(t, err, context.DeadlineExceeded)
+}
+
+func TestDialRetries(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	// nothing listens until after the first attempts have failed
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		time.Sleep(150 * time.Millisecond)
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+		defer ln.Close()
+		c, err := ln.Accept()
+		if err == nil {
+			c.Close()
+		}
+	}()
+
+	conn, err := netplus.Dial(context.Background(), "tcp", addr,
+		netplus.WithRetries(20),
+		netplus.WithBackoff(netplus.ConstantBackoff(25*time.Millisecond)),
+		netplus.WithPerAttemptTimeout(time.Second),
+	)
+	assert.Nil(t, err)
+	assert.NotNil(t, conn)
+	conn.Close()
+	<-closed
+
+	_, err = netplus.Dial(context.Background(), "tcp", addr, netplus.WithRetries(1), netplus.WithBackoff(netplus.ConstantBackoff(0)))
+	assert.NotNil(t, err)
+}
+
+func TestDialNegativeRetries(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	c, err := netplus.Dial(context.Background(), "tcp", ln.Addr().String(), netplus.WithRetries(-1))
+	assert.Nil(t, err)
+	assert.NotNil(t, c)
+	c.Close()
+}
+
+func TestDialWithStats(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	_, stats, err := netplus.DialWithStats(context.Background(), "tcp", addr,
+		netplus.WithRetries(2), netplus.WithBackoff(netplus.ConstantBackoff(20*time.Millisecond)))
+	assert.NotNil(t, err)
+	assert.Equal(t, stats.Attempts, 3)
+	assert.Len(t, stats.Errors, 3)
+	assert.Equal(t, stats.Errors[2], err)
+	assert.Ge(t, stats.TotalDialDuration, 40*time.Millisecond)
+	assert.Lt(t, stats.LastAttemptLatency, stats.TotalDialDuration)
+
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	conn, stats, err := netplus.DialWithStats(context.Background(), "tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	conn.Close()
+	assert.Equal(t, stats.Attempts, 1)
+	assert.Len(t, stats.Errors, 0)
+	assert.Le(t, stats.LastAttemptLatency, stats.TotalDialDuration)
+}
@@ -0,0 +1,86 @@
+package netplus
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+)
+
+// TLSOption configures DialTLS
+type TLSOption func(*tlsOptions)
+
+type tlsOptions struct {
+	config      tls.Config
+	dialOptions []DialOption
+}
+
+// WithRootCAs verifies the server certificate against pool instead of the system roots
+func WithRootCAs(pool *x509.CertPool) TLSOption {
+	return func(o *tlsOptions) {
+		o.config.RootCAs = pool
+	}
+}
+
+// WithClientCert presents cert to servers that ask for a client certificate
+func WithClientCert(cert tls.Certificate) TLSOption {
+	return func(o *tlsOptions) {
+		o.config.Certificates = append(o.config.Certificates, cert)
+	}
+}
+
+// WithInsecureSkipVerify turns off server certificate verification, for testing only
+func WithInsecureSkipVerify(skip bool) TLSOption {
+	return func(o *tlsOptions) {
+		o.config.InsecureSkipVerify = skip
+	}
+}
+
+// WithServerName sets the SNI and the name the certificate is verified against
+// it defaults to the host part of the dialled address
+func WithServerName(name string) TLSOption {
+	return func(o *tlsOptions) {
+		o.config.ServerName = name
+	}
+}
+
+// WithMinVersion sets the minimum TLS version, e.g. tls.VersionTLS12
+func WithMinVersion(version uint16) TLSOption {
+	return func(o *tlsOptions) {
+		o.config.MinVersion = version
+	}
+}
+
+// WithTLSDialOptions passes opts to the Dial call that opens the TCP connection
+func WithTLSDialOptions(opts ...DialOption) TLSOption {
+	return func(o *tlsOptions) {
+		o.dialOptions = append(o.dialOptions, opts...)
+	}
+}
+
+// DialTLS opens a TLS connection to address and completes the handshake
+// the returned connection is a *tls.Conn ready to be passed to Piper.Run
+func DialTLS(ctx context.Context, address string, opts ...TLSOption) (net.Conn, error) {
+	var o tlsOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		o.config.ServerName = host
+	}
+
+	conn, err := Dial(ctx, "tcp", address, o.dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+	tc := tls.Client(conn, &o.config)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
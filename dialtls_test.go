@@ -0,0 +1,32 @@
+package netplus_test
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestDialTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "https://")
+
+	_, err := netplus.DialTLS(context.Background(), addr)
+	assert.NotNil(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	conn, err := netplus.DialTLS(context.Background(), addr, netplus.WithRootCAs(pool), netplus.WithServerName("example.com"))
+	assert.Nil(t, err)
+	conn.Close()
+
+	conn, err = netplus.DialTLS(context.Background(), addr, netplus.WithInsecureSkipVerify(true))
+	assert.Nil(t, err)
+	conn.Close()
+}
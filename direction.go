@@ -0,0 +1,25 @@
+package netplus
+
+// Direction tells which way data flows through a pipe
+type Direction int
+
+const (
+	// DirectionUpstream is data read from downstream and written to upstream
+	DirectionUpstream Direction = iota
+	// DirectionDownstream is data read from upstream and written to downstream
+	DirectionDownstream
+	// DirectionBoth stands for both directions in filters and counters
+	DirectionBoth Direction = -1
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionUpstream:
+		return "upstream"
+	case DirectionDownstream:
+		return "downstream"
+	case DirectionBoth:
+		return "both"
+	}
+	return "unknown"
+}
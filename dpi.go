@@ -0,0 +1,43 @@
+package netplus
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+const (
+	// dpiPeekSize is how much of a connection the DPI hook sees, one MTU
+	dpiPeekSize = 1400
+	// dpiReadTimeout bounds the wait for the first bytes of a connection
+	dpiReadTimeout = 5 * time.Second
+)
+
+// WithDPIHook calls fn with the first bytes of every connection, as many as
+// arrive in a single read of up to 1400 bytes, before it is piped
+// peek is empty when the client sent nothing within 5 seconds
+// when fn does not allow the connection it is closed and reason is logged
+func WithDPIHook(fn func(ctx context.Context, peek []byte) (allow bool, reason string)) ProxyOption {
+	return func(ap *AutoProxy) {
+		ap.dpiHook = fn
+	}
+}
+
+// inspect runs the DPI hook on conn and returns the connection to pass on,
+// which replays the peeked bytes, or false when the hook rejected it
+func (ap *AutoProxy) inspect(conn net.Conn) (net.Conn, bool) {
+	if ap.dpiHook == nil {
+		return conn, true
+	}
+	peek := make([]byte, dpiPeekSize)
+	conn.SetReadDeadline(time.Now().Add(dpiReadTimeout))
+	n, _ := conn.Read(peek)
+	conn.SetReadDeadline(time.Time{})
+	peek = peek[:n]
+	if allow, reason := ap.dpiHook(ap.ctx, peek); !allow {
+		ap.Piper.logWarn("netplus: connection from", conn.RemoteAddr(), "dropped by DPI:", reason)
+		conn.Close()
+		return nil, false
+	}
+	return &prefixConn{Conn: conn, prefix: peek}, true
+}
@@ -0,0 +1,46 @@
+package netplus_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestAutoProxyDPIHook(t *testing.T) {
+	upstream := echoServer(t)
+	defer upstream.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	logger := &recordingLogger{}
+	ap := netplus.NewAutoProxy(ln, dialTo(upstream), netplus.NewPiper(logger, time.Minute),
+		netplus.WithDPIHook(func(ctx context.Context, peek []byte) (bool, string) {
+			if bytes.HasPrefix(peek, []byte("GET ")) {
+				return true, ""
+			}
+			return false, "not http"
+		}))
+	go ap.Serve()
+	defer ap.Stop(context.Background())
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer c.Close()
+	// the peeked bytes are replayed to the upstream
+	echoOnce(t, c, "GET / HTTP/1.0\r\n\r\n")
+
+	c, err = net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer c.Close()
+	c.Write([]byte("SSH-2.0-client\r\n"))
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = c.Read(make([]byte, 1))
+	assert.Equal(t, err, io.EOF)
+	assert.Contains(t, logger.Lines()[len(logger.Lines())-1], "not http")
+}
@@ -0,0 +1,65 @@
+package netplus
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PipeError is the error returned by Run, it wraps the error that ended the
+// session with enough context to correlate it in the logs
+type PipeError struct {
+	ConnectionID string
+	// Direction is the copy direction that failed first
+	Direction        Direction
+	BytesTransferred int64
+	Duration         time.Duration
+	Err              error
+}
+
+func (e *PipeError) Error() string {
+	return fmt.Sprintf("netplus: connection %s failed %s after %d bytes in %s: %v",
+		e.ConnectionID, e.Direction, e.BytesTransferred, e.Duration, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *PipeError) Unwrap() error {
+	return e.Err
+}
+
+// MultiError holds the errors of both copy directions, see WithAccumulateErrors
+// a direction that ended cleanly has a nil error
+type MultiError struct {
+	Upstream   error
+	Downstream error
+}
+
+func (e MultiError) Error() string {
+	switch {
+	case e.Upstream == nil:
+		return "downstream: " + e.Downstream.Error()
+	case e.Downstream == nil:
+		return "upstream: " + e.Upstream.Error()
+	}
+	return "upstream: " + e.Upstream.Error() + "; downstream: " + e.Downstream.Error()
+}
+
+// Is reports whether either direction failed with target
+func (e MultiError) Is(target error) bool {
+	return (e.Upstream != nil && errors.Is(e.Upstream, target)) ||
+		(e.Downstream != nil && errors.Is(e.Downstream, target))
+}
+
+// As finds the first error in either direction that matches target
+func (e MultiError) As(target interface{}) bool {
+	return (e.Upstream != nil && errors.As(e.Upstream, target)) ||
+		(e.Downstream != nil && errors.As(e.Downstream, target))
+}
+
+func (e *MultiError) set(r copyResult) {
+	if r.dir == DirectionUpstream {
+		e.Upstream = r.err
+	} else {
+		e.Downstream = r.err
+	}
+}
@@ -0,0 +1,127 @@
+package netplus_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestPipeError(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+
+	reader, _ := io.Pipe()
+	upstream, server := net.Pipe()
+	go server.Write([]byte("hello"))
+	_, err := piper.Run(context.Background(), &failingWriter{reader}, upstream)
+
+	var pipeErr *netplus.PipeError
+	assert.True(t, errors.As(fmt.Errorf("wrapped: %w", err), &pipeErr))
+	assert.Equal(t, pipeErr.ConnectionID, "1")
+	assert.Equal(t, pipeErr.Direction, netplus.DirectionDownstream)
+	assert.Equal(t, pipeErr.Err.Error(), "disk on fire")
+	assert.Contains(t, err.Error(), "connection 1 failed downstream after 0 bytes")
+}
+
+func TestMultiError(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithAccumulateErrors(true))
+
+	reader, _ := io.Pipe()
+	upstream, server := net.Pipe()
+	go server.Write([]byte("hello"))
+	_, err := piper.Run(context.Background(), &failingWriter{reader}, upstream)
+
+	var me netplus.MultiError
+	assert.True(t, errors.As(err, &me))
+	assert.Equal(t, me.Downstream.Error(), "disk on fire")
+	// the upstream direction was cut short when the pipe closed
+	assert.True(t, errors.Is(err, io.ErrClosedPipe))
+
+	var pipeErr *netplus.PipeError
+	assert.True(t, errors.As(err, &pipeErr))
+	assert.Equal(t, pipeErr.Direction, netplus.DirectionDownstream)
+
+	only := netplus.MultiError{Upstream: netplus.ErrShortWrite}
+	assert.True(t, errors.Is(only, netplus.ErrShortWrite))
+	assert.Equal(t, only.Error(), "upstream: short write")
+}
+
+type shortWriter struct {
+	*io.PipeReader
+}
+
+func (c *shortWriter) Write(p []byte) (n int, err error) {
+	return len(p) - 1, nil
+}
+
+func TestShortWrite(t *testing.T) {
+	for _, accumulate := range []bool{false, true} {
+		// a TCP upstream is a WriterTo and copies through the ReadFrom of the copy
+		for _, tcp := range []bool{false, true} {
+			piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithAccumulateErrors(accumulate))
+
+			reader, _ := io.Pipe()
+			upstream, server := net.Pipe()
+			if tcp {
+				upstream, server = tcpPair(t)
+			}
+			go server.Write([]byte("hello"))
+			written, err := piper.Run(context.Background(), &shortWriter{reader}, upstream)
+			server.Close()
+
+			assert.True(t, errors.Is(err, netplus.ErrShortWrite), accumulate, tcp, err)
+			assert.Equal(t, written, int64(4))
+			if accumulate {
+				var me netplus.MultiError
+				assert.True(t, errors.As(err, &me))
+				assert.Equal(t, me.Downstream, netplus.ErrShortWrite)
+			}
+		}
+	}
+}
+
+// stuckConn blocks its writes past Close and fails its reads once a write is stuck
+type stuckConn struct {
+	writing chan struct{}
+	block   chan struct{}
+}
+
+func (c *stuckConn) Read(p []byte) (int, error) {
+	<-c.writing
+	return 0, errors.New("read failed")
+}
+
+func (c *stuckConn) Write(p []byte) (int, error) {
+	close(c.writing)
+	<-c.block
+	return len(p), nil
+}
+
+func (c *stuckConn) Close() error { return nil }
+
+func TestMultiErrorAbandonedCopy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the 1s drain")
+	}
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithAccumulateErrors(true))
+
+	downstream := &stuckConn{writing: make(chan struct{}), block: make(chan struct{})}
+	defer close(downstream.block)
+	upstream, server := net.Pipe()
+	defer server.Close()
+	go server.Write([]byte("hello"))
+	_, err := piper.Run(context.Background(), downstream, upstream)
+
+	// the copy stuck writing downstream does not hide the upstream failure
+	var me netplus.MultiError
+	assert.True(t, errors.As(err, &me))
+	assert.NotNil(t, me.Upstream)
+	assert.Nil(t, me.Downstream)
+	assert.Contains(t, err.Error(), "upstream: read failed")
+}
@@ -0,0 +1,105 @@
+package netplus
+
+import (
+	"context"
+	"time"
+)
+
+// EventType tells what an Event is about
+type EventType int
+
+const (
+	// Connected is emitted when Run starts
+	Connected EventType = iota
+	// Disconnected is emitted when Run returns, Data holds the RunStats
+	Disconnected
+	// BytesMilestone is emitted every EventMilestoneBytes copied in one direction
+	// Data holds a BytesMilestoneData
+	BytesMilestone
+	// IdleTimeout is emitted when a session is closed for being idle
+	IdleTimeout
+	// Error is emitted when Run returns an error, Data holds the error
+	Error
+)
+
+// String returns the name of the event type
+func (t EventType) String() string {
+	switch t {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	case BytesMilestone:
+		return "bytes_milestone"
+	case IdleTimeout:
+		return "idle_timeout"
+	case Error:
+		return "error"
+	}
+	return "unknown"
+}
+
+// EventMilestoneBytes is the step at which BytesMilestone events are emitted
+const EventMilestoneBytes = 1 << 20
+
+// eventLogSize is the capacity of the EventLog channel
+const eventLogSize = 1024
+
+// Event is a session event sent to the EventLog channel
+type Event struct {
+	Type         EventType
+	ConnectionID string
+	Timestamp    time.Time
+	Data         interface{}
+}
+
+// BytesMilestoneData is the Data of a BytesMilestone event
+type BytesMilestoneData struct {
+	Direction Direction
+	Bytes     int64
+}
+
+// EventLog returns the channel session events are sent to
+// events are dropped when the channel is full and are only emitted once
+// EventLog has been called, the channel is closed by Drain
+func (p *Piper) EventLog() <-chan Event {
+	p.eventMux.Lock()
+	defer p.eventMux.Unlock()
+	if p.events == nil {
+		p.events = make(chan Event, eventLogSize)
+		if p.eventsClosed {
+			close(p.events)
+		}
+	}
+	return p.events
+}
+
+// emit sends an event to the event log without blocking
+func (p *Piper) emit(t EventType, id string, data interface{}) {
+	p.eventMux.Lock()
+	defer p.eventMux.Unlock()
+	if p.events == nil || p.eventsClosed {
+		return
+	}
+	select {
+	case p.events <- Event{Type: t, ConnectionID: id, Timestamp: time.Now(), Data: data}:
+	default:
+	}
+}
+
+// Drain waits until there are no active connections and then closes the EventLog channel
+// it returns ctx.Err() if ctx is done first, the channel is left open
+func (p *Piper) Drain(ctx context.Context) error {
+	if err := p.WaitIdle(ctx); err != nil {
+		return err
+	}
+	p.eventMux.Lock()
+	defer p.eventMux.Unlock()
+	if !p.eventsClosed {
+		p.eventsClosed = true
+		if p.events != nil {
+			close(p.events)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package netplus_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestEventLog(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	events := piper.EventLog()
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	done := piper.RunAsync(context.Background(), downstream, upstream)
+
+	go server.Write(make([]byte, netplus.EventMilestoneBytes))
+	_, err := io.ReadFull(client, make([]byte, netplus.EventMilestoneBytes))
+	assert.Nil(t, err)
+	client.Close()
+	<-done
+	assert.Nil(t, piper.Drain(context.Background()))
+
+	var types []netplus.EventType
+	for e := range events {
+		assert.Equal(t, e.ConnectionID, "1")
+		types = append(types, e.Type)
+		if e.Type == netplus.BytesMilestone {
+			assert.Equal(t, e.Data, netplus.BytesMilestoneData{netplus.DirectionDownstream, netplus.EventMilestoneBytes})
+		}
+	}
+	assert.Equal(t, types, []netplus.EventType{netplus.Connected, netplus.BytesMilestone, netplus.Disconnected})
+}
+
+func TestEventLogIdleTimeout(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, 50*time.Millisecond)
+	events := piper.EventLog()
+
+	_, downstream := net.Pipe()
+	upstream, _ := net.Pipe()
+	piper.Run(context.Background(), downstream, upstream)
+
+	assert.Equal(t, (<-events).Type, netplus.Connected)
+	assert.Equal(t, (<-events).Type, netplus.IdleTimeout)
+}
@@ -0,0 +1,185 @@
+package netplus_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"go.ideatocode.tech/netplus"
+)
+
+// echoUpstream returns a connection to a server echoing what it reads
+func echoUpstream() net.Conn {
+	upstream, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		io.Copy(server, server)
+	}()
+	return upstream
+}
+
+// pipeListener is a net.Listener handing out the server ends of net.Pipe
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+// dial returns the client end of a new connection to the listener
+func (l *pipeListener) dial() net.Conn {
+	client, server := net.Pipe()
+	l.conns <- server
+	return client
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	select {
+	case <-l.done:
+	default:
+		close(l.done)
+	}
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// a TCP forwarder pipes every accepted connection to its upstream
+func ExamplePiper_Run() {
+	piper := netplus.NewPiper(nil, time.Minute)
+
+	client, downstream := net.Pipe()
+	done := make(chan int64)
+	go func() {
+		written, _ := piper.Run(context.Background(), downstream, echoUpstream())
+		done <- written
+	}()
+
+	client.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	io.ReadFull(client, buf)
+	client.Close()
+	fmt.Println(string(buf))
+	fmt.Println("piped", <-done, "bytes")
+	// Output:
+	// hello
+	// piped 10 bytes
+}
+
+// an HTTP CONNECT proxy answers the CONNECT request and then pipes the raw
+// connection to the requested host
+func ExamplePiper_RunConn() {
+	piper := netplus.NewPiper(nil, time.Minute)
+
+	client, downstream := net.Pipe()
+	go func() {
+		r, err := http.ReadRequest(bufio.NewReader(downstream))
+		if err != nil || r.Method != http.MethodConnect {
+			downstream.Close()
+			return
+		}
+		fmt.Println("connecting to", r.Host)
+		upstream := echoUpstream()
+		downstream.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+		piper.RunConn(context.Background(), downstream, upstream)
+	}()
+
+	client.Write([]byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+	br := bufio.NewReader(client)
+	resp, _ := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	fmt.Println(resp.Status)
+
+	client.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	io.ReadFull(br, buf)
+	fmt.Println(string(buf))
+	client.Close()
+	// Output:
+	// connecting to example.com:443
+	// 200 Connection Established
+	// ping
+}
+
+// a rate limited relay accepts at most 10 connections per second and paces
+// every session to 1 MB/s in each direction
+func ExampleAutoProxy() {
+	piper := netplus.NewPiper(nil, time.Minute, netplus.WithReadRateLimit(1<<20, 1<<20))
+	ln := newPipeListener()
+	ap := netplus.NewAutoProxy(ln, func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+		return echoUpstream(), nil
+	}, piper)
+	ap.SetAcceptRateLimit(10, 10)
+	go ap.Serve()
+
+	client := ln.dial()
+	client.Write([]byte("relayed"))
+	buf := make([]byte, 7)
+	io.ReadFull(client, buf)
+	client.Close()
+	fmt.Println(string(buf))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	fmt.Println(ap.Stop(ctx))
+	// Output:
+	// relayed
+	// <nil>
+}
+
+// a pooled upstream is put back in the pool after every session and reused by
+// the next one instead of dialling again
+func ExampleConnPool() {
+	dials := 0
+	pool := netplus.NewConnPool(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		dials++
+		return echoUpstream(), nil
+	}, 4)
+	defer pool.Close()
+	piper := netplus.NewPiper(nil, time.Minute, netplus.WithKeepUpstreamOpen(true))
+
+	for _, msg := range []string{"first", "second"} {
+		upstream, err := pool.Get(context.Background())
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		client, downstream := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			piper.Run(context.Background(), downstream, upstream)
+		}()
+
+		client.Write([]byte(msg))
+		buf := make([]byte, len(msg))
+		io.ReadFull(client, buf)
+		client.Close()
+		<-done
+		fmt.Println(string(buf))
+		if err := pool.Put(upstream); err != nil {
+			fmt.Println(err)
+		}
+	}
+	fmt.Println("dialled", dials, "upstream")
+	// Output:
+	// first
+	// second
+	// dialled 1 upstream
+}
@@ -0,0 +1,193 @@
+package netplus
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// RunFan copies src to every dst, each chunk read is written to all of them
+// concurrently from the same buffer and the next read waits for the slowest
+// a dst failing a write is closed and dropped, RunFan returns once src
+// finishes or every dst failed, with the error of the last one, or ctx is done
+// src and the remaining dsts are closed and the result is the bytes read from src
+func (p *Piper) RunFan(ctx context.Context, src io.ReadCloser, dsts []io.WriteCloser) (int64, error) {
+	if p.isClosed() {
+		return 0, ErrPiperClosed
+	}
+	ctx, cancel := p.withClose(ctx)
+	defer cancel()
+	dsts = append([]io.WriteCloser(nil), dsts...)
+	all := append([]io.WriteCloser(nil), dsts...)
+	// the dsts are closed as well, a write blocked on a slow one ends with them
+	stop := closeOnDone(ctx, closerFunc(func() error {
+		src.Close()
+		for _, d := range all {
+			d.Close()
+		}
+		return nil
+	}))
+	defer stop()
+	defer func() {
+		src.Close()
+		for _, d := range dsts {
+			d.Close()
+		}
+	}()
+
+	buf := p.getBuf()
+	defer p.putBuf(buf)
+
+	var written int64
+	errs := make([]error, len(dsts))
+	for len(dsts) > 0 {
+		nr, er := src.Read(buf)
+		if nr > 0 {
+			var wg sync.WaitGroup
+			for i, d := range dsts {
+				wg.Add(1)
+				go func(i int, d io.Writer) {
+					defer wg.Done()
+					nw, ew := d.Write(buf[:nr])
+					if ew == nil && nw != nr {
+						ew = ErrShortWrite
+					}
+					errs[i] = ew
+				}(i, d)
+			}
+			wg.Wait()
+			written += int64(nr)
+
+			live := dsts[:0]
+			var lastErr error
+			for i, d := range dsts {
+				if errs[i] == nil {
+					live = append(live, d)
+					continue
+				}
+				if p.debugEnabled(0) {
+					p.debug("runfan: dropping destination:", errs[i])
+				}
+				d.Close()
+				lastErr = errs[i]
+			}
+			dsts = live
+			if len(dsts) == 0 {
+				if ctx.Err() != nil {
+					return written, ctx.Err()
+				}
+				return written, lastErr
+			}
+		}
+		if er != nil {
+			if ctx.Err() != nil {
+				return written, ctx.Err()
+			}
+			if er == io.EOF {
+				return written, nil
+			}
+			return written, er
+		}
+	}
+	return written, nil
+}
+
+// closeOnDone closes c once ctx is done, stop releases the watch
+func closeOnDone(ctx context.Context, c io.Closer) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// RunMerge copies every src to dst, each src in its own goroutine with the
+// writes to dst serialized, the inverse of RunFan
+// it returns once all srcs finished, on the first read or write error, or when
+// ctx is done, every src and dst are then closed, the result is the bytes written
+func (p *Piper) RunMerge(ctx context.Context, srcs []io.ReadCloser, dst io.WriteCloser) (int64, error) {
+	if p.isClosed() {
+		return 0, ErrPiperClosed
+	}
+	ctx, cancel := p.withClose(ctx)
+	defer cancel()
+	var (
+		mux      sync.Mutex // serializes the writes to dst and guards written and err
+		written  int64
+		firstErr error
+		once     sync.Once
+	)
+	closeAll := func() {
+		once.Do(func() {
+			for _, src := range srcs {
+				src.Close()
+			}
+			dst.Close()
+		})
+	}
+	defer closeAll()
+	stop := closeOnDone(ctx, closerFunc(func() error {
+		closeAll()
+		return nil
+	}))
+	defer stop()
+
+	fail := func(err error) {
+		mux.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mux.Unlock()
+		closeAll()
+	}
+
+	var wg sync.WaitGroup
+	for _, src := range srcs {
+		wg.Add(1)
+		go func(src io.Reader) {
+			defer wg.Done()
+			buf := p.getBuf()
+			defer p.putBuf(buf)
+			for {
+				nr, er := src.Read(buf)
+				if nr > 0 {
+					mux.Lock()
+					nw, ew := dst.Write(buf[:nr])
+					if ew == nil && nw != nr {
+						ew = ErrShortWrite
+					}
+					written += int64(nw)
+					mux.Unlock()
+					if ew != nil {
+						fail(ew)
+						return
+					}
+				}
+				if er == io.EOF {
+					return
+				}
+				if er != nil {
+					fail(er)
+					return
+				}
+			}
+		}(src)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return written, ctx.Err()
+	}
+	return written, firstErr
+}
+
+// closerFunc adapts a function to io.Closer
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
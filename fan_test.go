@@ -0,0 +1,117 @@
+package netplus_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestRunFan(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	client, src := net.Pipe()
+	go func() {
+		client.Write([]byte("hello "))
+		client.Write([]byte("world"))
+		client.Close()
+	}()
+
+	var dsts []io.WriteCloser
+	received := make([]chan string, 2)
+	for i := range received {
+		dst, server := net.Pipe()
+		dsts = append(dsts, dst)
+		received[i] = make(chan string, 1)
+		go func(c chan string) {
+			b, _ := io.ReadAll(server)
+			c <- string(b)
+		}(received[i])
+	}
+	// a destination that is already gone is dropped
+	gone, server := net.Pipe()
+	server.Close()
+	dsts = append(dsts, gone)
+
+	n, err := piper.RunFan(context.Background(), src, dsts)
+	assert.Nil(t, err)
+	assert.Equal(t, n, int64(11))
+	assert.Equal(t, <-received[0], "hello world")
+	assert.Equal(t, <-received[1], "hello world")
+}
+
+func TestRunFanAllFailed(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	client, src := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte("x"))
+
+	dst, server := net.Pipe()
+	server.Close()
+	_, err := piper.RunFan(context.Background(), src, []io.WriteCloser{dst})
+	assert.Equal(t, err, io.ErrClosedPipe)
+}
+
+func TestRunFanCancelBlockedWrite(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	client, src := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte("x"))
+
+	// nobody reads the destination, the write to it blocks
+	dst, server := net.Pipe()
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() {
+		_, err := piper.RunFan(ctx, src, []io.WriteCloser{dst})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.Equal(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("RunFan blocked on a write after ctx was done")
+	}
+}
+
+func TestRunMerge(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	var srcs []io.ReadCloser
+	for _, line := range []string{"a\n", "b\n", "c\n"} {
+		client, src := net.Pipe()
+		srcs = append(srcs, src)
+		go func(line string) {
+			client.Write([]byte(line))
+			client.Close()
+		}(line)
+	}
+	dst, server := net.Pipe()
+	received := make(chan string, 1)
+	go func() {
+		b, _ := io.ReadAll(server)
+		received <- string(b)
+	}()
+
+	n, err := piper.RunMerge(context.Background(), srcs, dst)
+	assert.Nil(t, err)
+	assert.Equal(t, n, int64(6))
+	lines := strings.Fields(<-received)
+	sort.Strings(lines)
+	assert.Equal(t, lines, []string{"a", "b", "c"})
+
+	// a failing dst ends the merge of the sources still open
+	client, src := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte("x"))
+	dst, server = net.Pipe()
+	server.Close()
+	_, err = piper.RunMerge(context.Background(), []io.ReadCloser{src}, dst)
+	assert.Equal(t, err, io.ErrClosedPipe)
+}
@@ -0,0 +1,23 @@
+package netplus
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNotSupported is returned for features the platform does not have
+var ErrNotSupported = errors.New("not supported on this platform")
+
+// ErrNotNamedPipe is returned by OpenNamedPipe for paths that are no FIFO
+var ErrNotNamedPipe = errors.New("not a named pipe")
+
+// OpenNamedPipe opens the Unix named pipe (FIFO) at path with the os.OpenFile
+// flags, for use as a side of Piper.Run
+// like open(2) it blocks until the other end is opened too, a reader with
+// os.O_RDONLY waits for a writer and a writer with os.O_WRONLY for a reader,
+// os.O_RDWR does not wait on Linux, with os.O_NONBLOCK a reader does not wait
+// and a writer fails without a reader
+// it returns ErrNotSupported on Windows and other platforms without FIFOs
+func OpenNamedPipe(path string, flags int) (io.ReadWriteCloser, error) {
+	return openNamedPipe(path, flags)
+}
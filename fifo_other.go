@@ -0,0 +1,9 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+
+package netplus
+
+import "io"
+
+func openNamedPipe(path string, flags int) (io.ReadWriteCloser, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,49 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package netplus_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestOpenNamedPipe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fifo")
+	assert.Nil(t, syscall.Mkfifo(path, 0o600))
+
+	// the writer blocks until the reader is open
+	go func() {
+		w, err := netplus.OpenNamedPipe(path, os.O_WRONLY)
+		if err == nil {
+			w.Write([]byte("hello"))
+			w.Close()
+		}
+	}()
+	r, err := netplus.OpenNamedPipe(path, os.O_RDONLY)
+	assert.Nil(t, err)
+
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	upstream, server := net.Pipe()
+	got := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(server)
+		got <- b
+	}()
+	written, err := piper.Run(context.Background(), r, upstream)
+	assert.Nil(t, err)
+	assert.Equal(t, written, int64(5))
+	assert.Equal(t, string(<-got), "hello")
+
+	_, err = netplus.OpenNamedPipe(t.TempDir(), os.O_RDONLY)
+	assert.True(t, errors.Is(err, netplus.ErrNotNamedPipe))
+}
@@ -0,0 +1,26 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package netplus
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+func openNamedPipe(path string, flags int) (io.ReadWriteCloser, error) {
+	f, err := os.OpenFile(path, flags, 0)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.Mode()&os.ModeNamedPipe == 0 {
+		f.Close()
+		return nil, fmt.Errorf("%w: %s", ErrNotNamedPipe, path)
+	}
+	return f, nil
+}
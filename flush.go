@@ -0,0 +1,109 @@
+package netplus
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"time"
+)
+
+// FlushStrategy decides when the write buffer of WithBufferedIO is flushed
+type FlushStrategy struct {
+	interval time.Duration
+	size     int
+}
+
+// FlushPerRead flushes after every copy iteration, the lowest latency
+var FlushPerRead = FlushStrategy{}
+
+// FlushOnTimer flushes every d whatever was read meanwhile
+func FlushOnTimer(d time.Duration) FlushStrategy {
+	return FlushStrategy{interval: d}
+}
+
+// FlushOnSize flushes once n bytes are buffered, what is left is flushed when
+// the copy ends, a quiet peer may otherwise keep a partial buffer waiting
+func FlushOnSize(n int) FlushStrategy {
+	return FlushStrategy{size: n}
+}
+
+// WithFlushStrategy sets when the write buffer of WithBufferedIO is flushed,
+// FlushPerRead by default, it has no effect without a write buffer
+func WithFlushStrategy(strategy FlushStrategy) Option {
+	return func(p *Piper) {
+		p.flushStrategy = strategy
+	}
+}
+
+// bufferedWriter is the write side of WithBufferedIO
+type bufferedWriter struct {
+	mux      sync.Mutex
+	w        *bufio.Writer
+	strategy FlushStrategy
+	err      error // the failure of a timer flush, returned by the next Write
+	stop     chan struct{}
+}
+
+func newBufferedWriter(dst io.Writer, size int, strategy FlushStrategy) *bufferedWriter {
+	b := &bufferedWriter{
+		w:        bufio.NewWriterSize(dst, size),
+		strategy: strategy,
+		stop:     make(chan struct{}),
+	}
+	if strategy.interval > 0 {
+		go b.flushEvery(strategy.interval)
+	}
+	return b
+}
+
+func (b *bufferedWriter) flushEvery(d time.Duration) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.mux.Lock()
+			if b.err == nil && b.w.Buffered() > 0 {
+				b.err = b.w.Flush()
+			}
+			b.mux.Unlock()
+		}
+	}
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.w.Write(p)
+	if err == nil && b.due() {
+		err = b.w.Flush()
+	}
+	return n, err
+}
+
+// due reports whether the strategy flushes after the current write
+func (b *bufferedWriter) due() bool {
+	switch {
+	case b.strategy.interval > 0:
+		return false
+	case b.strategy.size > 0:
+		return b.w.Buffered() >= b.strategy.size
+	}
+	return true
+}
+
+// close stops the timer and flushes what is left
+func (b *bufferedWriter) close() error {
+	close(b.stop)
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	return b.w.Flush()
+}
@@ -0,0 +1,55 @@
+package netplus_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestFlushOnSize(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute,
+		netplus.WithBufferedIO(0, 64), netplus.WithFlushStrategy(netplus.FlushOnSize(4)))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	w := &chunkWriter{Conn: upstream}
+	go io.Copy(io.Discard, server)
+	go func() {
+		for _, b := range []string{"ab", "cd", "e"} {
+			client.Write([]byte(b))
+		}
+		client.Close()
+	}()
+	written, err := piper.Run(context.Background(), downstream, w)
+	assert.Nil(t, err)
+	assert.Equal(t, written, int64(5))
+	// the rest is flushed at EOF
+	assert.Equal(t, w.chunks, []int{4, 1})
+}
+
+func TestFlushOnTimer(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute,
+		netplus.WithBufferedIO(0, 64), netplus.WithFlushStrategy(netplus.FlushOnTimer(50*time.Millisecond)))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	w := &chunkWriter{Conn: upstream}
+	done := piper.RunAsync(context.Background(), downstream, w)
+	client.Write([]byte("a"))
+	client.Write([]byte("b"))
+
+	// the timer flushes while the session is still open
+	b := make([]byte, 2)
+	_, err := io.ReadFull(server, b)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "ab")
+	client.Close()
+	server.Close()
+	<-done
+	assert.Equal(t, w.chunks, []int{2})
+}
@@ -1,6 +1,6 @@
 module go.ideatocode.tech/netplus
 
-go 1.17
+go 1.18
 
 require go.ideatocode.tech/log v1.0.4
 
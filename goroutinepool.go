@@ -0,0 +1,54 @@
+package netplus
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrPoolExhausted is returned by Run when the deadline of ctx passes while
+// waiting for goroutines under WithGoroutinePool
+var ErrPoolExhausted = errors.New("goroutine pool exhausted")
+
+// goroutinesPerSession is the number of goroutines a session runs, two copies and the idle timer
+const goroutinesPerSession = 3
+
+// WithGoroutinePool caps the goroutines used by all the sessions of the Piper to
+// maxGoroutines, every session takes three of them
+// Run waits for a free slot and returns ErrPoolExhausted if the deadline of ctx
+// passes first, or the error of ctx when it is cancelled
+func WithGoroutinePool(maxGoroutines int) Option {
+	return func(p *Piper) {
+		n := maxGoroutines / goroutinesPerSession
+		if n < 1 {
+			n = 1
+		}
+		p.sessionSlots = make(chan struct{}, n)
+	}
+}
+
+// acquireSlot waits for room for one more session when the pool is set
+func (p *Piper) acquireSlot(ctx context.Context) error {
+	if p.sessionSlots == nil {
+		return nil
+	}
+	select {
+	case p.sessionSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		switch {
+		case p.isClosed():
+			return ErrPiperClosed
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			return ErrPoolExhausted
+		}
+		return ctx.Err()
+	case <-p.closing:
+		return ErrPiperClosed
+	}
+}
+
+func (p *Piper) releaseSlot() {
+	if p.sessionSlots != nil {
+		<-p.sessionSlots
+	}
+}
@@ -0,0 +1,69 @@
+package netplus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ErrUpstreamUnavailable is the error new connections fail with while the health check fails
+var ErrUpstreamUnavailable = errors.New("upstream unavailable")
+
+// SetHealthCheck checks the upstream every interval, right away the first time
+// fn is called when not nil, otherwise addr is dialled over tcp with interval as timeout
+// while the check fails new connections are closed with ErrUpstreamUnavailable
+// without dialling upstream, active sessions are left alone
+// calling it again replaces the previous check
+func (ap *AutoProxy) SetHealthCheck(addr string, interval time.Duration, fn func() bool) {
+	if fn == nil {
+		fn = func() bool {
+			c, err := net.DialTimeout("tcp", addr, interval)
+			if err != nil {
+				return false
+			}
+			c.Close()
+			return true
+		}
+	}
+	ctx, cancel := context.WithCancel(ap.ctx)
+	ap.mux.Lock()
+	if ap.stopHealthCheck != nil {
+		ap.stopHealthCheck()
+	}
+	ap.stopHealthCheck = cancel
+	ap.mux.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			ap.setHealthy(fn())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (ap *AutoProxy) setHealthy(ok bool) {
+	var v int32
+	if !ok {
+		v = 1
+	}
+	if old := atomic.SwapInt32(&ap.unhealthy, v); old != v {
+		if ok {
+			ap.Piper.logWarn("netplus: upstream health check recovered")
+		} else {
+			ap.Piper.logWarn("netplus: upstream health check failed")
+		}
+	}
+}
+
+// upstreamAvailable reports whether the last health check succeeded
+func (ap *AutoProxy) upstreamAvailable() bool {
+	return atomic.LoadInt32(&ap.unhealthy) == 0
+}
@@ -0,0 +1,47 @@
+package netplus_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestAutoProxyHealthCheck(t *testing.T) {
+	upstream := echoServer(t)
+	defer upstream.Close()
+	var healthy int32
+	ap, ln, _ := startAutoProxy(t, upstream, func(ap *netplus.AutoProxy) {
+		ap.SetHealthCheck("", 10*time.Millisecond, func() bool {
+			return atomic.LoadInt32(&healthy) == 1
+		})
+	})
+	defer ap.Stop(context.Background())
+	time.Sleep(50 * time.Millisecond)
+
+	// unhealthy, the connection is closed right away
+	c, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = c.Read(make([]byte, 1))
+	assert.Equal(t, err, io.EOF)
+	c.Close()
+
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(50 * time.Millisecond)
+	c, err = net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer c.Close()
+	_, err = c.Write([]byte("ping"))
+	assert.Nil(t, err)
+	b := make([]byte, 4)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadFull(c, b)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "ping")
+}
@@ -0,0 +1,93 @@
+package netplus
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ErrHijackUnsupported is returned by Session.Hijack when a connection of the
+// session has no read deadline to stop its copy with
+var ErrHijackUnsupported = errors.New("connection does not support hijacking")
+
+// ErrSessionNotRunning is returned by Session.Hijack when the session is not
+// running, not started yet, closing or already hijacked
+var ErrSessionNotRunning = errors.New("session not running")
+
+// runHijacked is the running state of idleTimeoutPipe once Hijack stopped it
+const runHijacked = 2
+
+// hijackDeadline is the expired read deadline Hijack stops the copies with
+var hijackDeadline = time.Unix(1, 0)
+
+// hijackWriteWait is how long Hijack lets a write in progress finish before it
+// expires the write deadlines as well
+const hijackWriteWait = time.Second
+
+type readDeadliner interface {
+	SetReadDeadline(time.Time) error
+}
+
+// hijackedConns is what the copies of a hijacked session leave to Hijack
+type hijackedConns struct {
+	downstream, upstream io.ReadWriteCloser
+	buffered             []byte
+}
+
+// Hijack stops the copies of the running session without closing its
+// connections and hands them to the caller, for a protocol upgrade or a
+// CONNECT tunnel, buffered holds the bytes read from downstream that were not
+// written to upstream yet, those read from upstream are written to downstream
+// before Hijack returns, the connections must support read deadlines
+// a write still in progress after a second is stopped with an expired write
+// deadline, the bytes it did not write are lost
+// Run then returns with RunStats.Hijacked set and a nil error
+func (s *Session) Hijack() (downstream, upstream io.ReadWriteCloser, buffered []byte, err error) {
+	s.stateMux.Lock()
+	hijack := s.hijack
+	s.stateMux.Unlock()
+	if hijack == nil {
+		return nil, nil, nil, ErrSessionNotRunning
+	}
+	h, err := hijack()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return h.downstream, h.upstream, h.buffered, nil
+}
+
+// expireWrites stops the writes of the copies on conns with an expired deadline
+func (s *Session) expireWrites(conns ...io.ReadWriteCloser) {
+	atomic.StoreInt32(&s.stoppingWrites, 1)
+	for _, c := range conns {
+		if wd, ok := c.(interface{ SetWriteDeadline(time.Time) error }); ok {
+			wd.SetWriteDeadline(hijackDeadline)
+		}
+	}
+}
+
+// setReadDeadline sets the read deadline of a copy unless it is being stopped
+func (s *Session) setReadDeadline(rd readDeadliner, t time.Time) {
+	rd.SetReadDeadline(t)
+	// Hijack may have expired the deadline meanwhile
+	if atomic.LoadInt32(&s.stopping) == 1 {
+		rd.SetReadDeadline(hijackDeadline)
+	}
+}
+
+// hijackBuffered hands what the read buffer of a copy stopped by Hijack holds
+// on, the copy from downstream keeps it for Hijack and the other writes it
+func (p *Piper) hijackBuffered(s *Session, br *bufio.Reader, w *copyWriter) error {
+	if br == nil || br.Buffered() == 0 || atomic.LoadInt32(&s.hijacking) == 0 {
+		return nil
+	}
+	rest, _ := br.Peek(br.Buffered())
+	if w.dir == DirectionUpstream {
+		s.hijackBuf = append([]byte(nil), rest...)
+		return nil
+	}
+	_, err := w.Write(rest)
+	return err
+}
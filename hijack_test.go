@@ -0,0 +1,111 @@
+package netplus_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestHijack(t *testing.T) {
+	piper := netplus.NewPiper(nil, time.Minute)
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	s := piper.Start(context.Background(), downstream, upstream)
+
+	buf := make([]byte, 5)
+	go client.Write([]byte("hello"))
+	_, err := io.ReadFull(server, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, string(buf), "hello")
+
+	d, u, buffered, err := s.Hijack()
+	assert.Nil(t, err)
+	assert.Equal(t, d, downstream)
+	assert.Equal(t, u, upstream)
+	assert.Len(t, buffered, 0)
+	stats := s.Wait()
+	assert.Nil(t, stats.Err)
+	assert.True(t, stats.Hijacked)
+	assert.Equal(t, stats.BytesUpstream, int64(5))
+
+	// the copies are gone and the connections still work
+	go client.Write([]byte("world"))
+	_, err = io.ReadFull(d, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, string(buf), "world")
+	go server.Write([]byte("again"))
+	_, err = io.ReadFull(u, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, string(buf), "again")
+
+	_, _, _, err = s.Hijack()
+	assert.Equal(t, err, netplus.ErrSessionNotRunning)
+}
+
+func TestHijackStuckWrite(t *testing.T) {
+	piper := netplus.NewPiper(nil, time.Minute)
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	s := piper.Start(context.Background(), downstream, upstream)
+
+	// nobody reads downstream, the copy to it is stuck in its write
+	_, err := server.Write([]byte("hello"))
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	hijacked := make(chan error, 1)
+	go func() {
+		_, _, _, err := s.Hijack()
+		hijacked <- err
+	}()
+	select {
+	case err := <-hijacked:
+		assert.Nil(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("Hijack waited for the stuck write")
+	}
+	assert.True(t, s.Wait().Hijacked)
+
+	// the write deadline is gone again
+	go client.Read(make([]byte, 5))
+	_, err = downstream.Write([]byte("again"))
+	assert.Nil(t, err)
+}
+
+func TestHijackUnsupported(t *testing.T) {
+	piper := netplus.NewPiper(nil, time.Minute)
+
+	dr, dw := io.Pipe()
+	ur, uw := io.Pipe()
+	s := piper.Start(context.Background(), &pipeConn{dr, dw}, &pipeConn{ur, uw})
+	for !s.IsRunning() {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	_, _, _, err := s.Hijack()
+	assert.Equal(t, err, netplus.ErrHijackUnsupported)
+	assert.True(t, s.IsRunning())
+	dw.Close()
+	s.Wait()
+}
+
+// pipeConn is a connection without deadlines
+type pipeConn struct {
+	*io.PipeReader
+	*io.PipeWriter
+}
+
+func (c *pipeConn) Close() error {
+	c.PipeWriter.Close()
+	return c.PipeReader.Close()
+}
@@ -0,0 +1,67 @@
+package netplus
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+// histogramBuckets is enough power-of-two buckets for any int32 sized value
+const histogramBuckets = 32
+
+// HistogramBucket counts the values v with Min <= v <= Max
+type HistogramBucket struct {
+	Min   int64 `json:"min"`
+	Max   int64 `json:"max"`
+	Count int64 `json:"count"`
+}
+
+// histogram is a power-of-two histogram safe for concurrent use
+// bucket 0 holds zero and one, bucket i holds [2^i, 2^(i+1)), the last one holds everything larger
+type histogram [histogramBuckets]int64
+
+func (h *histogram) add(v int64) {
+	i := 0
+	if v > 1 {
+		i = bits.Len64(uint64(v)) - 1
+	}
+	if i >= histogramBuckets {
+		i = histogramBuckets - 1
+	}
+	atomic.AddInt64(&h[i], 1)
+}
+
+// buckets returns a snapshot of h up to the last non empty bucket
+func (h *histogram) buckets() []HistogramBucket {
+	return h.bucketsUpTo(histogramBuckets)
+}
+
+// bucketsUpTo is buckets for a histogram whose values were capped to 2^(n-1),
+// the last of its n buckets holds everything larger
+func (h *histogram) bucketsUpTo(n int) []HistogramBucket {
+	last := -1
+	counts := make([]int64, n)
+	for i := range counts {
+		counts[i] = atomic.LoadInt64(&h[i])
+		if counts[i] > 0 {
+			last = i
+		}
+	}
+	out := make([]HistogramBucket, 0, last+1)
+	for i := 0; i <= last; i++ {
+		b := HistogramBucket{Min: int64(1) << i, Max: int64(1)<<(i+1) - 1, Count: counts[i]}
+		if i == 0 {
+			b.Min = 0
+		}
+		if i == n-1 {
+			b.Max = 1<<63 - 1
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+func (h *histogram) reset() {
+	for i := range h {
+		atomic.StoreInt64(&h[i], 0)
+	}
+}
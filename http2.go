@@ -0,0 +1,74 @@
+package netplus
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// HTTP2PipeHandler is an http.Handler piping every request stream to its own upstream
+// served by an HTTP/2 capable http.Server each stream of a connection is piped
+// concurrently, the request body is sent upstream and the upstream replies are streamed
+// back as the response body, the Piper timeout applies to every stream on its own
+// HTTP/1 requests are piped too but their response cannot start before the body is read
+type HTTP2PipeHandler struct {
+	Piper *Piper
+	// Dial returns the upstream for the stream of r
+	Dial func(ctx context.Context, r *http.Request) (io.ReadWriteCloser, error)
+}
+
+// ServeHTTP implements http.Handler
+func (h *HTTP2PipeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upstream, err := h.Dial(r.Context(), r)
+	if err != nil {
+		h.Piper.logError("netplus: dialling upstream for stream", r.URL, "failed:", err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	h.Piper.Run(r.Context(), &streamConn{body: r.Body, w: w, flusher: flusher}, upstream)
+}
+
+// streamConn is the downstream side of one request stream
+type streamConn struct {
+	body    io.ReadCloser
+	w       io.Writer
+	flusher http.Flusher
+	// mux is held across the closed check and the write, so no write reaches
+	// the ResponseWriter once Close returned and ServeHTTP may have too
+	mux    sync.Mutex
+	closed bool
+}
+
+func (s *streamConn) Read(b []byte) (int, error) {
+	return s.body.Read(b)
+}
+
+func (s *streamConn) Write(b []byte) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := s.w.Write(b)
+	if err == nil && s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return n, err
+}
+
+// Close ends the request body, the response ends once ServeHTTP returns
+// it waits for a Write in progress, one blocked by flow control ends with the
+// request context
+func (s *streamConn) Close() error {
+	err := s.body.Close()
+	s.mux.Lock()
+	s.closed = true
+	s.mux.Unlock()
+	return err
+}
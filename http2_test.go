@@ -0,0 +1,68 @@
+package netplus_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestHTTP2PipeHandler(t *testing.T) {
+	upstream := echoServer(t)
+	defer upstream.Close()
+	ts := httptest.NewUnstartedServer(&netplus.HTTP2PipeHandler{
+		Piper: netplus.NewPiper(&recordingLogger{}, time.Minute),
+		Dial: func(ctx context.Context, r *http.Request) (io.ReadWriteCloser, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", upstream.Addr().String())
+		},
+	})
+	var conns int32
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	// open both streams before writing to either
+	type stream struct {
+		w    *io.PipeWriter
+		resp *http.Response
+	}
+	var streams []stream
+	for i := 0; i < 2; i++ {
+		body, w := io.Pipe()
+		req, err := http.NewRequest("POST", ts.URL, body)
+		assert.Nil(t, err)
+		resp, err := ts.Client().Do(req)
+		assert.Nil(t, err)
+		assert.Equal(t, resp.ProtoMajor, 2)
+		streams = append(streams, stream{w, resp})
+	}
+	assert.Equal(t, atomic.LoadInt32(&conns), int32(1))
+
+	for i, msg := range []string{"ping", "pong"} {
+		_, err := streams[i].w.Write([]byte(msg))
+		assert.Nil(t, err)
+	}
+	for i, msg := range []string{"ping", "pong"} {
+		b := make([]byte, len(msg))
+		_, err := io.ReadFull(streams[i].resp.Body, b)
+		assert.Nil(t, err)
+		assert.Equal(t, string(b), msg)
+	}
+	for _, s := range streams {
+		s.w.Close()
+		s.resp.Body.Close()
+	}
+}
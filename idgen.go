@@ -0,0 +1,70 @@
+package netplus
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// IDGenerator returns the connection IDs of new sessions, Next is called
+// concurrently and must not return the same ID twice
+type IDGenerator interface {
+	Next() string
+}
+
+// WithIDGenerator makes the Piper take its connection IDs from g
+// instead of counting from 1
+func WithIDGenerator(g IDGenerator) Option {
+	return func(p *Piper) {
+		p.idGenerator = g
+	}
+}
+
+type sequentialIDs struct {
+	prefix string
+	last   uint64
+}
+
+// SequentialIDGenerator returns prefix followed by an integer counting from 1
+func SequentialIDGenerator(prefix string) IDGenerator {
+	return &sequentialIDs{prefix: prefix}
+}
+
+func (g *sequentialIDs) Next() string {
+	return g.prefix + strconv.FormatUint(atomic.AddUint64(&g.last, 1), 10)
+}
+
+type uuidIDs struct{}
+
+// UUIDIDGenerator returns random version 4 UUIDs
+func UUIDIDGenerator() IDGenerator {
+	return uuidIDs{}
+}
+
+func (uuidIDs) Next() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("netplus: reading random bytes: " + err.Error())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+type timestampIDs struct {
+	layout string
+	last   uint64
+}
+
+// TimestampIDGenerator returns the current time formatted with layout followed
+// by a dash and a counter, so sessions started within the same tick differ
+func TimestampIDGenerator(layout string) IDGenerator {
+	return &timestampIDs{layout: layout}
+}
+
+func (g *timestampIDs) Next() string {
+	n := atomic.AddUint64(&g.last, 1)
+	return time.Now().Format(g.layout) + "-" + strconv.FormatUint(n, 10)
+}
@@ -0,0 +1,41 @@
+package netplus_test
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestIDGenerators(t *testing.T) {
+	seq := netplus.SequentialIDGenerator("conn-")
+	assert.Equal(t, seq.Next(), "conn-1")
+	assert.Equal(t, seq.Next(), "conn-2")
+
+	uuid := netplus.UUIDIDGenerator()
+	id := uuid.Next()
+	assert.True(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(id), id)
+	assert.NotEqual(t, uuid.Next(), id)
+
+	ts := netplus.TimestampIDGenerator("20060102")
+	a, b := ts.Next(), ts.Next()
+	assert.True(t, strings.HasPrefix(a, time.Now().Format("20060102")+"-"), a)
+	assert.NotEqual(t, a, b)
+}
+
+func TestWithIDGenerator(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithIDGenerator(netplus.SequentialIDGenerator("tenant-")))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer server.Close()
+	s := piper.Start(context.Background(), downstream, upstream)
+	assert.Equal(t, s.ID(), "tenant-1")
+	client.Close()
+	s.Wait()
+}
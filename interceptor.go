@@ -0,0 +1,61 @@
+package netplus
+
+import "net"
+
+// InterceptorConn wraps inner and passes the data of every Read through onRead
+// and of every Write through onWrite, they may return other bytes to use
+// instead or an error to fail the call, a nil hook leaves its side alone
+// for a single side of a pipe this is simpler than a PipeChain Transform
+func InterceptorConn(inner net.Conn, onRead, onWrite func(b []byte) ([]byte, error)) net.Conn {
+	return &interceptorConn{Conn: inner, onRead: onRead, onWrite: onWrite}
+}
+
+type interceptorConn struct {
+	net.Conn
+	onRead, onWrite func(b []byte) ([]byte, error)
+
+	// pending holds what onRead returned beyond the buffer of the last Read
+	// and readErr the error of the inner Read returned after it
+	pending []byte
+	readErr error
+}
+
+func (c *interceptorConn) Read(b []byte) (int, error) {
+	if c.onRead == nil {
+		return c.Conn.Read(b)
+	}
+	// a hook dropping all the bytes of a read makes it read again
+	for len(c.pending) == 0 && c.readErr == nil {
+		n, err := c.Conn.Read(b)
+		if n > 0 {
+			out, herr := c.onRead(b[:n])
+			if herr != nil {
+				return 0, herr
+			}
+			c.pending = out
+		}
+		c.readErr = err
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	if len(c.pending) > 0 {
+		return n, nil
+	}
+	err := c.readErr
+	c.readErr = nil
+	return n, err
+}
+
+func (c *interceptorConn) Write(b []byte) (int, error) {
+	if c.onWrite == nil {
+		return c.Conn.Write(b)
+	}
+	out, err := c.onWrite(b)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,49 @@
+package netplus_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestInterceptorConn(t *testing.T) {
+	errBlocked := errors.New("blocked")
+	inner, peer := net.Pipe()
+	conn := netplus.InterceptorConn(inner,
+		func(b []byte) ([]byte, error) {
+			return append(bytes.ToUpper(b), '!'), nil
+		}, nil)
+
+	go func() {
+		peer.Write([]byte("hello"))
+		peer.Close()
+	}()
+	// the byte added by the hook does not fit and comes with the next read
+	b := make([]byte, 5)
+	n, err := conn.Read(b)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b[:n]), "HELLO")
+	rest, err := io.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, string(rest), "!")
+
+	inner, peer = net.Pipe()
+	conn = netplus.InterceptorConn(inner, nil, func(b []byte) ([]byte, error) {
+		if bytes.Contains(b, []byte("secret")) {
+			return nil, errBlocked
+		}
+		return bytes.ReplaceAll(b, []byte("a"), []byte("aa")), nil
+	})
+	defer conn.Close()
+	go io.ReadFull(peer, make([]byte, 3))
+	n, err = conn.Write([]byte("ab"))
+	assert.Nil(t, err)
+	assert.Equal(t, n, 2)
+	_, err = conn.Write([]byte("secret"))
+	assert.Equal(t, err, errBlocked)
+}
@@ -0,0 +1,74 @@
+package netplus
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ActiveSession describes one running session in the Piper.ServeHTTP listing
+type ActiveSession struct {
+	ID              string `json:"id"`
+	Src             string `json:"src,omitempty"`
+	Dst             string `json:"dst,omitempty"`
+	BytesUpstream   int64  `json:"bytes_upstream"`
+	BytesDownstream int64  `json:"bytes_downstream"`
+	Duration        string `json:"duration"`
+	// BytesPerSecond is the rate in both directions over the last 10 seconds,
+	// or since the session started when it is younger
+	BytesPerSecond float64           `json:"bytes_per_second"`
+	Labels         map[string]string `json:"labels,omitempty"`
+}
+
+// ServeHTTP renders the active sessions as a JSON array, oldest first
+// so a Piper can be mounted as an admin endpoint
+func (p *Piper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	list := []ActiveSession{}
+	var starts []time.Time
+	p.sessions.Range(func(_, v interface{}) bool {
+		s := v.(*Session)
+		s.stateMux.Lock()
+		start := s.start
+		list = append(list, ActiveSession{
+			ID:              s.id,
+			Src:             s.src,
+			Dst:             s.dst,
+			BytesUpstream:   s.BytesWritten(DirectionUpstream),
+			BytesDownstream: s.BytesWritten(DirectionDownstream),
+			Duration:        now.Sub(start).String(),
+			BytesPerSecond:  s.rate(now.Sub(start)),
+			Labels:          s.labels,
+		})
+		s.stateMux.Unlock()
+		starts = append(starts, start)
+		return true
+	})
+	sort.Sort(byStart{list, starts})
+	writeJSON(w, list)
+}
+
+type byStart struct {
+	list   []ActiveSession
+	starts []time.Time
+}
+
+func (b byStart) Len() int           { return len(b.list) }
+func (b byStart) Less(i, j int) bool { return b.starts[i].Before(b.starts[j]) }
+func (b byStart) Swap(i, j int) {
+	b.list[i], b.list[j] = b.list[j], b.list[i]
+	b.starts[i], b.starts[j] = b.starts[j], b.starts[i]
+}
+
+// activeRateWindow is the number of seconds BytesPerSecond averages over
+const activeRateWindow = 10
+
+// rate returns the bytes per second written over the last activeRateWindow
+// seconds of a session running for age, it leaves the session as it is
+func (s *Session) rate(age time.Duration) float64 {
+	n := int(age/time.Second) + 1
+	if n > activeRateWindow {
+		n = activeRateWindow
+	}
+	return s.rates.recent(s.clock.Now(), n)
+}
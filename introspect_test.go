@@ -0,0 +1,52 @@
+package netplus_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestPiperServeHTTP(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+
+	client, downstream := tcpPair(t)
+	upstream, server := tcpPair(t)
+	defer server.Close()
+	s := piper.Start(context.Background(), downstream, upstream)
+	client.Write([]byte("hello"))
+	_, err := io.ReadFull(server, make([]byte, 5))
+	assert.Nil(t, err)
+	for s.BytesWritten(netplus.DirectionUpstream) < 5 {
+		time.Sleep(time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	piper.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, rec.Header().Get("Content-Type"), "application/json")
+	var list []netplus.ActiveSession
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Len(t, list, 1)
+	assert.Equal(t, list[0].ID, s.ID())
+	assert.Equal(t, list[0].Src, client.LocalAddr().String())
+	assert.Equal(t, list[0].Dst, server.LocalAddr().String())
+	assert.Equal(t, list[0].BytesUpstream, int64(5))
+	assert.Gt(t, list[0].BytesPerSecond, float64(0))
+
+	// listing again does not reset the rate
+	rec = httptest.NewRecorder()
+	piper.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Gt(t, list[0].BytesPerSecond, float64(0))
+
+	client.Close()
+	s.Wait()
+	rec = httptest.NewRecorder()
+	piper.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, rec.Body.String(), "[]\n")
+}
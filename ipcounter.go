@@ -0,0 +1,116 @@
+package netplus
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// ipCounterTTL is how long an idle per-IP counter is kept around
+	ipCounterTTL = time.Minute
+	// ipCounterCapacity is the number of idle per-IP counters kept before the
+	// least recently used ones are evicted
+	ipCounterCapacity = 64 * 1024
+)
+
+// ipCounter counts active sessions per source IP
+// idle counters are held in an LRU list so idle IPs expire instead of growing the map forever,
+// counters with active sessions are kept out of the list
+type ipCounter struct {
+	mux     sync.Mutex
+	limit   int
+	entries map[string]*ipEntry
+	lru     *list.List // idle entries, most recently released first
+}
+
+type ipEntry struct {
+	ip     string
+	active int
+	seen   time.Time
+	el     *list.Element // nil while active
+}
+
+func newIPCounter(limit int) *ipCounter {
+	return &ipCounter{
+		limit:   limit,
+		entries: make(map[string]*ipEntry),
+		lru:     list.New(),
+	}
+}
+
+// acquire reserves a session slot for ip, it returns false when the limit is reached
+func (c *ipCounter) acquire(ip string) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	now := time.Now()
+	c.evict(now)
+
+	e, ok := c.entries[ip]
+	if !ok {
+		e = &ipEntry{ip: ip}
+		c.entries[ip] = e
+	}
+	if e.active >= c.limit {
+		if e.active == 0 && e.el == nil {
+			c.idle(e, now)
+		}
+		return false
+	}
+	if e.el != nil {
+		c.lru.Remove(e.el)
+		e.el = nil
+	}
+	e.active++
+	return true
+}
+
+// release frees a slot reserved by acquire
+func (c *ipCounter) release(ip string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	e, ok := c.entries[ip]
+	if !ok || e.active == 0 {
+		return
+	}
+	if e.active--; e.active == 0 {
+		c.idle(e, time.Now())
+	}
+}
+
+// idle puts e at the front of the LRU list
+func (c *ipCounter) idle(e *ipEntry, now time.Time) {
+	e.seen = now
+	e.el = c.lru.PushFront(e)
+}
+
+// evict drops idle counters that have expired or overflow the capacity
+// the list is ordered by the time the counters became idle so it stops
+// at the first one that is kept
+func (c *ipCounter) evict(now time.Time) {
+	for el := c.lru.Back(); el != nil; el = c.lru.Back() {
+		e := el.Value.(*ipEntry)
+		if now.Sub(e.seen) <= ipCounterTTL && c.lru.Len() <= ipCounterCapacity {
+			return
+		}
+		c.lru.Remove(el)
+		delete(c.entries, e.ip)
+	}
+}
+
+// remoteIP returns the host part of rwc's remote address if it has one
+func remoteIP(rwc interface{}) (string, bool) {
+	conn, ok := rwc.(interface{ RemoteAddr() net.Addr })
+	if !ok || conn.RemoteAddr() == nil {
+		return "", false
+	}
+	addr := conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, true
+	}
+	return host, true
+}
@@ -0,0 +1,29 @@
+package netplus
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestIPCounterEvict(t *testing.T) {
+	c := newIPCounter(1)
+	assert.True(t, c.acquire("active"))
+	for i := 0; i < ipCounterCapacity+10; i++ {
+		ip := strconv.Itoa(i)
+		assert.True(t, c.acquire(ip))
+		c.release(ip)
+	}
+	assert.True(t, c.acquire("new"))
+	assert.Equal(t, c.lru.Len(), ipCounterCapacity)
+	// the oldest idle counters went first, active ones are kept
+	_, ok := c.entries["0"]
+	assert.False(t, ok)
+	assert.False(t, c.acquire("active"))
+
+	c.evict(time.Now().Add(2 * ipCounterTTL))
+	assert.Equal(t, c.lru.Len(), 0)
+	assert.Len(t, c.entries, 2)
+}
@@ -0,0 +1,117 @@
+package netplus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ErrNotAllowed is returned by AllowlistMiddleware and DenylistMiddleware for rejected connections
+var ErrNotAllowed = errors.New("connection not allowed")
+
+// AllowlistMiddleware accepts only connections whose remote IP is in one of cidrs
+// entries without a prefix length match a single IP, an entry that does not
+// parse is returned as an error
+func AllowlistMiddleware(cidrs []string) (ConnectionMiddleware, error) {
+	prefixes, err := parsePrefixes(cidrs)
+	if err != nil {
+		return nil, err
+	}
+	return AllowlistPrefixMiddleware(prefixes), nil
+}
+
+// AllowlistPrefixMiddleware accepts only connections whose remote IP is in one of prefixes
+func AllowlistPrefixMiddleware(prefixes []netip.Prefix) ConnectionMiddleware {
+	prefixes = maskPrefixes(prefixes)
+	return func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+		if !containsAddr(prefixes, conn) {
+			return nil, fmt.Errorf("%w: %s is not in the allowlist", ErrNotAllowed, conn.RemoteAddr())
+		}
+		return conn, nil
+	}
+}
+
+// DenylistMiddleware rejects connections whose remote IP is in one of cidrs
+// entries without a prefix length match a single IP, an entry that does not
+// parse is returned as an error
+func DenylistMiddleware(cidrs []string) (ConnectionMiddleware, error) {
+	prefixes, err := parsePrefixes(cidrs)
+	if err != nil {
+		return nil, err
+	}
+	return DenylistPrefixMiddleware(prefixes), nil
+}
+
+// DenylistPrefixMiddleware rejects connections whose remote IP is in one of prefixes
+func DenylistPrefixMiddleware(prefixes []netip.Prefix) ConnectionMiddleware {
+	prefixes = maskPrefixes(prefixes)
+	return func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+		if containsAddr(prefixes, conn) {
+			return nil, fmt.Errorf("%w: %s is in the denylist", ErrNotAllowed, conn.RemoteAddr())
+		}
+		return conn, nil
+	}
+}
+
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			addr, err := netip.ParseAddr(c)
+			if err != nil {
+				return nil, fmt.Errorf("netplus: %w", err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("netplus: %w", err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+// maskPrefixes returns a copy of prefixes with the host bits cleared, as
+// net.ParseCIDR does, IPv4-mapped prefixes become IPv4 ones
+func maskPrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	masked := make([]netip.Prefix, 0, len(prefixes))
+	for _, p := range prefixes {
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		masked = append(masked, p.Masked())
+	}
+	return masked
+}
+
+// containsAddr reports whether the remote IP of conn is in one of prefixes
+func containsAddr(prefixes []netip.Prefix, conn net.Conn) bool {
+	addr, ok := connAddr(conn)
+	if !ok {
+		return false
+	}
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// connAddr returns the remote IP of conn if it has one
+func connAddr(conn net.Conn) (netip.Addr, bool) {
+	if ap, ok := addrPortFromNetAddr(conn.RemoteAddr()); ok {
+		return ap.Addr(), true
+	}
+	host, ok := remoteIP(conn)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	addr, err := netip.ParseAddr(host)
+	return addr.Unmap(), err == nil
+}
@@ -0,0 +1,69 @@
+package netplus_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func connFrom(ip string) net.Conn {
+	c, _ := net.Pipe()
+	return &addrConn{c, &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}}
+}
+
+func TestAllowlistMiddleware(t *testing.T) {
+	mw, err := netplus.AllowlistMiddleware([]string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"})
+	assert.Nil(t, err)
+	for ip, allowed := range map[string]bool{
+		"10.1.2.3":    true,
+		"192.168.1.1": true,
+		"192.168.1.2": false,
+		"2001:db8::1": true,
+		"2001:db9::1": false,
+	} {
+		_, err := mw(context.Background(), connFrom(ip))
+		assert.Equal(t, err == nil, allowed, ip)
+		if !allowed {
+			assert.True(t, errors.Is(err, netplus.ErrNotAllowed), ip)
+		}
+	}
+}
+
+func TestDenylistMiddleware(t *testing.T) {
+	mw, err := netplus.DenylistMiddleware([]string{"10.0.0.0/8"})
+	assert.Nil(t, err)
+	_, err = mw(context.Background(), connFrom("10.1.2.3"))
+	assert.True(t, errors.Is(err, netplus.ErrNotAllowed))
+	_, err = mw(context.Background(), connFrom("11.1.2.3"))
+	assert.Nil(t, err)
+}
+
+func TestIPFilterInvalidEntry(t *testing.T) {
+	_, err := netplus.AllowlistMiddleware([]string{"10.0.0.0/8", "not an ip"})
+	assert.NotNil(t, err)
+	_, err = netplus.DenylistMiddleware([]string{"10.0.0.0/33"})
+	assert.NotNil(t, err)
+}
+
+func TestPrefixMiddleware(t *testing.T) {
+	prefixes := []netip.Prefix{netip.MustParsePrefix("10.1.2.3/8"), netip.MustParsePrefix("::ffff:192.168.0.0/112")}
+	allow := netplus.AllowlistPrefixMiddleware(prefixes)
+	deny := netplus.DenylistPrefixMiddleware(prefixes)
+	for ip, listed := range map[string]bool{
+		"10.200.0.1":      true,
+		"192.168.3.4":     true,
+		"::ffff:10.0.0.1": true,
+		"11.0.0.1":        false,
+		"2001:db8::1":     false,
+	} {
+		_, err := allow(context.Background(), connFrom(ip))
+		assert.Equal(t, err == nil, listed, ip)
+		_, err = deny(context.Background(), connFrom(ip))
+		assert.Equal(t, err == nil, !listed, ip)
+	}
+}
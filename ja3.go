@@ -0,0 +1,58 @@
+package netplus
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ProxyOption configures an AutoProxy created by NewAutoProxy
+type ProxyOption func(*AutoProxy)
+
+// WithTLSFingerprintHook calls fn with the JA3 fingerprint of the ClientHello of
+// every TLS connection before it is piped, an error from fn drops the connection
+// connections that do not start with a ClientHello are piped without calling fn
+func WithTLSFingerprintHook(fn func(fingerprint string, conn net.Conn) error) ProxyOption {
+	return func(ap *AutoProxy) {
+		ap.fingerprintHook = fn
+	}
+}
+
+// ja3 returns the JA3 fingerprint of the ClientHello, the MD5 of ja3String
+func (h *clientHello) ja3() string {
+	sum := md5.Sum([]byte(h.ja3String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// ja3String lists the version, ciphers, extensions, curves and point formats
+// of the ClientHello as JA3 does, GREASE values left out
+func (h *clientHello) ja3String() string {
+	formats := make([]uint16, len(h.pointFormats))
+	for i, f := range h.pointFormats {
+		formats[i] = uint16(f)
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(h.version)),
+		ja3List(h.ciphers),
+		ja3List(h.extensions),
+		ja3List(h.curves),
+		ja3List(formats),
+	}, ",")
+}
+
+func ja3List(list []uint16) string {
+	values := make([]string, 0, len(list))
+	for _, v := range list {
+		if !isGREASE(v) {
+			values = append(values, strconv.Itoa(int(v)))
+		}
+	}
+	return strings.Join(values, "-")
+}
+
+// isGREASE reports whether v is one of the reserved 0x?a?a values of RFC 8701
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
@@ -0,0 +1,51 @@
+package netplus
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+// buildClientHello returns a TLS record holding a ClientHello with the given extensions
+func buildClientHello(ciphers []uint16, exts map[uint16][]byte, order []uint16) []byte {
+	u16 := func(v int) []byte { return []byte{byte(v >> 8), byte(v)} }
+	var body bytes.Buffer
+	body.Write(u16(0x0303))
+	body.Write(make([]byte, 32))
+	body.WriteByte(0) // session id
+	body.Write(u16(2 * len(ciphers)))
+	for _, c := range ciphers {
+		body.Write(u16(int(c)))
+	}
+	body.Write([]byte{1, 0}) // compression methods
+	var ext bytes.Buffer
+	for _, typ := range order {
+		ext.Write(u16(int(typ)))
+		ext.Write(u16(len(exts[typ])))
+		ext.Write(exts[typ])
+	}
+	body.Write(u16(ext.Len()))
+	body.Write(ext.Bytes())
+
+	hs := append([]byte{1, 0, byte(body.Len() >> 8), byte(body.Len())}, body.Bytes()...)
+	return append([]byte{0x16, 3, 1, byte(len(hs) >> 8), byte(len(hs))}, hs...)
+}
+
+func TestJA3(t *testing.T) {
+	record := buildClientHello([]uint16{0x0a0a, 0x1301, 0xc02f}, map[uint16][]byte{
+		0x0a0a: {},
+		0:      {0, 8, 0, 0, 5, 'a', '.', 'c', 'o', 'm'},
+		10:     {0, 6, 0x1a, 0x1a, 0, 0x1d, 0, 0x17},
+		11:     {2, 0, 1},
+	}, []uint16{0x0a0a, 0, 10, 11})
+
+	hello, peeked, err := readClientHello(bytes.NewReader(record))
+	assert.Nil(t, err)
+	assert.Equal(t, peeked, record)
+	assert.Equal(t, hello.serverName, "a.com")
+	assert.Equal(t, hello.ja3String(), "771,4865-49199,0-10-11,29-23,0-1")
+	assert.Equal(t, len(hello.ja3()), 32)
+	assert.True(t, isGREASE(0xfafa))
+	assert.False(t, isGREASE(0x0a1a))
+}
@@ -0,0 +1,23 @@
+//go:build dragonfly || freebsd || linux || netbsd || solaris
+
+package netplus
+
+import (
+	"syscall"
+	"time"
+)
+
+// setKeepaliveProbesFD sets the interval between keepalive probes and how many
+// unanswered ones drop the connection, zero values are left alone
+func setKeepaliveProbesFD(fd uintptr, interval time.Duration, count int) error {
+	if interval > 0 {
+		secs := int((interval + time.Second - 1) / time.Second)
+		if err := setsockoptIntFD(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, secs); err != nil {
+			return err
+		}
+	}
+	if count > 0 {
+		return setsockoptIntFD(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, count)
+	}
+	return nil
+}
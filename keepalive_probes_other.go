@@ -0,0 +1,12 @@
+//go:build !dragonfly && !freebsd && !linux && !netbsd && !solaris
+
+package netplus
+
+import "time"
+
+func setKeepaliveProbesFD(fd uintptr, interval time.Duration, count int) error {
+	if interval > 0 || count > 0 {
+		return errSockoptUnsupported
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package netplus
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+)
+
+// RunLabeled is Run for a session tagged with labels such as the tenant it belongs to
+// the labels prefix the log lines of the session and are kept in its RunStats
+// and SessionStats, so one Piper can be shared and still report per tenant
+func (p *Piper) RunLabeled(ctx context.Context, downstream, upstream io.ReadWriteCloser, labels map[string]string) (RunStats, error) {
+	s := p.newSession()
+	s.setLabels(labels)
+	return p.runSession(ctx, s, downstream, upstream)
+}
+
+// setLabels stores a copy of labels and renders them for the log lines
+func (s *Session) setLabels(labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	s.labels = make(map[string]string, len(labels))
+	keys := make([]string, 0, len(labels))
+	for k, v := range labels {
+		s.labels[k] = v
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(k + "=" + labels[k])
+	}
+	b.WriteString("] ")
+	s.labelPrefix = b.String()
+}
+
+// Labels returns the labels the session was started with
+func (s *Session) Labels() map[string]string {
+	return s.labels
+}
+
+// logArgs prefixes a log line of the session with its labels
+func (s *Session) logArgs(args ...interface{}) []interface{} {
+	if s.labelPrefix == "" {
+		return args
+	}
+	return append([]interface{}{s.labelPrefix}, args...)
+}
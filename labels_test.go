@@ -0,0 +1,50 @@
+package netplus_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestRunLabeled(t *testing.T) {
+	logger := &recordingLogger{}
+	piper := netplus.NewPiper(logger, time.Minute)
+	labels := map[string]string{"tenant": "acme", "service": "api"}
+
+	reader, _ := io.Pipe()
+	upstream, server := net.Pipe()
+	go server.Write([]byte("hello"))
+	stats, err := piper.RunLabeled(context.Background(), &failingWriter{reader}, upstream, labels)
+	assert.NotNil(t, err)
+	assert.Equal(t, stats.Labels, labels)
+	assert.Len(t, logger.Lines(), 1)
+	assert.Contains(t, logger.Lines()[0], "[service=api tenant=acme] netplus: write failed:")
+}
+
+func TestSessionStatsLabels(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithIDGenerator(netplus.SequentialIDGenerator("s")))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer server.Close()
+	done := make(chan netplus.RunStats, 1)
+	go func() {
+		stats, _ := piper.RunLabeled(context.Background(), downstream, upstream, map[string]string{"tenant": "acme"})
+		done <- stats
+	}()
+
+	var stats netplus.SessionStats
+	ok := false
+	for !ok {
+		stats, ok = piper.SessionStats("s1")
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, stats.Labels["tenant"], "acme")
+	client.Close()
+	assert.Equal(t, (<-done).Labels["tenant"], "acme")
+}
@@ -0,0 +1,113 @@
+package netplus_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+// goroutines returns the stacks of all goroutines by goroutine header, the
+// ones running net or runtime code for netplus included, the test goroutines
+// themselves excluded
+func goroutines() map[string]string {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	stacks := map[string]string{}
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(g, "testing.tRunner") {
+			continue
+		}
+		header := g[:strings.IndexByte(g, '\n')]
+		// the state in brackets changes, the ID does not
+		stacks[header[:strings.IndexByte(header, '[')]] = g
+	}
+	return stacks
+}
+
+// checkLeaks fails the test when a goroutine started since it was called is
+// still running 100ms after the returned func is called, like goleak.VerifyNone
+// without the dependency
+func checkLeaks(t *testing.T) func() {
+	before := goroutines()
+	return func() {
+		t.Helper()
+		deadline := time.Now().Add(100 * time.Millisecond)
+		for {
+			var leaked bytes.Buffer
+			for id, stack := range goroutines() {
+				if _, ok := before[id]; !ok {
+					leaked.WriteString(stack + "\n\n")
+				}
+			}
+			if leaked.Len() == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("leaked goroutines:\n%s", leaked.String())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
+
+func TestGoroutineLeakOnNormalClose(t *testing.T) {
+	verify := checkLeaks(t)
+	piper := netplus.NewPiper(nil, time.Minute)
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer server.Close()
+	done := make(chan struct{})
+	go func() {
+		piper.Run(context.Background(), downstream, upstream)
+		close(done)
+	}()
+	go io.Copy(io.Discard, server)
+	client.Write([]byte("hello"))
+	client.Close()
+	<-done
+	verify()
+}
+
+func TestGoroutineLeakOnContextCancel(t *testing.T) {
+	verify := checkLeaks(t)
+	piper := netplus.NewPiper(nil, time.Minute)
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		piper.Run(ctx, downstream, upstream)
+		close(done)
+	}()
+	client.Write([]byte("x"))
+	_, err := io.ReadFull(server, make([]byte, 1))
+	assert.Nil(t, err)
+	cancel()
+	<-done
+	verify()
+}
+
+func TestGoroutineLeakOnIdleTimeout(t *testing.T) {
+	verify := checkLeaks(t)
+	piper := netplus.NewPiper(nil, 20*time.Millisecond)
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	_, err := piper.Run(context.Background(), downstream, upstream)
+	assert.NotNil(t, err)
+	verify()
+}
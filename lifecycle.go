@@ -0,0 +1,78 @@
+package netplus
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrPiperClosed is returned by Run once Close has been called
+var ErrPiperClosed = errors.New("piper closed")
+
+// Close ends all the active sessions of the Piper, their Run returns an error
+// wrapping context.Canceled, and makes every later Run return ErrPiperClosed
+// the same goes for RunN, RunOnce, RunFan and RunMerge, the background writer
+// of WithAuditLogAsync is stopped
+// it does not wait for the sessions, use WaitIdle for that
+func (p *Piper) Close() error {
+	if !atomic.CompareAndSwapInt32(&p.closed, 0, 1) {
+		return nil
+	}
+	close(p.closing)
+	if p.audit != nil {
+		p.audit.close()
+	}
+	p.sessions.Range(func(_, v interface{}) bool {
+		s := v.(*Session)
+		s.stateMux.Lock()
+		stop := s.stop
+		s.stateMux.Unlock()
+		if stop != nil {
+			stop()
+		}
+		return true
+	})
+	return nil
+}
+
+// isClosed reports whether Close has been called
+func (p *Piper) isClosed() bool {
+	return atomic.LoadInt32(&p.closed) == 1
+}
+
+// withClose returns ctx cancelled by Close as well, the sessions of runSession
+// are ended through Session.stop instead, which needs no goroutine of its own
+func (p *Piper) withClose(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx.Done() == nil {
+		return closedContext{ctx, p.closing}, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-p.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// closedContext wraps a ctx that is never done on its own, it is done once
+// closing is closed
+type closedContext struct {
+	context.Context
+	closing chan struct{}
+}
+
+func (c closedContext) Done() <-chan struct{} {
+	return c.closing
+}
+
+func (c closedContext) Err() error {
+	select {
+	case <-c.closing:
+		return context.Canceled
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,130 @@
+package netplus_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestPiperClose(t *testing.T) {
+	verify := checkLeaks(t)
+	piper := netplus.NewPiper(nil, time.Minute, netplus.WithPoolReclaimInterval(time.Millisecond))
+
+	errs := make(chan error, 2)
+	for _, run := range []func(d, u net.Conn) (int64, error){
+		func(d, u net.Conn) (int64, error) { return piper.Run(context.Background(), d, u) },
+		func(d, u net.Conn) (int64, error) { return piper.RunConn(context.Background(), d, u) },
+	} {
+		client, downstream := net.Pipe()
+		upstream, server := net.Pipe()
+		defer client.Close()
+		defer server.Close()
+		go func(run func(d, u net.Conn) (int64, error)) {
+			_, err := run(downstream, upstream)
+			errs <- err
+		}(run)
+		client.Write([]byte("x"))
+		_, err := io.ReadFull(server, make([]byte, 1))
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, piper.Close())
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			assert.True(t, errors.Is(err, context.Canceled))
+		case <-time.After(time.Second):
+			t.Fatal("session still running after Close")
+		}
+	}
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	_, err := piper.Run(context.Background(), downstream, upstream)
+	assert.Equal(t, err, netplus.ErrPiperClosed)
+	assert.Nil(t, piper.Close())
+	verify()
+}
+
+func TestPiperCloseWaitingForSlot(t *testing.T) {
+	piper := netplus.NewPiper(nil, time.Minute, netplus.WithGoroutinePool(3))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go piper.Run(context.Background(), downstream, upstream)
+	for piper.ActiveConnections() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the second session waits for the slot of the first one
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := piper.Run(context.Background(), &pipeConn{}, &pipeConn{})
+		waiting <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	piper.Close()
+	assert.Equal(t, <-waiting, netplus.ErrPiperClosed)
+}
+
+func TestPiperCloseOtherRuns(t *testing.T) {
+	verify := checkLeaks(t)
+	piper := netplus.NewPiper(nil, time.Minute, netplus.WithAuditLogAsync(io.Discard, 4))
+
+	errs := make(chan error, 4)
+	_, src := net.Pipe()
+	go func() {
+		_, err := piper.RunOnce(context.Background(), src, io.Discard)
+		errs <- err
+	}()
+	_, fanSrc := net.Pipe()
+	_, fanDst := net.Pipe()
+	go func() {
+		_, err := piper.RunFan(context.Background(), fanSrc, []io.WriteCloser{fanDst})
+		errs <- err
+	}()
+	_, mergeSrc := net.Pipe()
+	_, mergeDst := net.Pipe()
+	go func() {
+		_, err := piper.RunMerge(context.Background(), []io.ReadCloser{mergeSrc}, mergeDst)
+		errs <- err
+	}()
+	_, downstream := net.Pipe()
+	_, upstream := net.Pipe()
+	go func() {
+		_, err := piper.RunN(context.Background(), downstream, []io.ReadWriteCloser{upstream})
+		errs <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	assert.Nil(t, piper.Close())
+	for i := 0; i < 4; i++ {
+		select {
+		case err := <-errs:
+			assert.True(t, errors.Is(err, context.Canceled), err)
+		case <-time.After(time.Second):
+			t.Fatal("run still going after Close")
+		}
+	}
+
+	_, err := piper.RunOnce(context.Background(), &pipeConn{}, io.Discard)
+	assert.Equal(t, err, netplus.ErrPiperClosed)
+	_, err = piper.RunFan(context.Background(), &pipeConn{}, nil)
+	assert.Equal(t, err, netplus.ErrPiperClosed)
+	_, err = piper.RunMerge(context.Background(), nil, &pipeConn{})
+	assert.Equal(t, err, netplus.ErrPiperClosed)
+	_, err = piper.RunN(context.Background(), &pipeConn{}, []io.ReadWriteCloser{&pipeConn{}})
+	assert.Equal(t, err, netplus.ErrPiperClosed)
+	// the audit writer is gone too
+	verify()
+}
@@ -0,0 +1,83 @@
+package netplus
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes
+var certCheckInterval = time.Second
+
+// ListenTLS listens on addr and serves TLS with the certificate in certFile and keyFile
+// the files are checked for changes during handshakes and reloaded when they change,
+// connections that are already established keep the certificate they were started with
+// a reload that fails keeps serving the previous certificate
+func ListenTLS(addr string, certFile, keyFile string) (net.Listener, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(ln, &tls.Config{GetCertificate: r.GetCertificate}), nil
+}
+
+// certReloader polls a certificate and key pair for changes
+type certReloader struct {
+	certFile, keyFile string
+
+	mux             sync.Mutex
+	cert            *tls.Certificate
+	certMod, keyMod time.Time
+	checked         time.Time
+}
+
+func (r *certReloader) load() error {
+	cfi, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	kfi, err := os.Stat(r.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.certMod = cfi.ModTime()
+	r.keyMod = kfi.ModTime()
+	r.checked = time.Now()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if time.Since(r.checked) < certCheckInterval {
+		return r.cert, nil
+	}
+	r.checked = time.Now()
+	cfi, err := os.Stat(r.certFile)
+	if err != nil {
+		return r.cert, nil
+	}
+	kfi, err := os.Stat(r.keyFile)
+	if err != nil {
+		return r.cert, nil
+	}
+	if cfi.ModTime().Equal(r.certMod) && kfi.ModTime().Equal(r.keyMod) {
+		return r.cert, nil
+	}
+	// on failure load leaves the previous certificate in place, to be retried on the next check
+	r.load()
+	return r.cert, nil
+}
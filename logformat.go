@@ -0,0 +1,109 @@
+package netplus
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.ideatocode.tech/log"
+)
+
+// PrintfLogger is a logger with a single Printf method such as the *log.Logger
+// of the standard library
+type PrintfLogger interface {
+	Printf(format string, args ...interface{})
+}
+
+// FormatLogger is a leveled logger with Printf style methods
+type FormatLogger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// NewPrintfLogger returns a log.Logger for a Piper writing to l, the
+// lines get a DEBUG, WARN or ERROR prefix since l has no levels
+func NewPrintfLogger(l PrintfLogger) log.Logger {
+	return printfLogger{l}
+}
+
+// NewFormatLogger returns a log.Logger for a Piper writing to the matching
+// level of l
+func NewFormatLogger(l FormatLogger) log.Logger {
+	return formatLogger{l}
+}
+
+type printfLogger struct {
+	l PrintfLogger
+}
+
+func (p printfLogger) Debug(args ...interface{}) { p.l.Printf("DEBUG %s", sprint(args)) }
+func (p printfLogger) Warn(args ...interface{})  { p.l.Printf("WARN %s", sprint(args)) }
+func (p printfLogger) Error(args ...interface{}) { p.l.Printf("ERROR %s", sprint(args)) }
+
+type formatLogger struct {
+	l FormatLogger
+}
+
+func (f formatLogger) Debug(args ...interface{}) { f.l.Debugf("%s", sprint(args)) }
+func (f formatLogger) Warn(args ...interface{})  { f.l.Warnf("%s", sprint(args)) }
+func (f formatLogger) Error(args ...interface{}) { f.l.Errorf("%s", sprint(args)) }
+
+// sprint joins args with spaces like the Debug of the default logger
+func sprint(args []interface{}) string {
+	s := fmt.Sprintln(args...)
+	return s[:len(s)-1]
+}
+
+// debug levels for Piper.DebugLevel and NewStderrLogger
+const (
+	// DebugOff logs no debug lines
+	DebugOff = 0
+	// DebugBasic logs the session events such as timeouts and rejections
+	DebugBasic = 1
+	// DebugVerbose also logs the steps of closing every session
+	DebugVerbose = 10000
+)
+
+// WithLogger sets the Logger of the Piper, replacing the one given to NewPiper
+func WithLogger(l log.Logger) Option {
+	return func(p *Piper) {
+		p.Logger = l
+	}
+}
+
+// NewStderrLogger returns a log.Logger writing a line with a timestamp, the
+// level and the message to os.Stderr for every warning and error and, up to
+// level, for the debug lines, for development without a logging library
+func NewStderrLogger(level int) log.Logger {
+	return &stderrLogger{w: os.Stderr, level: level}
+}
+
+type stderrLogger struct {
+	mux   sync.Mutex
+	w     io.Writer
+	level int
+}
+
+func (l *stderrLogger) Debug(args ...interface{}) {
+	if l.level > DebugOff {
+		l.write("DEBUG", args)
+	}
+}
+
+func (l *stderrLogger) Warn(args ...interface{})  { l.write("WARN", args) }
+func (l *stderrLogger) Error(args ...interface{}) { l.write("ERROR", args) }
+
+// IsEnabled skips building the debug lines above the level of the logger
+func (l *stderrLogger) IsEnabled(level int) bool {
+	return level <= l.level
+}
+
+func (l *stderrLogger) write(level string, args []interface{}) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	fmt.Fprintf(l.w, "%s %-5s %s\n", time.Now().Format("2006-01-02 15:04:05.000"), level, sprint(args))
+}
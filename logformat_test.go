@@ -0,0 +1,69 @@
+package netplus_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	stdlog "log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+type levelLogger struct {
+	lines []string
+}
+
+func (l *levelLogger) Debugf(format string, args ...interface{}) {
+	l.lines = append(l.lines, "debug: "+fmt.Sprintf(format, args...))
+}
+func (l *levelLogger) Infof(format string, args ...interface{}) {
+	l.lines = append(l.lines, "info: "+fmt.Sprintf(format, args...))
+}
+func (l *levelLogger) Warnf(format string, args ...interface{}) {
+	l.lines = append(l.lines, "warn: "+fmt.Sprintf(format, args...))
+}
+func (l *levelLogger) Errorf(format string, args ...interface{}) {
+	l.lines = append(l.lines, "error: "+fmt.Sprintf(format, args...))
+}
+
+func TestPrintfLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := netplus.NewPrintfLogger(stdlog.New(&buf, "", 0))
+	logger.Debug("a", 1)
+	logger.(interface{ Error(...interface{}) }).Error("b")
+	assert.Equal(t, buf.String(), "DEBUG a 1\nERROR b\n")
+}
+
+func TestFormatLogger(t *testing.T) {
+	l := &levelLogger{}
+	logger := netplus.NewFormatLogger(l)
+	logger.Debug("a", 1)
+	logger.(interface{ Warn(...interface{}) }).Warn("b", 2)
+	assert.Equal(t, strings.Join(l.lines, "|"), "debug: a 1|warn: b 2")
+}
+
+func TestStderrLogger(t *testing.T) {
+	stderr := os.Stderr
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	os.Stderr = w
+	logger := netplus.NewStderrLogger(netplus.DebugOff)
+	os.Stderr = stderr
+
+	piper := netplus.NewPiper(nil, time.Minute, netplus.WithLogger(logger))
+	piper.Logger.Debug("hidden")
+	piper.Logger.(interface{ Error(...interface{}) }).Error("shown", 1)
+	w.Close()
+	b, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	line := string(b)
+	assert.Contains(t, line, "ERROR shown 1\n")
+	assert.False(t, strings.Contains(line, "hidden"))
+	_, err = time.Parse("2006-01-02 15:04:05.000", line[:23])
+	assert.Nil(t, err)
+}
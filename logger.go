@@ -0,0 +1,105 @@
+package netplus
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+)
+
+// NopLogger is a log.Logger dropping everything, NewPiper uses it without a logger
+type NopLogger struct{}
+
+// Debug implements log.Logger
+func (NopLogger) Debug(args ...interface{}) {}
+
+// Warn drops a warning
+func (NopLogger) Warn(args ...interface{}) {}
+
+// Error drops an error
+func (NopLogger) Error(args ...interface{}) {}
+
+// warnLogger is implemented by loggers that have a warning level
+type warnLogger interface {
+	Warn(args ...interface{})
+}
+
+// errorLogger is implemented by loggers that have an error level
+type errorLogger interface {
+	Error(args ...interface{})
+}
+
+// levelEnabler is implemented by loggers that can tell whether they would
+// drop a debug level, so the log lines are not even built for them
+type levelEnabler interface {
+	IsEnabled(level int) bool
+}
+
+// debugEnabled reports whether the debug level is above min and the Logger
+// does not drop it, callers check it before building a log line
+func (p *Piper) debugEnabled(min int) bool {
+	if p.debugLevel <= min {
+		return false
+	}
+	if l, ok := p.Logger.(levelEnabler); ok {
+		return l.IsEnabled(p.debugLevel)
+	}
+	return true
+}
+
+// debug logs at debug level subject to WithLogSampling
+func (p *Piper) debug(args ...interface{}) {
+	if p.logSampling > 0 && !p.logBucket.allow(float64(p.logSampling), float64(p.logSampling)) {
+		atomic.AddInt64(&p.droppedLogLines, 1)
+		return
+	}
+	p.Logger.Debug(args...)
+}
+
+// logWarn logs at warning level when the logger supports it and at debug level otherwise
+// it does nothing without a Logger
+func (p *Piper) logWarn(args ...interface{}) {
+	if p.Logger == nil {
+		return
+	}
+	if l, ok := p.Logger.(warnLogger); ok {
+		l.Warn(args...)
+		return
+	}
+	p.Logger.Debug(args...)
+}
+
+// logError logs to ErrorLogger, or Logger when it is not set,
+// at error level when the logger supports it and at debug level otherwise
+// it does nothing when neither is set
+func (p *Piper) logError(args ...interface{}) {
+	l := p.ErrorLogger
+	if l == nil {
+		l = p.Logger
+	}
+	if l == nil {
+		return
+	}
+	if el, ok := l.(errorLogger); ok {
+		el.Error(args...)
+		return
+	}
+	l.Debug(args...)
+}
+
+// isTimeoutErr reports whether err is a deadline or timeout error
+func isTimeoutErr(err error) bool {
+	var ne interface{ Timeout() bool }
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// isWouldBlockErr reports whether err is the transient EAGAIN of a non blocking read
+func isWouldBlockErr(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK)
+}
+
+// isClosedErr reports whether err only says the connection was already closed
+func isClosedErr(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe)
+}
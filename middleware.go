@@ -0,0 +1,54 @@
+package netplus
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds the TLS handshake of TLSTerminateMiddleware
+const tlsHandshakeTimeout = 10 * time.Second
+
+// ConnectionMiddleware processes an accepted connection before the upstream is dialled
+// it can inspect the connection, return a wrapped one, or return an error to reject it
+type ConnectionMiddleware func(ctx context.Context, conn net.Conn) (net.Conn, error)
+
+// Use appends mw to the middleware chain, run in order on every accepted connection
+// a middleware returning an error closes the connection without starting the pipe
+func (ap *AutoProxy) Use(mw ...ConnectionMiddleware) {
+	ap.mux.Lock()
+	defer ap.mux.Unlock()
+	ap.middleware = append(ap.middleware[:len(ap.middleware):len(ap.middleware)], mw...)
+}
+
+// applyMiddleware runs the chain on conn, on error conn has been closed
+func (ap *AutoProxy) applyMiddleware(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	ap.mux.Lock()
+	chain := ap.middleware
+	ap.mux.Unlock()
+
+	for _, mw := range chain {
+		next, err := mw(ctx, conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = next
+	}
+	return conn, nil
+}
+
+// TLSTerminateMiddleware completes a TLS handshake with cfg so the upstream receives plain text
+// a client that does not finish the handshake within 10 seconds is rejected
+func TLSTerminateMiddleware(cfg *tls.Config) ConnectionMiddleware {
+	return func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+		tc := tls.Server(conn, cfg)
+		conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+		conn.SetDeadline(time.Time{})
+		return tc, nil
+	}
+}
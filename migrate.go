@@ -0,0 +1,97 @@
+package netplus
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// sessionState is the JSON form of a session moved by Export and ImportSession
+type sessionState struct {
+	ID           string            `json:"id"`
+	Src          string            `json:"src,omitempty"`
+	Dst          string            `json:"dst,omitempty"`
+	Start        time.Time         `json:"start"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Config       *PiperConfig      `json:"config,omitempty"`
+	BytesRead    [2]int64          `json:"bytes_read"`
+	BytesWritten [2]int64          `json:"bytes_written"`
+	Reads        [2]int64          `json:"reads"`
+	// IdleTimeout and IdleLeft are the idle window and what was left of it
+	IdleTimeout time.Duration `json:"idle_timeout"`
+	IdleLeft    time.Duration `json:"idle_left"`
+	// RateTokens are the tokens left in the rate limiter of each direction, nil
+	// for the ones that never limited
+	RateTokens [2]*float64 `json:"rate_tokens"`
+}
+
+// Export serializes the state of the session, its ID, counters, labels, session
+// config, idle timer and rate limiters, to JSON for ImportSession on another
+// server, the bytes in flight in the copy buffers are not part of it and are lost
+func (s *Session) Export() ([]byte, error) {
+	s.stateMux.Lock()
+	defer s.stateMux.Unlock()
+	state := sessionState{
+		ID:          s.id,
+		Src:         s.src,
+		Dst:         s.dst,
+		Start:       s.start,
+		Labels:      s.labels,
+		Config:      s.override,
+		IdleTimeout: s.idleTimeout,
+	}
+	for dir := range state.BytesRead {
+		state.BytesRead[dir] = atomic.LoadInt64(&s.bytesRead[dir])
+		state.BytesWritten[dir] = atomic.LoadInt64(&s.bytesWritten[dir])
+		state.Reads[dir] = atomic.LoadInt64(&s.reads[dir])
+		if tokens, ok := s.buckets[dir].snapshot(); ok {
+			state.RateTokens[dir] = &tokens
+		}
+	}
+	if s.idleTimeout > 0 {
+		state.IdleLeft = s.idleDeadline(s.idleTimeout).Sub(s.clock.Now())
+		if state.IdleLeft < 0 {
+			state.IdleLeft = 0
+		}
+	}
+	return json.Marshal(state)
+}
+
+// ImportSession resumes a session exported by Session.Export with new
+// connections, it keeps the ID, counters and labels of the session, applies
+// its session config and carries on its idle window and rate limiters
+// the session runs in the background like Start
+func (p *Piper) ImportSession(data []byte, downstream, upstream io.ReadWriteCloser) (*Session, error) {
+	var state sessionState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	s := p.newSession()
+	s.id, s.start = state.ID, state.Start
+	s.setLabels(state.Labels)
+	for dir := range state.BytesRead {
+		s.bytesRead[dir] = state.BytesRead[dir]
+		s.bytesWritten[dir] = state.BytesWritten[dir]
+		s.reads[dir] = state.Reads[dir]
+		if state.RateTokens[dir] != nil {
+			s.buckets[dir].restore(*state.RateTokens[dir])
+		}
+	}
+	// the idle window goes on where it was left, whatever the new timeout
+	if state.IdleTimeout > 0 {
+		s.resumed = true
+		s.lastActivity = s.clock.Now().Add(state.IdleLeft - state.IdleTimeout).UnixNano()
+	}
+
+	ctx := context.Background()
+	if state.Config != nil {
+		ctx = WithSession(ctx, *state.Config)
+	}
+	go func() {
+		s.stats, _ = p.runSession(ctx, s, downstream, upstream)
+		close(s.done)
+	}()
+	return s, nil
+}
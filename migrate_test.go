@@ -0,0 +1,80 @@
+package netplus_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestSessionExportImport(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	go io.Copy(io.Discard, server)
+	s := piper.Start(netplus.WithSession(context.Background(), netplus.PiperConfig{UpstreamRateLimit: 1 << 20}), downstream, upstream)
+	_, err := client.Write([]byte("hello"))
+	assert.Nil(t, err)
+	for s.BytesWritten(netplus.DirectionUpstream) < 5 {
+		time.Sleep(time.Millisecond)
+	}
+
+	data, err := s.Export()
+	assert.Nil(t, err)
+	var state map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &state))
+	assert.Equal(t, state["id"], s.ID())
+	assert.NotNil(t, state["config"])
+	client.Close()
+	s.Wait()
+
+	// the session resumes on another piper with new connections
+	other := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	client, downstream = net.Pipe()
+	upstream, server = net.Pipe()
+	go io.Copy(io.Discard, server)
+	resumed, err := other.ImportSession(data, downstream, upstream)
+	assert.Nil(t, err)
+	assert.Equal(t, resumed.ID(), s.ID())
+	_, err = client.Write([]byte("!"))
+	assert.Nil(t, err)
+	for resumed.BytesWritten(netplus.DirectionUpstream) < 6 {
+		time.Sleep(time.Millisecond)
+	}
+	stats, ok := other.SessionStats(s.ID())
+	assert.True(t, ok)
+	assert.Equal(t, stats.BytesUpstream, int64(6))
+	client.Close()
+	resumed.Wait()
+
+	_, err = other.ImportSession([]byte("{"), downstream, upstream)
+	assert.NotNil(t, err)
+}
+
+func TestImportSessionIdleWindow(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, 200*time.Millisecond)
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	s := piper.Start(context.Background(), downstream, upstream)
+	time.Sleep(150 * time.Millisecond)
+	data, err := s.Export()
+	assert.Nil(t, err)
+
+	client, downstream = net.Pipe()
+	upstream, server = net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	start := time.Now()
+	resumed, err := piper.ImportSession(data, downstream, upstream)
+	assert.Nil(t, err)
+	resumed.Wait()
+	// what was left of the idle window, not a whole new one
+	assert.Lt(t, int64(time.Since(start)), int64(150*time.Millisecond))
+}
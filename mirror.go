@@ -0,0 +1,97 @@
+package netplus
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+)
+
+// mirrorQueueLen is how many writes can wait for a slow mirror before the
+// next ones are dropped
+const mirrorQueueLen = 64
+
+// WithMirror dials a mirror for every session with dialMirror and writes a copy
+// of the data sent to the upstream to it, for IDS and IPS integration
+// the mirror never slows down or fails the session, it is dialled in the
+// background and gets nothing sent before it is up, writes it cannot keep up
+// with are dropped and after an error it gets nothing more
+// the mirror is closed once the session has ended and its queue is written
+func WithMirror(dialMirror func(ctx context.Context) (io.WriteCloser, error)) Option {
+	return func(p *Piper) {
+		p.dialMirror = dialMirror
+	}
+}
+
+type mirror struct {
+	queue chan []byte
+	// done is closed when the session ends, queue is never closed since a copy
+	// abandoned by the session may still send to it
+	done chan struct{}
+	// up is set while the mirror is dialled and writable
+	up int32
+}
+
+// startMirror dials the mirror of a session, it returns nil when there is none
+func (p *Piper) startMirror(ctx context.Context, s *Session) *mirror {
+	if p.dialMirror == nil {
+		return nil
+	}
+	m := &mirror{queue: make(chan []byte, mirrorQueueLen), done: make(chan struct{})}
+	go func() {
+		w, err := p.dialMirror(ctx)
+		if err != nil {
+			p.logWarn(s.logArgs("netplus: dialling mirror failed:", err)...)
+			s.nonFatal(err)
+			return
+		}
+		defer w.Close()
+		atomic.StoreInt32(&m.up, 1)
+		write := func(b []byte) bool {
+			if _, err := w.Write(b); err != nil {
+				atomic.StoreInt32(&m.up, 0)
+				p.logWarn(s.logArgs("netplus: writing to mirror failed:", err)...)
+				s.nonFatal(err)
+				return false
+			}
+			return true
+		}
+		for {
+			select {
+			case b := <-m.queue:
+				if !write(b) {
+					return
+				}
+			case <-m.done:
+				// write what is left in the queue
+				for {
+					select {
+					case b := <-m.queue:
+						if !write(b) {
+							return
+						}
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return m
+}
+
+// send queues a copy of b without blocking
+func (m *mirror) send(b []byte) {
+	if m == nil || atomic.LoadInt32(&m.up) == 0 {
+		return
+	}
+	select {
+	case m.queue <- append([]byte(nil), b...):
+	default:
+	}
+}
+
+func (m *mirror) close() {
+	if m != nil {
+		close(m.done)
+	}
+}
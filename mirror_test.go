@@ -0,0 +1,91 @@
+package netplus_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestMirror(t *testing.T) {
+	mirrorConn, mirrorPeer := net.Pipe()
+	dialled := make(chan struct{})
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithMirror(func(ctx context.Context) (io.WriteCloser, error) {
+		close(dialled)
+		return mirrorConn, nil
+	}))
+	mirrored := make(chan []byte, 1)
+	go func() {
+		b, _ := io.ReadAll(mirrorPeer)
+		mirrored <- b
+	}()
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	go func() {
+		// the mirror gets what is sent once it is up
+		<-dialled
+		time.Sleep(20 * time.Millisecond)
+		client.Write([]byte("request"))
+		io.ReadFull(client, make([]byte, 8))
+		client.Close()
+	}()
+	go func() {
+		io.ReadFull(server, make([]byte, 7))
+		server.Write([]byte("response"))
+	}()
+	_, err := piper.Run(context.Background(), downstream, upstream)
+	assert.Nil(t, err)
+	// only the upstream bound data, and the mirror is closed with the session
+	assert.Equal(t, string(<-mirrored), "request")
+}
+
+func TestMirrorDialFailure(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithMirror(func(ctx context.Context) (io.WriteCloser, error) {
+		return nil, errors.New("mirror down")
+	}))
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	go io.Copy(io.Discard, server)
+	go func() {
+		client.Write([]byte("request"))
+		client.Close()
+	}()
+	written, err := piper.Run(context.Background(), downstream, upstream)
+	assert.Nil(t, err)
+	assert.Equal(t, written, int64(7))
+}
+
+func TestMirrorSlowDial(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithMirror(func(ctx context.Context) (io.WriteCloser, error) {
+		<-release
+		return nil, errors.New("mirror down")
+	}))
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	go io.Copy(io.Discard, server)
+	go func() {
+		client.Write([]byte("request"))
+		client.Close()
+	}()
+
+	// the session does not wait for the mirror to be dialled
+	done := make(chan int64, 1)
+	go func() {
+		written, _ := piper.Run(context.Background(), downstream, upstream)
+		done <- written
+	}()
+	select {
+	case written := <-done:
+		assert.Equal(t, written, int64(7))
+	case <-time.After(time.Second):
+		t.Fatal("session waited for the mirror dial")
+	}
+}
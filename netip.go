@@ -0,0 +1,45 @@
+package netplus
+
+import (
+	"net"
+	"net/netip"
+)
+
+// addrFromIP converts ip to a netip.Addr, IPv4 addresses in their 16 byte form
+// come out as plain IPv4
+func addrFromIP(ip net.IP) (netip.Addr, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	return addr.Unmap(), ok
+}
+
+// ipFromAddr converts addr to a net.IP, nil for the zero Addr
+func ipFromAddr(addr netip.Addr) net.IP {
+	if !addr.IsValid() {
+		return nil
+	}
+	return net.IP(addr.AsSlice())
+}
+
+// addrPortFromNetAddr converts the TCP and UDP addresses of the net package,
+// or anything whose String is an IP and port, to a netip.AddrPort
+func addrPortFromNetAddr(a net.Addr) (netip.AddrPort, bool) {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		if addr, ok := addrFromIP(a.IP); ok {
+			return netip.AddrPortFrom(addr, uint16(a.Port)), true
+		}
+		return netip.AddrPort{}, false
+	case *net.UDPAddr:
+		if addr, ok := addrFromIP(a.IP); ok {
+			return netip.AddrPortFrom(addr, uint16(a.Port)), true
+		}
+		return netip.AddrPort{}, false
+	case nil:
+		return netip.AddrPort{}, false
+	}
+	ap, err := netip.ParseAddrPort(a.String())
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()), true
+}
@@ -0,0 +1,160 @@
+package netplus
+
+import (
+	"io"
+	"time"
+
+	"go.ideatocode.tech/log"
+)
+
+// Option configures a Piper created by NewPiper
+type Option func(*Piper)
+
+// WithErrorLogger sends error level events to l instead of the debug Logger
+func WithErrorLogger(l log.Logger) Option {
+	return func(p *Piper) {
+		p.ErrorLogger = l
+	}
+}
+
+// WithLogSampling allows at most n debug log lines per second across all sessions
+// lines over the limit are dropped and counted in Stats().DroppedLogLines
+func WithLogSampling(n int) Option {
+	return func(p *Piper) {
+		p.logSampling = n
+	}
+}
+
+// WithAccumulateErrors makes Run wait for both copy directions and return a
+// MultiError holding both of their errors instead of only the first one
+func WithAccumulateErrors(accumulate bool) Option {
+	return func(p *Piper) {
+		p.accumulateErrors = accumulate
+	}
+}
+
+// WithKeepUpstreamOpen leaves the upstream open when the session ends because the
+// downstream finished sending without error, so it can be put back in a ConnPool
+// only upstreams with read deadlines can be kept, the read from them is stopped
+// with an expired deadline which is then cleared
+// sessions ending any other way close both connections as usual
+func WithKeepUpstreamOpen(keep bool) Option {
+	return func(p *Piper) {
+		p.keepUpstreamOpen = keep
+	}
+}
+
+// WithReadHint bounds every Read of the copy loop to max bytes and waits for at
+// least min bytes before writing them on, zero leaves a bound off
+// min trades latency for fewer, larger writes and is capped by the buffer size
+func WithReadHint(min, max int) Option {
+	return func(p *Piper) {
+		p.readMin = min
+		p.readMax = max
+	}
+}
+
+// WithMinReadSize accumulates at least n bytes, or whatever is left at EOF,
+// before every write so protocols that expect whole messages get them in one piece
+// unlike the min of WithReadHint it grows the copy buffer to n when needed
+func WithMinReadSize(n int) Option {
+	return func(p *Piper) {
+		p.minReadSize = n
+	}
+}
+
+// WithIterationHistogram records the size of every read of the copy loop in
+// RunStats.IterationHistogram, a full last bucket hints at a buffer too small
+func WithIterationHistogram(enabled bool) Option {
+	return func(p *Piper) {
+		p.iterationHistogram = enabled
+	}
+}
+
+// WithReadSizeHistogram reports the sizes returned by the copy loop reads of
+// all sessions in Stats().ReadSizeHistogram, the same counts the histogram
+// handler of RegisterPprof serves, to tell small packet workloads from streaming
+func WithReadSizeHistogram(enabled bool) Option {
+	return func(p *Piper) {
+		p.readSizeHistogram = 0
+		if enabled {
+			p.readSizeHistogram = 1
+		}
+	}
+}
+
+// WithTimeoutJitter adds a random jitter of up to fraction * Timeout to the
+// first idle window of every session, not to the ones after activity, so
+// connections established together do not all time out together
+func WithTimeoutJitter(fraction float64) Option {
+	return func(p *Piper) {
+		p.timeoutJitter = fraction
+	}
+}
+
+// WithReadRetry retries a read failing with EAGAIN or EWOULDBLOCK, as on a
+// connection switched to non blocking mode, up to maxRetries times in a row
+// retryDelay apart before the error closes the pipe
+func WithReadRetry(maxRetries int, retryDelay time.Duration) Option {
+	return func(p *Piper) {
+		p.readRetries = maxRetries
+		p.readRetryDelay = retryDelay
+	}
+}
+
+// WithBufferedIO reads the connections through a readBuf bytes bufio.Reader and
+// writes them through a writeBuf bytes bufio.Writer, flushed after every copy
+// iteration unless WithFlushStrategy says otherwise, to save syscalls on
+// protocols with many small messages, 0 disables either
+func WithBufferedIO(readBuf, writeBuf int) Option {
+	return func(p *Piper) {
+		p.readBufferSize = readBuf
+		p.writeBufferSize = writeBuf
+	}
+}
+
+// WithDeadlineExtensionFactor gives slow writers more time, a read shorter than
+// the copy buffer extends the idle window after it by f times what was left of
+// the previous one, a full read or a connection going quiet gets no extension
+func WithDeadlineExtensionFactor(f float64) Option {
+	return func(p *Piper) {
+		p.deadlineExtension = f
+	}
+}
+
+// WithOnFirstWrite calls fn with the connection data in direction is written
+// to right before the first write of a session to it, fn may use it to send a
+// banner or authenticate out of band before the first forwarded byte
+// DirectionBoth sets fn for both directions
+func WithOnFirstWrite(direction Direction, fn func(conn io.ReadWriteCloser)) Option {
+	return func(p *Piper) {
+		for _, dir := range []Direction{DirectionUpstream, DirectionDownstream} {
+			if direction == dir || direction == DirectionBoth {
+				p.onFirstWrite[dir] = fn
+			}
+		}
+	}
+}
+
+// WithRateLimiter paces the data in direction with l, on top of the rate limits
+// of the config, l is shared by all the sessions of the Piper
+// DirectionBoth sets one limiter for the data of both directions
+func WithRateLimiter(direction Direction, l RateLimiter) Option {
+	return func(p *Piper) {
+		for _, dir := range []Direction{DirectionUpstream, DirectionDownstream} {
+			if direction == dir || direction == DirectionBoth {
+				p.rateLimiters[dir] = l
+			}
+		}
+	}
+}
+
+// WithReadRateLimit paces what every session reads from downstream to
+// upstreamBPS and from upstream to downstreamBPS bytes per second, unlike the
+// rate limits of PiperConfig there is no one second burst up front, zero leaves
+// a direction unlimited
+func WithReadRateLimit(upstreamBPS, downstreamBPS float64) Option {
+	return func(p *Piper) {
+		p.readRateLimit = [2]float64{upstreamBPS, downstreamBPS}
+	}
+}
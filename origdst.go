@@ -0,0 +1,11 @@
+package netplus
+
+import "net"
+
+// OriginalDst returns the destination a connection redirected by an iptables
+// REDIRECT or DNAT rule was sent to before, from SO_ORIGINAL_DST, so the
+// DialFunc of a transparent AutoProxy can route on it
+// it returns ErrNotSupported on platforms other than Linux
+func OriginalDst(conn net.Conn) (net.Addr, error) {
+	return originalDst(conn)
+}
@@ -0,0 +1,45 @@
+package netplus
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// soOriginalDst is SO_ORIGINAL_DST and IP6T_SO_ORIGINAL_DST from the netfilter
+// headers, the syscall package has no name for them
+const soOriginalDst = 80
+
+func originalDst(conn net.Conn) (net.Addr, error) {
+	v6 := false
+	if la, ok := conn.LocalAddr().(*net.TCPAddr); ok && la.IP.To4() == nil {
+		v6 = true
+	}
+	var addr *net.TCPAddr
+	err := controlFD(conn, func(fd uintptr) error {
+		if v6 {
+			// IPv6MTUInfo starts with a sockaddr_in6, the getsockopt fills it in
+			info, err := syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.IPPROTO_IPV6, soOriginalDst)
+			if err != nil {
+				return err
+			}
+			// the port is in network byte order whatever the field type says
+			port := (*[2]byte)(unsafe.Pointer(&info.Addr.Port))
+			addr = &net.TCPAddr{IP: append(net.IP(nil), info.Addr.Addr[:]...), Port: int(binary.BigEndian.Uint16(port[:]))}
+			return nil
+		}
+		// IPv6Mreq is as long as a sockaddr_in, the getsockopt fills it in
+		mreq, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IP, soOriginalDst)
+		if err != nil {
+			return err
+		}
+		b := mreq.Multiaddr
+		addr = &net.TCPAddr{IP: net.IPv4(b[4], b[5], b[6], b[7]), Port: int(binary.BigEndian.Uint16(b[2:4]))}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return addr, nil
+}
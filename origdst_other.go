@@ -0,0 +1,9 @@
+//go:build !linux
+
+package netplus
+
+import "net"
+
+func originalDst(conn net.Conn) (net.Addr, error) {
+	return nil, ErrNotSupported
+}
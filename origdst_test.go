@@ -0,0 +1,31 @@
+package netplus_test
+
+import (
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestOriginalDst(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	_, err := netplus.OriginalDst(a)
+	assert.NotNil(t, err)
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+	// without a NAT rule there is either no original destination or, with
+	// conntrack loaded, the one the client dialled
+	addr, err := netplus.OriginalDst(server)
+	if err == nil {
+		assert.Equal(t, addr.String(), server.LocalAddr().String())
+	}
+}
@@ -66,8 +66,8 @@ loop:
 func (p *Pipe) newcopy(src net.Conn, dst net.Conn, chn chan error) {
 	var err error
 
-	buf := pool.Get().([]byte)
-	defer pool.Put(buf)
+	buf := defaultPool.Get()
+	defer defaultPool.Put(buf)
 
 	for {
 		src.SetDeadline(time.Now().Add(p.Timeout))
@@ -112,9 +112,16 @@ func (p *Pipe) closeBothSockets(src net.Conn, dst net.Conn) {
 		p.Logger.Debug("Swapped")
 	}
 
-	// t := time.Now().Add(time.Millisecond * 100)
-	// src.SetDeadline(t)
-	// dst.SetDeadline(t)
+	// closing with unread data resets the connections, so the pending bytes are
+	// read and dropped first and the peers see an orderly close
+	t := time.Now().Add(closeDrainTime)
+	done := make(chan struct{})
+	go func() {
+		discardPending(src, t)
+		close(done)
+	}()
+	discardPending(dst, t)
+	<-done
 
 	src.Close()
 	dst.Close()
@@ -123,3 +130,18 @@ func (p *Pipe) closeBothSockets(src net.Conn, dst net.Conn) {
 		p.Logger.Debug("closing")
 	}
 }
+
+// closeDrainTime bounds how long closeBothSockets reads what is left
+const closeDrainTime = 5 * time.Millisecond
+
+// discardPending reads and drops what c received until t
+func discardPending(c net.Conn, t time.Time) {
+	buf := defaultPool.Get()
+	defer defaultPool.Put(buf)
+	c.SetReadDeadline(t)
+	for {
+		if _, err := c.Read(buf); err != nil {
+			return
+		}
+	}
+}
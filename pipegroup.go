@@ -0,0 +1,208 @@
+package netplus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrShuttingDown is returned by PipeGroup.Add once Shutdown has been called
+var ErrShuttingDown = errors.New("pipe group is shutting down")
+
+// ErrTooManyConnectionsFromIP is returned by PipeGroup.Add when the source IP
+// of the downstream already has as many sessions as SetPerIPLimit allows
+var ErrTooManyConnectionsFromIP = errors.New("too many connections from ip")
+
+// ErrDuplicateSession is returned by PipeGroup.Add when a session with the same
+// key is running or closed less than the grace of SetDeduplication ago
+var ErrDuplicateSession = errors.New("duplicate session")
+
+// PipeGroup runs pipe sessions in the background and keeps track of them
+// so they can be drained on shutdown
+type PipeGroup struct {
+	Piper *Piper
+	// OnClose is called after every session finishes, including the ones
+	// drained by Shutdown
+	OnClose func(stats RunStats, err error)
+
+	mux      sync.Mutex
+	wg       sync.WaitGroup
+	shutdown bool
+	perIP    *ipCounter
+	dedup    *dedup
+	// accepted counts the sessions Add started per second
+	accepted rateRing
+}
+
+// dedup tracks the sessions of a group by key, a running one maps to the zero
+// time and a finished one to when it closed until its grace is over
+type dedup struct {
+	keyFn    func(conn net.Conn) string
+	grace    time.Duration
+	sessions map[string]time.Time
+}
+
+// NewPipeGroup returns a PipeGroup running its sessions with p
+func NewPipeGroup(p *Piper) *PipeGroup {
+	return &PipeGroup{
+		Piper: p,
+	}
+}
+
+// SetPerIPLimit limits the number of active sessions per source IP
+// the IP is taken from the downstream remote address, downstreams without one are not limited
+// n <= 0 removes the limit
+func (g *PipeGroup) SetPerIPLimit(n int) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if n <= 0 {
+		g.perIP = nil
+		return
+	}
+	g.perIP = newIPCounter(n)
+}
+
+// SetDeduplication rejects a session with ErrDuplicateSession while another
+// one with the same key from keyFn runs or closed less than grace ago, such as
+// a client reconnecting before its old session is gone
+// downstreams that are no net.Conn or get an empty key are not deduplicated
+// a nil keyFn turns the deduplication off
+func (g *PipeGroup) SetDeduplication(keyFn func(conn net.Conn) string, grace time.Duration) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if keyFn == nil {
+		g.dedup = nil
+		return
+	}
+	g.dedup = &dedup{keyFn: keyFn, grace: grace, sessions: map[string]time.Time{}}
+}
+
+// Add starts piping downstream and upstream in the background
+// it returns ErrShuttingDown once Shutdown has been called
+func (g *PipeGroup) Add(ctx context.Context, downstream io.ReadWriteCloser, upstream io.ReadWriteCloser) error {
+	return g.add(ctx, downstream, upstream, nil)
+}
+
+// add is Add with a done callback called right after the session ends
+func (g *PipeGroup) add(ctx context.Context, downstream io.ReadWriteCloser, upstream io.ReadWriteCloser, done func(RunStats, error)) error {
+	g.mux.Lock()
+	if g.shutdown {
+		g.mux.Unlock()
+		return ErrShuttingDown
+	}
+	perIP := g.perIP
+	ip, hasIP := remoteIP(downstream)
+	if perIP != nil && hasIP && !perIP.acquire(ip) {
+		g.mux.Unlock()
+		return ErrTooManyConnectionsFromIP
+	}
+	dd, key := g.dedup, ""
+	if conn, ok := downstream.(net.Conn); ok && dd != nil {
+		key = dd.keyFn(conn)
+	}
+	if key != "" {
+		if closed, ok := dd.sessions[key]; ok && (closed.IsZero() || time.Since(closed) < dd.grace) {
+			g.mux.Unlock()
+			if perIP != nil && hasIP {
+				perIP.release(ip)
+			}
+			return ErrDuplicateSession
+		}
+		dd.sessions[key] = time.Time{}
+	}
+	g.accepted.add(time.Now(), 1)
+	g.wg.Add(1)
+	g.mux.Unlock()
+
+	go func() {
+		defer g.wg.Done()
+		if perIP != nil && hasIP {
+			defer perIP.release(ip)
+		}
+		stats, err := g.Piper.run(ctx, downstream, upstream)
+		if key != "" {
+			g.closeKey(dd, key)
+		}
+		if done != nil {
+			done(stats, err)
+		}
+		if g.OnClose != nil {
+			g.OnClose(stats, err)
+		}
+	}()
+	return nil
+}
+
+// ConnectionRatePerSecond returns the average number of sessions per second
+// Add accepted over the last 60 seconds, rejected connections are not counted
+func (g *PipeGroup) ConnectionRatePerSecond() float64 {
+	return g.accepted.recent(time.Now(), 60)
+}
+
+// ConnectionRateHistory returns the sessions Add accepted in each of the last
+// 60 seconds, oldest first, the last one is the second in progress
+func (g *PipeGroup) ConnectionRateHistory() [60]float64 {
+	return g.accepted.perSecond(time.Now())
+}
+
+// closeKey starts the grace of the session with key and forgets it afterwards
+func (g *PipeGroup) closeKey(dd *dedup, key string) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	closed := time.Now()
+	dd.sessions[key] = closed
+	time.AfterFunc(dd.grace, func() {
+		g.mux.Lock()
+		defer g.mux.Unlock()
+		if dd.sessions[key] == closed {
+			delete(dd.sessions, key)
+		}
+	})
+}
+
+// Shutdown stops the group from accepting new sessions and waits for the
+// existing ones to finish on their own, much like http.Server.Shutdown
+// it returns ctx.Err() if ctx is done before all sessions have finished
+func (g *PipeGroup) Shutdown(ctx context.Context) error {
+	g.mux.Lock()
+	g.shutdown = true
+	g.mux.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// HandleSignals waits for one of signals, SIGTERM and SIGINT by default,
+// and then calls Shutdown
+// it blocks until the shutdown is complete or ctx is done
+func (g *PipeGroup) HandleSignals(ctx context.Context, signals ...os.Signal) error {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	defer signal.Stop(c)
+
+	select {
+	case <-c:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return g.Shutdown(ctx)
+}
@@ -0,0 +1,181 @@
+package netplus_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/log"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestPipeGroupShutdown(t *testing.T) {
+	logger := log.NewZero(os.Stderr)
+	group := netplus.NewPipeGroup(netplus.NewPiper(logger, time.Minute))
+
+	closed := make(chan error, 1)
+	group.OnClose = func(stats netplus.RunStats, err error) {
+		closed <- err
+	}
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer server.Close()
+
+	err := group.Add(context.Background(), downstream, upstream)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = group.Shutdown(ctx)
+	assert.Equal(t, err, context.DeadlineExceeded)
+
+	a, b := net.Pipe()
+	err = group.Add(context.Background(), a, b)
+	assert.Equal(t, err, netplus.ErrShuttingDown)
+
+	// the in-flight session still finishes normally
+	client.Close()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnClose was not called")
+	}
+
+	err = group.Shutdown(context.Background())
+	assert.Nil(t, err)
+}
+
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func TestPipeGroupPerIPLimit(t *testing.T) {
+	logger := log.NewZero(os.Stderr)
+	group := netplus.NewPipeGroup(netplus.NewPiper(logger, time.Minute))
+	group.SetPerIPLimit(1)
+
+	closed := make(chan struct{}, 2)
+	group.OnClose = func(stats netplus.RunStats, err error) {
+		closed <- struct{}{}
+	}
+
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer server.Close()
+
+	err := group.Add(context.Background(), &addrConn{downstream, remote}, upstream)
+	assert.Nil(t, err)
+
+	a, b := net.Pipe()
+	remote2 := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4321}
+	err = group.Add(context.Background(), &addrConn{a, remote2}, b)
+	assert.Equal(t, err, netplus.ErrTooManyConnectionsFromIP)
+
+	client.Close()
+	<-closed
+
+	err = group.Add(context.Background(), &addrConn{a, remote2}, b)
+	assert.Nil(t, err)
+	a.Close()
+	<-closed
+}
+
+func TestPipeGroupHandleSignals(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals cannot be sent to self on windows")
+	}
+	logger := log.NewZero(os.Stderr)
+	group := netplus.NewPipeGroup(netplus.NewPiper(logger, time.Minute))
+
+	done := make(chan error)
+	go func() {
+		done <- group.HandleSignals(context.Background(), syscall.SIGHUP)
+	}()
+
+	// give HandleSignals time to register
+	time.Sleep(50 * time.Millisecond)
+	proc, err := os.FindProcess(os.Getpid())
+	assert.Nil(t, err)
+	err = proc.Signal(syscall.SIGHUP)
+	assert.Nil(t, err)
+
+	select {
+	case err = <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("HandleSignals did not return")
+	}
+
+	a, b := net.Pipe()
+	err = group.Add(context.Background(), a, b)
+	assert.Equal(t, err, netplus.ErrShuttingDown)
+}
+
+func TestPipeGroupDeduplication(t *testing.T) {
+	group := netplus.NewPipeGroup(netplus.NewPiper(&recordingLogger{}, time.Minute))
+	group.SetDeduplication(func(conn net.Conn) string {
+		return conn.RemoteAddr().(*net.TCPAddr).IP.String()
+	}, 50*time.Millisecond)
+
+	closed := make(chan struct{}, 3)
+	group.OnClose = func(stats netplus.RunStats, err error) {
+		closed <- struct{}{}
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+	add := func() (net.Conn, error) {
+		client, downstream := net.Pipe()
+		upstream, server := net.Pipe()
+		t.Cleanup(func() { server.Close() })
+		return client, group.Add(context.Background(), &addrConn{downstream, remote}, upstream)
+	}
+
+	client, err := add()
+	assert.Nil(t, err)
+	_, err = add()
+	assert.Equal(t, err, netplus.ErrDuplicateSession)
+
+	// still rejected within the grace after the first one closed
+	client.Close()
+	<-closed
+	_, err = add()
+	assert.Equal(t, err, netplus.ErrDuplicateSession)
+
+	time.Sleep(60 * time.Millisecond)
+	client, err = add()
+	assert.Nil(t, err)
+	client.Close()
+	<-closed
+}
+
+func TestPipeGroupConnectionRate(t *testing.T) {
+	group := netplus.NewPipeGroup(netplus.NewPiper(&recordingLogger{}, time.Minute))
+	group.SetPerIPLimit(3)
+	assert.Equal(t, group.ConnectionRatePerSecond(), 0.0)
+
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+	for i := 0; i < 4; i++ {
+		client, downstream := net.Pipe()
+		upstream, server := net.Pipe()
+		defer client.Close()
+		defer server.Close()
+		err := group.Add(context.Background(), &addrConn{downstream, remote}, upstream)
+		assert.Equal(t, err == nil, i < 3)
+	}
+
+	// the rejected one does not count
+	history := group.ConnectionRateHistory()
+	assert.Equal(t, history[58]+history[59], 3.0)
+	assert.Equal(t, group.ConnectionRatePerSecond(), 3.0/60)
+}
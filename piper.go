@@ -1,9 +1,15 @@
 package netplus
 
 import (
+	"bufio"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,32 +21,128 @@ import (
 // but failed to return an explicit error.
 var ErrShortWrite = errors.New("short write")
 
+// ErrConnectTimeout is returned by Run when no byte was read from either
+// direction within MaxConnectTime
+var ErrConnectTimeout = errors.New("no data within connect time")
+
+// ErrDeadlockDetected is returned by Run when a write of the session did not
+// return within the timeout of SetDeadlockTimeout
+var ErrDeadlockDetected = errors.New("copy stuck in write, possible deadlock")
+
 // errInvalidWrite means that a write returned an impossible count.
 var errInvalidWrite = errors.New("invalid write result")
 
 // Piper .
 type Piper struct {
-	Logger     log.Logger
-	Timeout    time.Duration
-	debugLevel int
-}
+	Logger log.Logger
+	// ErrorLogger receives error level events such as close and write failures
+	// Logger is used when it is nil
+	ErrorLogger log.Logger
+	Timeout     time.Duration
+	// WriteTimeout bounds every single write to a connection that supports
+	// write deadlines, independently of the idle Timeout, zero means no bound
+	WriteTimeout time.Duration
+	// MaxConnectTime closes sessions that read nothing from either direction
+	// within that long after Run is called, zero means no bound
+	MaxConnectTime time.Duration
+	// OnConfigReloaded is called by WatchConfigFile after a new config has been applied
+	OnConfigReloaded    func(old, new PiperConfig)
+	debugLevel          int
+	config              atomic.Value // *PiperConfig
+	readSizes           histogram
+	durations           histogram
+	active              int64
+	logSampling         int
+	logBucket           tokenBucket
+	droppedLogLines     int64
+	lastID              uint64
+	idGenerator         IDGenerator
+	audit               *auditLog
+	failoverDial        func(ctx context.Context) (io.ReadWriteCloser, error)
+	maxFailovers        int
+	dialMirror          func(ctx context.Context) (io.WriteCloser, error)
+	accumulateErrors    bool
+	keepUpstreamOpen    bool
+	upstreamCloseMode   CloseMode
+	downstreamCloseMode CloseMode
+	closeFunc           func(src, dst io.ReadWriteCloser)
+	flushOnClose        func(conn io.ReadWriteCloser) error
+	noDelay             connToggle
+	cork                connToggle
+	rcvBuf              int
+	sndBuf              int
+	keepalive           *tcpKeepalive
+	timeoutKeepalive    *tcpKeepalive
+	readMin             int
+	readMax             int
+	minReadSize         int
+	sessionSlots        chan struct{}
+	iterationHistogram  bool
+	readSizeHistogram   int32
+	trackGoroutines     int32 // set by DebugDumpOnSignal
+	closed              int32 // set by Close, which closes closing
+	closing             chan struct{}
+	timeoutJitter       float64
+	readRetries         int
+	readRetryDelay      time.Duration
+	readBufferSize      int
+	writeBufferSize     int
+	flushStrategy       FlushStrategy
+	reclaimInterval     time.Duration
+	bufPool             *BufferPool
+	deadlineExtension   float64
+	scheduler           *Scheduler
+	onFirstWrite        [2]func(conn io.ReadWriteCloser)
+	rateLimiters        [2]RateLimiter
+	readRateLimit       [2]float64
+	deadlockTimeout     int64 // time.Duration
+	timerStartMode      TimerStartMode
+	clock               Clock
+	adaptiveTimeout     *adaptiveTimeout
+	returnedBufs        int64
+	reclaimBudget       int64
+	reclaimRound        int64 // unix nanoseconds
 
-var pool sync.Pool
+	timelineResolution time.Duration
+	timelineSize       int
 
-func init() {
-	pool = sync.Pool{
-		New: func() interface{} {
-			return make([]byte, 32*1024)
-		},
-	}
+	pongTimeout time.Duration
+
+	sessions sync.Map // connection ID to *Session
+
+	idleMux sync.Mutex
+	idle    chan struct{} // closed when the last active session ends
+
+	eventMux     sync.Mutex
+	events       chan Event
+	eventsClosed bool
+
+	warmMux  sync.Mutex
+	warm     *ConnPool
+	warmDial func() (io.ReadWriteCloser, error)
 }
 
+// defaultBufferSize is the size of the pooled copy buffers
+const defaultBufferSize = 32 * 1024
+
 // NewPiper returns a pointer to a newPiper Piper instance
-func NewPiper(l log.Logger, t time.Duration) *Piper {
-	return &Piper{
+// a nil l logs to a NopLogger
+func NewPiper(l log.Logger, t time.Duration, opts ...Option) *Piper {
+	if l == nil {
+		l = NopLogger{}
+	}
+	p := &Piper{
 		Logger:  l,
 		Timeout: t,
+		clock:   realClock{},
+		bufPool: defaultPool,
+		closing: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
 	}
+	p.reclaimRound = time.Now().UnixNano()
+	return p
 }
 
 // Debug turns debugging on and off
@@ -53,130 +155,752 @@ func (p *Piper) DebugLevel(debug int) {
 	p.debugLevel = debug
 }
 
+// ActiveConnections returns the number of Run calls in progress
+func (p *Piper) ActiveConnections() int64 {
+	return atomic.LoadInt64(&p.active)
+}
+
+// WaitIdle blocks until there are no active connections or ctx is done
+func (p *Piper) WaitIdle(ctx context.Context) error {
+	for {
+		p.idleMux.Lock()
+		if p.ActiveConnections() == 0 {
+			p.idleMux.Unlock()
+			return nil
+		}
+		if p.idle == nil {
+			p.idle = make(chan struct{})
+		}
+		idle := p.idle
+		p.idleMux.Unlock()
+
+		// a new session may have started by the time idle is closed
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-idle:
+		}
+	}
+}
+
+// sessionEnded decrements the active connections and wakes up WaitIdle
+func (p *Piper) sessionEnded() {
+	if atomic.AddInt64(&p.active, -1) > 0 {
+		return
+	}
+	p.idleMux.Lock()
+	if p.idle != nil {
+		close(p.idle)
+		p.idle = nil
+	}
+	p.idleMux.Unlock()
+}
+
+// nextID returns a new connection ID
+func (p *Piper) nextID() string {
+	if p.idGenerator != nil {
+		return p.idGenerator.Next()
+	}
+	return strconv.FormatUint(atomic.AddUint64(&p.lastID, 1), 10)
+}
+
+// PiperStats is a snapshot of the counters kept by a Piper
+type PiperStats struct {
+	ActiveConnections int64
+	// DroppedLogLines counts the debug lines dropped by WithLogSampling
+	DroppedLogLines int64
+	// DurationHistogram counts the finished sessions by duration, Min and Max
+	// are in seconds and the last bucket, from 4096s, holds everything longer
+	DurationHistogram []HistogramBucket
+	// ReadSizeHistogram counts the bytes returned by every read of the copy
+	// loops of all sessions, it is only recorded WithReadSizeHistogram or
+	// after RegisterPprof
+	ReadSizeHistogram []HistogramBucket
+}
+
+// durationBuckets is the number of DurationHistogram buckets, up to one hour and more
+const durationBuckets = 13
+
+// Stats returns a snapshot of the Piper counters
+func (p *Piper) Stats() PiperStats {
+	stats := PiperStats{
+		ActiveConnections: p.ActiveConnections(),
+		DroppedLogLines:   atomic.LoadInt64(&p.droppedLogLines),
+		DurationHistogram: p.durations.bucketsUpTo(durationBuckets),
+	}
+	if atomic.LoadInt32(&p.readSizeHistogram) == 1 {
+		stats.ReadSizeHistogram = p.readSizes.buckets()
+	}
+	return stats
+}
+
+// ResetStats zeroes the counters and histograms of Stats
+// ActiveConnections is a gauge and is left alone
+func (p *Piper) ResetStats() {
+	atomic.StoreInt64(&p.droppedLogLines, 0)
+	p.durations.reset()
+	p.readSizes.reset()
+}
+
+// addDuration records a finished session in the DurationHistogram
+func (p *Piper) addDuration(d time.Duration) {
+	seconds := int64(d / time.Second)
+	if max := int64(1) << (durationBuckets - 1); seconds > max {
+		seconds = max
+	}
+	p.durations.add(seconds)
+}
+
+// String returns a short summary of the Piper for log lines
+func (p *Piper) String() string {
+	cfg := p.Config()
+	bufSize := cfg.BufferSize
+	if bufSize == 0 {
+		bufSize = p.bufPool.BufferSize()
+	}
+	return fmt.Sprintf("Piper{timeout=%s, bufSize=%d, active=%d}", cfg.Timeout, bufSize, p.ActiveConnections())
+}
+
+// GoString returns a Go-syntax representation of the Piper for %#v
+func (p *Piper) GoString() string {
+	return fmt.Sprintf("&netplus.Piper{Timeout:%#v, debugLevel:%d}", p.Config().Timeout, p.debugLevel)
+}
+
+// RunStats describes a finished pipe session
+type RunStats struct {
+	// BytesUpstream is the number of bytes copied from downstream to upstream
+	BytesUpstream int64
+	// BytesDownstream is the number of bytes copied from upstream to downstream
+	BytesDownstream int64
+	Duration        time.Duration
+	// Timeline is recorded when the Piper was created WithTimeline
+	Timeline []TimelinePoint
+	// IterationHistogram holds the sizes returned by the copy loop reads in both
+	// directions when the Piper was created WithIterationHistogram
+	IterationHistogram []HistogramBucket
+	// IterationsUpstream and IterationsDownstream count the reads that returned data
+	IterationsUpstream   int64
+	IterationsDownstream int64
+	// Err is the error the session ended with, as returned by Run
+	Err error
+	// Labels are the labels given to RunLabeled
+	Labels map[string]string
+	// Failovers counts the upstreams replaced by RunWithReplay
+	Failovers int
+	// Hijacked is set when Session.Hijack took the connections over
+	Hijacked bool
+	// AvgWriteLatencyNs is the moving average of the time the writes of the copied
+	// data take, in both directions, the rate limit waits are left out, rising
+	// values mean a slow receiver
+	AvgWriteLatencyNs int64
+}
+
 // Run pipes data between upstream and downstream and closes one when the other closes
-// times out after two hours by default
+// times out after two hours by default, a nil upstream comes from WarmUp
 func (p *Piper) Run(ctx context.Context, downstream io.ReadWriteCloser, upstream io.ReadWriteCloser) (written int64, err error) {
-	var dur time.Duration
-	if p.Timeout == 0 {
-		dur = time.Duration(2 * time.Hour)
-	} else {
-		dur = p.Timeout
+	stats, err := p.run(ctx, downstream, upstream)
+	return stats.BytesUpstream + stats.BytesDownstream, err
+}
+
+// RunAsync runs the pipe in the background
+// the returned channel receives the stats once the pipe is done and is then closed
+func (p *Piper) RunAsync(ctx context.Context, downstream io.ReadWriteCloser, upstream io.ReadWriteCloser) <-chan RunStats {
+	c := make(chan RunStats, 1)
+	go func() {
+		stats, _ := p.run(ctx, downstream, upstream)
+		c <- stats
+		close(c)
+	}()
+	return c
+}
+
+// nonFatalQueueLen is how many non-fatal errors RunAsyncWithErrors holds for
+// a slow reader before the next ones are dropped
+const nonFatalQueueLen = 16
+
+// RunAsyncWithErrors is RunAsync with a second channel receiving the errors
+// the session survives as they happen, such as a read that is retried or a
+// failed mirror, errors the reader cannot keep up with are dropped
+// the error channel is closed once the session is done
+func (p *Piper) RunAsyncWithErrors(ctx context.Context, downstream io.ReadWriteCloser, upstream io.ReadWriteCloser) (<-chan RunStats, <-chan error) {
+	c := make(chan RunStats, 1)
+	errs := make(chan error, nonFatalQueueLen)
+	s := p.newSession()
+	s.errs = errs
+	go func() {
+		stats, _ := p.runSession(ctx, s, downstream, upstream)
+		s.closeErrs()
+		c <- stats
+		close(c)
+	}()
+	return c, errs
+}
+
+func (p *Piper) run(ctx context.Context, downstream io.ReadWriteCloser, upstream io.ReadWriteCloser) (RunStats, error) {
+	return p.runSession(ctx, p.newSession(), downstream, upstream)
+}
+
+func (p *Piper) runSession(ctx context.Context, s *Session, downstream io.ReadWriteCloser, upstream io.ReadWriteCloser) (RunStats, error) {
+	err := p.acquireSlot(ctx)
+	if err == nil && p.isClosed() {
+		p.releaseSlot()
+		err = ErrPiperClosed
 	}
-	return p.idleTimeoutPipe(ctx, downstream, upstream, dur)
+	if err != nil {
+		atomic.StoreInt32(&s.running, 0)
+		if s.timeline != nil {
+			s.timeline.finish()
+		}
+		return RunStats{Err: err, Labels: s.labels}, err
+	}
+	defer p.releaseSlot()
+
+	if upstream == nil {
+		if upstream, err = p.warmUpstream(); err != nil {
+			atomic.StoreInt32(&s.running, 0)
+			if s.timeline != nil {
+				s.timeline.finish()
+			}
+			return RunStats{Err: err, Labels: s.labels}, err
+		}
+	}
+
+	cfg := p.Config()
+	s.stateMux.Lock()
+	if override, ok := SessionFromContext(ctx); ok {
+		cfg = cfg.merge(override)
+		s.override = &override
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Duration(2 * time.Hour)
+	}
+	atomic.AddInt64(&p.active, 1)
+	defer p.sessionEnded()
+	defer atomic.StoreInt32(&s.running, 0)
+	if s.start.IsZero() {
+		s.start = time.Now()
+	}
+	s.src, s.dst = remoteAddr(downstream), remoteAddr(upstream)
+	s.stateMux.Unlock()
+	p.sessions.Store(s.id, s)
+	defer p.sessions.Delete(s.id)
+
+	s.mirror = p.startMirror(ctx, s)
+	defer s.mirror.close()
+	p.auditOpen(s)
+	p.emit(Connected, s.id, nil)
+	start := time.Now()
+	stats, err := p.idleTimeoutPipe(ctx, s, downstream, upstream, cfg)
+	stats.Duration = time.Since(start)
+	p.addDuration(stats.Duration)
+	stats.Err = err
+	stats.Labels = s.labels
+	if s.timeline != nil {
+		stats.Timeline = s.timeline.finish()
+	}
+	stats.IterationsUpstream = atomic.LoadInt64(&s.reads[DirectionUpstream])
+	stats.IterationsDownstream = atomic.LoadInt64(&s.reads[DirectionDownstream])
+	stats.AvgWriteLatencyNs = int64(math.Float64frombits(atomic.LoadUint64(&s.writeLatency)))
+	if s.iterations != nil {
+		stats.IterationHistogram = s.iterations.buckets()
+	}
+	if err != nil {
+		p.emit(Error, s.id, err)
+	}
+	p.auditClose(s, stats)
+	p.emit(Disconnected, s.id, stats)
+	return stats, err
 }
 
-func (p *Piper) idleTimeoutPipe(ctx context.Context, dst io.ReadWriteCloser, src io.ReadWriteCloser, timeout time.Duration) (written int64, err error) {
-	if p.debugLevel > 9999 {
-		p.Logger.Debug("runnning idleTimeoutPipe for ", timeout)
+func (p *Piper) idleTimeoutPipe(ctx context.Context, s *Session, dst io.ReadWriteCloser, src io.ReadWriteCloser, cfg PiperConfig) (stats RunStats, err error) {
+	timeout := cfg.Timeout
+	start := time.Now()
+	if p.debugEnabled(9999) {
+		p.debug(s.logArgs("runnning idleTimeoutPipe for ", timeout)...)
 	}
 	var running int32 = 1
 
+	parentDone := ctx.Done()
 	ctx, closeContext := context.WithCancel(ctx)
+	// a hijacked session ends without closeBothSockets
+	defer closeContext()
 
-	upstreamReset := make(chan struct{})
-	downstreammReset := make(chan struct{})
+	// the copies send to these without blocking, one buffered slot keeps a reset
+	// pending while the timer goroutine is busy with the other direction
+	upstreamReset := make(chan struct{}, 1)
+	downstreammReset := make(chan struct{}, 1)
+	// closeDeferred is set when the WithCloseFunc call waits for the copies
+	var closeDeferred int32
 	closeBothSockets := func(from string) {
-		if p.debugLevel > 9999 {
-			p.Logger.Debug("closeBothSockets called from ", from)
+		if p.debugEnabled(9999) {
+			p.debug(s.logArgs("closeBothSockets called from ", from)...)
 		}
 
 		if !atomic.CompareAndSwapInt32(&running, 1, 0) {
 			return
 		}
-		if p.debugLevel > 9999 {
-			p.Logger.Debug("Swapped")
+		if p.debugEnabled(9999) {
+			p.debug(s.logArgs("Swapped")...)
 		}
 		closeContext()
-		src.Close()
-		dst.Close()
-		if p.debugLevel > 9999 {
-			p.Logger.Debug("closing")
+		p.flushDownstream(s, dst)
+		if p.closeFunc != nil {
+			if s.stopCopies(src, dst) {
+				// fn gets the connections once both copies stopped
+				atomic.StoreInt32(&closeDeferred, 1)
+			} else {
+				p.closeFunc(src, dst)
+			}
+		} else {
+			if err := src.Close(); err != nil && !isClosedErr(err) {
+				p.logError(s.logArgs("netplus: closing upstream:", err)...)
+				s.nonFatal(err)
+			}
+			if err := dst.Close(); err != nil && !isClosedErr(err) {
+				p.logError(s.logArgs("netplus: closing downstream:", err)...)
+				s.nonFatal(err)
+			}
+		}
+		if p.debugEnabled(9999) {
+			p.debug(s.logArgs("closing")...)
 		}
 		ctx.Done()
 	}
-	go func() {
-		timer := time.NewTimer(timeout)
-		defer timer.Stop() // Stop the timer when the goroutine exits
-
-		for {
-			select {
-			case <-ctx.Done():
+	// keepUpstream ends the session closing only the downstream and stops
+	// the read from upstream with an expired deadline, it returns false
+	// when the upstream has no read deadline or the session is already closing
+	keepUpstream := func() bool {
+		rd, ok := src.(interface{ SetReadDeadline(time.Time) error })
+		if !ok || !atomic.CompareAndSwapInt32(&running, 1, 0) {
+			return false
+		}
+		closeContext()
+		p.flushDownstream(s, dst)
+		if err := dst.Close(); err != nil && !isClosedErr(err) {
+			p.logError(s.logArgs("netplus: closing downstream:", err)...)
+			s.nonFatal(err)
+		}
+		rd.SetReadDeadline(time.Unix(1, 0))
+		return true
+	}
+	hijacked := make(chan struct{})
+	var piperClosed int32
+	stop := func() {
+		atomic.StoreInt32(&piperClosed, 1)
+		closeBothSockets("Piper.Close")
+	}
+	s.stateMux.Lock()
+	s.stop = stop
+	// Close may have missed the session, it was not running yet
+	closedEarly := p.isClosed()
+	s.hijack = func() (hijackedConns, error) {
+		srd, ok1 := src.(readDeadliner)
+		drd, ok2 := dst.(readDeadliner)
+		if !ok1 || !ok2 {
+			return hijackedConns{}, ErrHijackUnsupported
+		}
+		if !atomic.CompareAndSwapInt32(&running, 1, runHijacked) {
+			return hijackedConns{}, ErrSessionNotRunning
+		}
+		atomic.StoreInt32(&s.hijacking, 1)
+		atomic.StoreInt32(&s.stopping, 1)
+		srd.SetReadDeadline(hijackDeadline)
+		drd.SetReadDeadline(hijackDeadline)
+		t := time.NewTimer(hijackWriteWait)
+		defer t.Stop()
+		select {
+		case <-hijacked:
+		case <-t.C:
+			// a copy is stuck in a write, it is stopped too
+			s.expireWrites(src, dst)
+			<-hijacked
+		}
+		return hijackedConns{dst, src, s.hijackBuf}, nil
+	}
+	defer func() {
+		s.stateMux.Lock()
+		s.hijack, s.stop = nil, nil
+		s.stateMux.Unlock()
+	}()
+	s.idleTimeout = timeout
+	if p.timeoutJitter > 0 {
+		s.idleJitter = time.Duration(rand.Int63n(int64(p.timeoutJitter*float64(timeout)) + 1))
+	}
+	s.stateMux.Unlock()
+	if closedEarly {
+		stop()
+	}
+	if s.deadlines {
+		// the copies time out on their own, only a cancellable ctx needs watching
+		if parentDone != nil {
+			go func() {
+				<-ctx.Done()
 				closeBothSockets("ctx.Done")
-				return
-			case <-timer.C:
-				if p.debugLevel > 0 {
-					p.Logger.Debug("idletimeoutpipe: timeout reached")
+			}()
+		}
+	} else {
+		go func() {
+			// without a timer yet expired stays nil until the first reset
+			var timer Timer
+			var expired <-chan time.Time
+			reset := func() {
+				d := s.idleDeadline(timeout).Sub(s.clock.Now())
+				if timer == nil {
+					timer = s.clock.NewTimer(d)
+					expired = timer.C()
+					return
 				}
-				closeBothSockets("idle")
-				return
-			case <-upstreamReset:
-				timer.Reset(timeout)
-			case <-downstreammReset:
-				timer.Reset(timeout)
+				timer.Reset(d)
 			}
-		}
-	}()
-	var w1, w2 int64
-	var err1, err2 error
-	ec := make(chan error, 2)
+			if !p.waitsFirstByte(s) {
+				first := timeout + s.idleJitter
+				if s.resumed || s.adaptive != nil {
+					first = s.idleDeadline(timeout).Sub(s.clock.Now())
+				}
+				timer = s.clock.NewTimer(first)
+				expired = timer.C()
+			}
+			defer func() {
+				if timer != nil {
+					timer.Stop() // Stop the timer when the goroutine exits
+				}
+			}()
+
+			for {
+				select {
+				case <-ctx.Done():
+					closeBothSockets("ctx.Done")
+					return
+				case <-expired:
+					if p.probeIdle(s, src, dst) {
+						reset()
+						continue
+					}
+					if p.debugEnabled(0) {
+						p.debug(s.logArgs("idletimeoutpipe: timeout reached")...)
+					}
+					p.emit(IdleTimeout, s.id, nil)
+					closeBothSockets("idle")
+					return
+				case <-upstreamReset:
+					reset()
+				case <-downstreammReset:
+					reset()
+				}
+			}
+		}()
+	}
+	var connectTimedOut int32
+	if p.MaxConnectTime > 0 {
+		connectTimer := time.AfterFunc(p.MaxConnectTime, func() {
+			if atomic.LoadInt64(&s.reads[DirectionUpstream])+atomic.LoadInt64(&s.reads[DirectionDownstream]) == 0 {
+				atomic.StoreInt32(&connectTimedOut, 1)
+				closeBothSockets("connect timeout")
+			}
+		})
+		defer connectTimer.Stop()
+	}
+	var deadlocked int32
+	if d := time.Duration(atomic.LoadInt64(&p.deadlockTimeout)); d > 0 {
+		stop := p.watchDeadlock(ctx, s, d, func() {
+			atomic.StoreInt32(&deadlocked, 1)
+			closeBothSockets("deadlock")
+		})
+		defer stop()
+	}
+	ec := make(chan copyResult, 2)
 	go func() {
-		w1, err1 = copy(src, dst, upstreamReset)
-		ec <- err1
+		w, err := p.copy(ctx, s, src, dst, cfg.BufferSize, upstreamReset, DirectionDownstream)
+		ec <- copyResult{DirectionDownstream, w, err}
 	}()
 	go func() {
-		w2, err2 = copy(dst, src, downstreammReset)
-		ec <- err2
+		w, err := p.copy(ctx, s, dst, src, cfg.BufferSize, downstreammReset, DirectionUpstream)
+		ec <- copyResult{DirectionUpstream, w, err}
 	}()
-	firstErr := <-ec
-	closeBothSockets("end of Run")
-	if p.debugLevel > 9999 {
-		p.Logger.Debug("Emptying channel")
+	first := <-ec
+	stats.add(first)
+	kept := p.keepUpstreamOpen && first.dir == DirectionUpstream && first.err == nil && keepUpstream()
+	held := !kept && first.err == nil && atomic.LoadInt32(&running) != runHijacked && p.holdOpen(s, first.dir, src, dst)
+	if !kept && !held {
+		closeBothSockets("end of Run")
 	}
-	// give the other goroutine a chance to finish ( 1 second ) before just ignoring that goroutine
-	select {
-	case <-ec: // empty the channel, equivallent to wg.Wait
-	case <-time.After(1 * time.Second):
+	if p.debugEnabled(9999) {
+		p.debug(s.logArgs("Emptying channel")...)
 	}
-	if p.debugLevel > 9999 {
-		p.Logger.Debug("Emptied channel")
+	// second stays zero when the other copy is abandoned after the drain
+	var second copyResult
+	gotSecond := true
+	// keptLost is set when the upstream meant to be kept has to be closed
+	keptLost := false
+	if held {
+		// the side left open ends with its own EOF or with the idle timeout
+		second = <-ec
+		stats.add(second)
+		closeBothSockets("end of Run")
+	} else if atomic.LoadInt32(&running) == runHijacked {
+		second = <-ec
+		stats.add(second)
+	} else {
+		// give the other goroutine a chance to finish ( 1 second ) before just ignoring that goroutine
+		select {
+		case second = <-ec: // empty the channel, equivallent to wg.Wait
+			stats.add(second)
+			if kept && errors.Is(second.err, os.ErrDeadlineExceeded) {
+				// the expired deadline is how the read was stopped
+				second.err = nil
+				src.(interface{ SetReadDeadline(time.Time) error }).SetReadDeadline(time.Time{})
+				if wd, ok := src.(interface{ SetWriteDeadline(time.Time) error }); ok {
+					wd.SetWriteDeadline(time.Time{})
+				}
+			} else if kept {
+				// the upstream ended or failed on its own, it is not reusable
+				keptLost = true
+				src.Close()
+				if isClosedErr(second.err) {
+					second.err = nil
+				}
+			}
+		case <-time.After(1 * time.Second):
+			gotSecond = false
+			if kept {
+				src.Close()
+			}
+		}
+	}
+	if p.debugEnabled(9999) {
+		p.debug(s.logArgs("Emptied channel")...)
+	}
+	if atomic.LoadInt32(&closeDeferred) == 1 {
+		if errors.Is(second.err, os.ErrDeadlineExceeded) {
+			// the expired deadline is how the copy was stopped
+			second.err = nil
+		}
+		for _, c := range []io.ReadWriteCloser{src, dst} {
+			c.(deadliner).SetDeadline(time.Time{})
+		}
+		p.closeFunc(src, dst)
+	}
+	if atomic.LoadInt32(&running) == runHijacked {
+		// both copies stopped, the connections are handed over as they are
+		for _, c := range []io.ReadWriteCloser{src, dst} {
+			c.(readDeadliner).SetReadDeadline(time.Time{})
+			if wd, ok := c.(interface{ SetWriteDeadline(time.Time) error }); ok {
+				wd.SetWriteDeadline(time.Time{})
+			}
+		}
+		close(hijacked)
+		stats.Hijacked = true
+		return stats, nil
+	}
+
+	failed, runErr := first.dir, first.err
+	if (held || keptLost) && runErr == nil {
+		failed, runErr = second.dir, second.err
+	}
+	if p.accumulateErrors && (first.err != nil || second.err != nil) {
+		var me MultiError
+		me.set(first)
+		if gotSecond {
+			me.set(second)
+		}
+		if first.err == nil {
+			failed = second.dir
+		}
+		runErr = me
+	}
+	if atomic.LoadInt32(&connectTimedOut) == 1 {
+		runErr = ErrConnectTimeout
+	}
+	if atomic.LoadInt32(&deadlocked) == 1 {
+		runErr = ErrDeadlockDetected
+	}
+	if atomic.LoadInt32(&piperClosed) == 1 {
+		runErr = context.Canceled
+	}
+	if runErr != nil {
+		return stats, &PipeError{
+			ConnectionID:     s.id,
+			Direction:        failed,
+			BytesTransferred: stats.BytesUpstream + stats.BytesDownstream,
+			Duration:         time.Since(start),
+			Err:              runErr,
+		}
+	}
+	return stats, nil
+}
+
+// readAtLeast reads at least min bytes into buf when min > 0 and does a single
+// Read otherwise, a stream ending before min bytes returns what was read with io.EOF
+func readAtLeast(src io.Reader, buf []byte, min int) (int, error) {
+	if min <= 0 {
+		return src.Read(buf)
+	}
+	n, err := io.ReadAtLeast(src, buf, min)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
 	}
+	return n, err
+}
 
-	return w1 + w2, firstErr
+// copyResult is what one copy goroutine reports back to idleTimeoutPipe
+type copyResult struct {
+	dir     Direction
+	written int64
+	err     error
 }
 
-func copy(src io.Reader, dst io.Writer, timekeeper chan struct{}) (written int64, err error) {
+func (s *RunStats) add(r copyResult) {
+	if r.dir == DirectionUpstream {
+		s.BytesUpstream = r.written
+	} else {
+		s.BytesDownstream = r.written
+	}
+}
+
+// copy moves data from src to dst until either fails
+// the rate limit for dir is read from the live config on every iteration
+// so UpdateConfig applies to running sessions
+func (p *Piper) copy(ctx context.Context, s *Session, src io.Reader, dst io.Writer, size int, timekeeper chan struct{}, dir Direction) (written int64, err error) {
 	defer close(timekeeper)
+	if atomic.LoadInt32(&p.trackGoroutines) == 1 {
+		atomic.StoreInt64(&s.goroutines[dir], goroutineID())
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			p.logError(s.logArgs("netplus: panic in copy:", r)...)
+			err = fmt.Errorf("netplus: panic in copy: %v", r)
+		}
+	}()
 
 	// buf := make([]byte, size)
-	buf := pool.Get().([]byte)
-	defer pool.Put(buf)
+	buf := p.getBuf()
+	defer p.putBuf(buf)
+	if size > len(buf) {
+		buf = make([]byte, size)
+	} else if size > 0 {
+		buf = buf[:size]
+	}
+	if p.readMax > 0 && p.readMax < len(buf) {
+		buf = buf[:p.readMax]
+	}
+	readMin := p.readMin
+	if p.minReadSize > 0 {
+		readMin = p.minReadSize
+		if readMin > len(buf) {
+			// message mode grows the buffer to hold a whole message
+			buf = make([]byte, readMin)
+		}
+	}
+	if readMin > len(buf) {
+		readMin = len(buf)
+	}
+
+	wd, canWriteDeadline := dst.(interface{ SetWriteDeadline(time.Time) error })
+	hasWriteDeadline := canWriteDeadline && p.WriteTimeout > 0
+	// RunConn sessions enforce the idle timeout with deadlines instead of a timer
+	rd, canReadDeadline := src.(interface{ SetReadDeadline(time.Time) error })
+	idle := s.idleTimeout
+	useDeadlines := s.deadlines && idle > 0 && canReadDeadline
+
+	conns := []interface{}{src, dst}
+	conn, _ := dst.(io.ReadWriteCloser)
+	if dir == DirectionDownstream && s.downstreamTurn != nil {
+		dst = &downstreamWriter{dst, s}
+	}
+	// the deadlines above stay on the connections, the buffers only batch the syscalls
+	var br *bufio.Reader
+	if p.readBufferSize > 0 {
+		br = bufio.NewReaderSize(src, p.readBufferSize)
+		src = br
+	}
+	if p.writeBufferSize > 0 {
+		bw := newBufferedWriter(dst, p.writeBufferSize, p.flushStrategy)
+		dst = bw
+		defer func() {
+			if ew := bw.close(); ew != nil && err == nil && !isClosedErr(ew) {
+				err = ew
+			}
+		}()
+	}
+
+	cw := &copyWriter{
+		p:                p,
+		ctx:              ctx,
+		s:                s,
+		dst:              dst,
+		dir:              dir,
+		conn:             conn,
+		timekeeper:       timekeeper,
+		written:          &written,
+		hasWriteDeadline: hasWriteDeadline,
+		idle:             idle,
+		bufSize:          len(buf),
+		buf:              buf,
+	}
+	if canWriteDeadline {
+		cw.wd = wd
+	}
+	if useDeadlines && p.waitsFirstByte(s) {
+		// dst is the source of the other copy, which reads without a deadline
+		cw.peerRead, _ = conn.(interface{ SetReadDeadline(time.Time) error })
+	}
+	// like io.Copy a WriterTo source picks the chunks itself, unless the reads need
+	// handling, falling back to io.Copy it reads into buf through cw.ReadFrom
+	if wt, ok := src.(io.WriterTo); ok && br == nil && !useDeadlines && readMin == 0 && p.readMax == 0 && p.readRetries == 0 {
+		_, err = wt.WriteTo(cw)
+		if err != nil && cw.err != nil {
+			err = cw.err
+		}
+		if err != nil && isTimeoutErr(err) && atomic.LoadInt32(&s.hijacking) == 1 {
+			err = p.hijackBuffered(s, br, cw)
+		}
+		return written, err
+	}
 
+	retries := 0
 	for {
-		nr, er := src.Read(buf)
-		if nr > 0 {
-			nw, ew := dst.Write(buf[0:nr])
-			if nw < 0 || nr < nw {
-				nw = 0
-				if ew == nil {
-					ew = errInvalidWrite
-				}
+		if useDeadlines && p.waitsFirstByte(s) {
+			s.setReadDeadline(rd, time.Time{})
+			// the first read of the other copy may have set the deadline meanwhile
+			if !p.waitsFirstByte(s) {
+				s.setReadDeadline(rd, s.connDeadline(idle))
 			}
-			written += int64(nw)
-			if ew != nil {
-				err = ew
+		} else if useDeadlines {
+			s.setReadDeadline(rd, s.connDeadline(idle))
+		}
+		nr, er := readAtLeast(src, buf, readMin)
+		if nr > 0 {
+			if _, err = cw.Write(buf[:nr]); err != nil {
 				break
 			}
-			if nr != nw {
-				err = ErrShortWrite
-				break
+		}
+		if er != nil && isTimeoutErr(er) && atomic.LoadInt32(&s.hijacking) == 1 {
+			err = p.hijackBuffered(s, br, cw)
+			break
+		}
+		if er == nil {
+			retries = 0
+		} else if retries < p.readRetries && isWouldBlockErr(er) {
+			retries++
+			if p.debugEnabled(0) {
+				p.debug(s.logArgs("netplus: retrying read:", er)...)
 			}
-			// non blocking send
-			select {
-			case timekeeper <- struct{}{}:
-			default:
+			s.nonFatal(er)
+			if er = sleepContext(ctx, p.readRetryDelay); er == nil {
+				continue
 			}
 		}
 		if er != nil {
+			if useDeadlines && isTimeoutErr(er) && ctx.Err() == nil {
+				// the other direction may have been active meanwhile
+				if s.clock.Now().Before(s.idleDeadline(idle)) || p.probeIdle(s, conns...) {
+					continue
+				}
+				if p.debugEnabled(0) {
+					p.debug(s.logArgs("idletimeoutpipe: timeout reached")...)
+				}
+				p.emit(IdleTimeout, s.id, nil)
+			}
 			if er != io.EOF {
 				err = er
 			}
@@ -185,3 +909,144 @@ func copy(src io.Reader, dst io.Writer, timekeeper chan struct{}) (written int64
 	}
 	return written, err
 }
+
+// copyWriter is the write half of copy, it does everything a copy does with
+// a chunk read from its source
+type copyWriter struct {
+	p    *Piper
+	ctx  context.Context
+	s    *Session
+	dst  io.Writer
+	dir  Direction
+	conn io.ReadWriteCloser // dst before any buffering, for WithOnFirstWrite
+	// peerRead starts the read deadline of the other copy after the first byte,
+	// see TimerStartOnFirstByte
+	peerRead   interface{ SetReadDeadline(time.Time) error }
+	timekeeper chan struct{}
+	written    *int64
+
+	wd               interface{ SetWriteDeadline(time.Time) error }
+	hasWriteDeadline bool
+	idle             time.Duration
+	bufSize          int // chunks shorter than the copy buffer are partial reads
+	// buf is the copy buffer ReadFrom reads into
+	buf []byte
+	// err is the last write error, the WriteTo of a net.Conn wraps what Write returns
+	err error
+}
+
+// ReadFrom copies src through Write with the copy buffer, so a WriterTo source
+// handing the copy to io.Copy does not allocate a buffer of its own
+func (w *copyWriter) ReadFrom(src io.Reader) (n int64, err error) {
+	for {
+		nr, er := src.Read(w.buf)
+		if nr > 0 {
+			nw, ew := w.Write(w.buf[:nr])
+			n += int64(nw)
+			if ew != nil {
+				return n, ew
+			}
+		}
+		if er == io.EOF {
+			return n, nil
+		}
+		if er != nil {
+			return n, er
+		}
+	}
+}
+
+func (w *copyWriter) Write(b []byte) (int, error) {
+	p, s, dir, nr := w.p, w.s, w.dir, len(b)
+	if p.deadlineExtension > 0 && w.idle > 0 && w.bufSize > 0 {
+		var extension time.Duration
+		if left := w.idle - s.clock.Now().Sub(s.LastActivity()); nr < w.bufSize && left > 0 {
+			extension = time.Duration(p.deadlineExtension * float64(left))
+		}
+		atomic.StoreInt64(&s.idleExtension, int64(extension))
+	}
+	s.read(dir, nr)
+	if w.peerRead != nil && atomic.CompareAndSwapInt32(&s.timerStarted, 0, 1) {
+		s.setReadDeadline(w.peerRead, s.connDeadline(w.idle))
+	}
+	if atomic.LoadInt32(&p.readSizeHistogram) == 1 {
+		p.readSizes.add(int64(nr))
+	}
+	if cfg := p.sessionConfig(s); cfg.rateLimit(dir) > 0 {
+		if err := s.buckets[dir].wait(w.ctx, nr, cfg.rateLimit(dir)); err != nil {
+			return 0, err
+		}
+	}
+	if l := s.readLimiters[dir]; l != nil {
+		if err := l.Wait(w.ctx, nr); err != nil {
+			return 0, err
+		}
+	}
+	if l := p.rateLimiters[dir]; l != nil {
+		if err := l.Wait(w.ctx, nr); err != nil {
+			return 0, err
+		}
+	}
+	if f := s.flows[dir]; f != nil {
+		if err := p.scheduler.wait(w.ctx, f, nr); err != nil {
+			return 0, err
+		}
+	}
+	// with CloseAfterDrain the bytes read after the peer finished are dropped
+	if atomic.LoadInt32(&s.draining[dir]) == 0 {
+		if fn := p.onFirstWrite[dir]; fn != nil && w.conn != nil && atomic.CompareAndSwapInt32(&s.firstWrite[dir], 0, 1) {
+			fn(w.conn)
+		}
+		if w.hasWriteDeadline {
+			w.wd.SetWriteDeadline(time.Now().Add(p.WriteTimeout))
+		} else if s.deadlines && w.idle > 0 && w.wd != nil {
+			// a write of RunConn stuck past the idle window fails instead of stalling the copy
+			w.wd.SetWriteDeadline(s.connDeadline(w.idle))
+		}
+		if w.wd != nil && atomic.LoadInt32(&s.stoppingWrites) == 1 {
+			// the deadline set above must not outlive the one stopping the copy
+			w.wd.SetWriteDeadline(hijackDeadline)
+		}
+		// the rate limiters and the scheduler waits are not part of the latency
+		writeAt := time.Now()
+		atomic.StoreInt64(&s.writeStart[dir], writeAt.UnixNano())
+		nw, ew := w.dst.Write(b)
+		atomic.StoreInt64(&s.writeStart[dir], 0)
+		s.observeWriteLatency(time.Since(writeAt))
+		if nw < 0 || nr < nw {
+			nw = 0
+			if ew == nil {
+				ew = errInvalidWrite
+			}
+		}
+		written := *w.written
+		if written/EventMilestoneBytes != (written+int64(nw))/EventMilestoneBytes {
+			p.emit(BytesMilestone, s.id, BytesMilestoneData{dir, written + int64(nw)})
+		}
+		*w.written += int64(nw)
+		s.wrote(dir, nw)
+		if dir == DirectionUpstream {
+			s.mirror.send(b[:nw])
+		}
+		switch {
+		case ew != nil && isClosedErr(ew) && p.drains(dir):
+			// the peer closed before the other copy saw its EOF
+			atomic.StoreInt32(&s.draining[dir], 1)
+		case ew != nil:
+			if !isClosedErr(ew) {
+				p.logError(s.logArgs("netplus: write failed:", ew)...)
+			}
+			w.err = ew
+			return nw, ew
+		case nr != nw:
+			w.err = ErrShortWrite
+			return nw, ErrShortWrite
+		}
+	}
+	// non blocking send
+	select {
+	case w.timekeeper <- struct{}{}:
+	default:
+	}
+	return nr, nil
+}
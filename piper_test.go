@@ -2,11 +2,15 @@ package netplus_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -206,3 +210,983 @@ func TestReadTimeoutReached(t *testing.T) {
 		time.Sleep(time.Millisecond)
 	}
 }
+
+func TestPiperString(t *testing.T) {
+	logger := log.NewZero(os.Stderr)
+	piper := netplus.NewPiper(logger, 30*time.Second)
+	assert.Equal(t, piper.String(), "Piper{timeout=30s, bufSize=32768, active=0}")
+	assert.Equal(t, fmt.Sprintf("%v", piper), "Piper{timeout=30s, bufSize=32768, active=0}")
+	assert.Equal(t, fmt.Sprintf("%#v", piper), "&netplus.Piper{Timeout:30000000000, debugLevel:0}")
+
+	client, downstream := net.Pipe()
+	upstream, _ := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		piper.Run(context.Background(), downstream, upstream)
+		close(done)
+	}()
+	for piper.ActiveConnections() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, piper.String(), "Piper{timeout=30s, bufSize=32768, active=1}")
+	client.Close()
+	<-done
+	assert.Equal(t, piper.ActiveConnections(), int64(0))
+
+	// both follow UpdateConfig
+	assert.Nil(t, piper.UpdateConfig(netplus.PiperConfig{Timeout: time.Minute}))
+	assert.Equal(t, piper.String(), "Piper{timeout=1m0s, bufSize=32768, active=0}")
+	assert.Equal(t, fmt.Sprintf("%#v", piper), "&netplus.Piper{Timeout:60000000000, debugLevel:0}")
+}
+
+type recordingLogger struct {
+	m     sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Debug(args ...interface{}) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.lines = append(l.lines, fmt.Sprint(args...))
+}
+
+func (l *recordingLogger) Lines() []string {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+// gatedLogger records like recordingLogger but reports levels above max as disabled
+type gatedLogger struct {
+	recordingLogger
+	max int
+}
+
+func (l *gatedLogger) IsEnabled(level int) bool {
+	return level <= l.max
+}
+
+type failingWriter struct {
+	*io.PipeReader
+}
+
+func (c *failingWriter) Write(p []byte) (n int, err error) {
+	return 0, errors.New("disk on fire")
+}
+
+func TestErrorLogger(t *testing.T) {
+	debug := &recordingLogger{}
+	errs := &recordingLogger{}
+	piper := netplus.NewPiper(debug, time.Minute, netplus.WithErrorLogger(errs))
+
+	reader, _ := io.Pipe()
+	upstream, server := net.Pipe()
+	go server.Write([]byte("hello"))
+	_, err := piper.Run(context.Background(), &failingWriter{reader}, upstream)
+	assert.NotNil(t, err)
+
+	assert.Len(t, debug.Lines(), 0)
+	assert.Len(t, errs.Lines(), 1)
+	assert.Contains(t, errs.Lines()[0], "disk on fire")
+}
+
+func TestLoggerIsEnabled(t *testing.T) {
+	for _, max := range []int{0, 10000} {
+		logger := &gatedLogger{max: max}
+		piper := netplus.NewPiper(logger, 20*time.Millisecond)
+		piper.DebugLevel(10000)
+
+		client, downstream := net.Pipe()
+		upstream, server := net.Pipe()
+		defer client.Close()
+		defer server.Close()
+		piper.Run(context.Background(), downstream, upstream)
+		if max == 0 {
+			assert.Len(t, logger.Lines(), 0)
+		} else {
+			assert.Gt(t, len(logger.Lines()), 0)
+		}
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	piper := netplus.NewPiper(nil, time.Minute)
+
+	reader, _ := io.Pipe()
+	upstream, server := net.Pipe()
+	go server.Write([]byte("hello"))
+	_, err := piper.Run(context.Background(), &failingWriter{reader}, upstream)
+	assert.NotNil(t, err)
+
+	// debug lines go nowhere
+	assert.Equal(t, piper.Logger, netplus.NopLogger{})
+	piper = netplus.NewPiper(nil, time.Minute)
+	piper.DebugLevel(10000)
+	client, downstream := net.Pipe()
+	upstream, server = net.Pipe()
+	defer server.Close()
+	client.Close()
+	piper.Run(context.Background(), downstream, upstream)
+}
+
+func TestLogSampling(t *testing.T) {
+	logger := &recordingLogger{}
+	piper := netplus.NewPiper(logger, time.Minute, netplus.WithLogSampling(3))
+	piper.DebugLevel(10000)
+
+	for i := 0; i < 4; i++ {
+		client, downstream := net.Pipe()
+		upstream, _ := net.Pipe()
+		client.Close()
+		piper.Run(context.Background(), downstream, upstream)
+	}
+
+	assert.Len(t, logger.Lines(), 3)
+	assert.Gt(t, piper.Stats().DroppedLogLines, int64(0))
+}
+
+func TestWriteTimeout(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	piper.WriteTimeout = 50 * time.Millisecond
+
+	// nobody reads from the downstream client so the write blocks
+	_, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	go server.Write([]byte("hello"))
+
+	start := time.Now()
+	_, err := piper.Run(context.Background(), downstream, upstream)
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), err)
+	assert.Lt(t, int64(time.Since(start)), int64(time.Second))
+}
+
+// unclosableConn ignores Close so only deadlines can unblock it
+type unclosableConn struct {
+	net.Conn
+}
+
+func (unclosableConn) Close() error {
+	return nil
+}
+
+func TestWriteIdleDeadline(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, 50*time.Millisecond)
+
+	// closing the downstream on idle does not unblock its write, the deadline of RunConn does
+	client, downstream := net.Pipe()
+	defer client.Close()
+	upstream, server := net.Pipe()
+	go server.Write([]byte("hello"))
+
+	start := time.Now()
+	_, err := piper.RunConn(context.Background(), unclosableConn{downstream}, upstream)
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), err)
+	assert.Lt(t, int64(time.Since(start)), int64(2*time.Second))
+}
+
+func TestRunAsync(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	done := piper.RunAsync(context.Background(), downstream, upstream)
+
+	go server.Write([]byte("hello"))
+	_, err := io.ReadFull(client, make([]byte, 5))
+	assert.Nil(t, err)
+	client.Close()
+
+	stats := <-done
+	assert.Equal(t, stats.BytesDownstream, int64(5))
+	assert.Equal(t, stats.BytesUpstream, int64(0))
+	_, ok := <-done
+	assert.False(t, ok)
+}
+
+func TestRunAsyncWithErrors(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute,
+		netplus.WithReadRetry(1, time.Millisecond),
+		netplus.WithMirror(func(ctx context.Context) (io.WriteCloser, error) {
+			return nil, errors.New("mirror down")
+		}))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	done, errs := piper.RunAsyncWithErrors(context.Background(), &wouldBlockConn{Conn: downstream, failures: 1}, upstream)
+
+	// both come in while the session keeps going, the mirror is dialled in the
+	// background so in any order
+	first, second := <-errs, <-errs
+	if first.Error() != "mirror down" {
+		first, second = second, first
+	}
+	assert.Equal(t, first.Error(), "mirror down")
+	assert.True(t, errors.Is(second, syscall.EAGAIN))
+	go client.Write([]byte("hello"))
+	_, err := io.ReadFull(server, make([]byte, 5))
+	assert.Nil(t, err)
+	client.Close()
+
+	stats := <-done
+	assert.Nil(t, stats.Err)
+	assert.Equal(t, stats.BytesUpstream, int64(5))
+	_, ok := <-errs
+	assert.False(t, ok)
+}
+
+func TestTimeline(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithTimeline(10*time.Millisecond))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	done := piper.RunAsync(context.Background(), downstream, upstream)
+
+	go server.Write([]byte("hello"))
+	_, err := io.ReadFull(client, make([]byte, 5))
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	client.Close()
+
+	stats := <-done
+	assert.Ge(t, len(stats.Timeline), 4)
+	var down int64
+	for _, pt := range stats.Timeline {
+		down += pt.DownBytes
+		assert.Equal(t, pt.UpBytes, int64(0))
+	}
+	assert.Equal(t, down, int64(5))
+}
+
+func TestTimelineSize(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute,
+		netplus.WithTimeline(time.Millisecond), netplus.WithTimelineSize(3))
+
+	client, downstream := net.Pipe()
+	upstream, _ := net.Pipe()
+	done := piper.RunAsync(context.Background(), downstream, upstream)
+
+	time.Sleep(50 * time.Millisecond)
+	client.Close()
+
+	stats := <-done
+	assert.Equal(t, len(stats.Timeline), 3)
+	for i := 1; i < len(stats.Timeline); i++ {
+		assert.True(t, stats.Timeline[i].Timestamp.After(stats.Timeline[i-1].Timestamp))
+	}
+	assert.True(t, time.Since(stats.Timeline[2].Timestamp) < 20*time.Millisecond)
+}
+
+// chunkWriter records the size of every write
+type chunkWriter struct {
+	net.Conn
+	mux    sync.Mutex
+	chunks []int
+}
+
+func (c *chunkWriter) Write(b []byte) (int, error) {
+	c.mux.Lock()
+	c.chunks = append(c.chunks, len(b))
+	c.mux.Unlock()
+	return c.Conn.Write(b)
+}
+
+func TestReadHint(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithReadHint(4, 6))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	w := &chunkWriter{Conn: upstream}
+	go io.Copy(io.Discard, server)
+	go func() {
+		for _, b := range []string{"ab", "cd", "efghijkl", "m"} {
+			client.Write([]byte(b))
+		}
+		client.Close()
+	}()
+	written, err := piper.Run(context.Background(), downstream, w)
+	assert.Nil(t, err)
+	assert.Equal(t, written, int64(13))
+	// writes wait for 4 bytes and never exceed 6, the tail goes out at EOF
+	assert.Equal(t, w.chunks, []int{4, 6, 3})
+}
+
+func TestMinReadSize(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithMinReadSize(10))
+	assert.Nil(t, piper.UpdateConfig(netplus.PiperConfig{Timeout: time.Minute, BufferSize: 4}))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	w := &chunkWriter{Conn: upstream}
+	go io.Copy(io.Discard, server)
+	go func() {
+		for _, b := range []string{"abc", "defg", "hij", "k"} {
+			client.Write([]byte(b))
+		}
+		client.Close()
+	}()
+	written, err := piper.Run(context.Background(), downstream, w)
+	assert.Nil(t, err)
+	assert.Equal(t, written, int64(11))
+	// the message is larger than the buffer size and still goes out whole
+	assert.Equal(t, w.chunks, []int{10, 1})
+}
+
+func TestGoroutinePool(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithGoroutinePool(3))
+
+	client, downstream := net.Pipe()
+	upstream, _ := net.Pipe()
+	done := piper.RunAsync(context.Background(), downstream, upstream)
+	for piper.ActiveConnections() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// no room for a second session
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	d2, _ := net.Pipe()
+	u2, _ := net.Pipe()
+	_, err := piper.Run(ctx, d2, u2)
+	assert.Equal(t, err, netplus.ErrPoolExhausted)
+	// a cancelled wait is not an exhausted pool
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = piper.Run(ctx, d2, u2)
+	assert.Equal(t, err, context.Canceled)
+
+	client.Close()
+	<-done
+	c3, d3 := net.Pipe()
+	u3, _ := net.Pipe()
+	c3.Close()
+	_, err = piper.Run(context.Background(), d3, u3)
+	assert.Nil(t, err)
+}
+
+func TestIterationHistogram(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithIterationHistogram(true))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	go io.Copy(io.Discard, server)
+	go func() {
+		client.Write([]byte("a"))
+		client.Write([]byte("bcd"))
+		client.Write([]byte("efgh"))
+		client.Close()
+	}()
+	done := piper.RunAsync(context.Background(), downstream, upstream)
+	stats := <-done
+	assert.Equal(t, stats.IterationHistogram, []netplus.HistogramBucket{
+		{Min: 0, Max: 1, Count: 1},
+		{Min: 2, Max: 3, Count: 1},
+		{Min: 4, Max: 7, Count: 1},
+	})
+}
+
+func TestIterationCounts(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	go func() {
+		b := make([]byte, 4)
+		io.ReadFull(server, b)
+		server.Write([]byte("pong"))
+	}()
+	go func() {
+		client.Write([]byte("pi"))
+		client.Write([]byte("ng"))
+		io.ReadFull(client, make([]byte, 4))
+		client.Close()
+	}()
+	stats := <-piper.RunAsync(context.Background(), downstream, upstream)
+	assert.Equal(t, stats.IterationsUpstream, int64(2))
+	assert.Equal(t, stats.IterationsDownstream, int64(1))
+}
+
+func TestIdleTimerResetUnderLoad(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, 50*time.Millisecond)
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	done := piper.RunAsync(context.Background(), downstream, upstream)
+
+	// small writes in both directions, never idle for the timeout
+	stop := time.Now().Add(300 * time.Millisecond)
+	var wg sync.WaitGroup
+	for _, c := range []net.Conn{client, server} {
+		c := c
+		go io.Copy(io.Discard, c)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(stop) {
+				if _, err := c.Write([]byte("x")); err != nil {
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+	select {
+	case <-done:
+		t.Fatal("session timed out while busy")
+	default:
+	}
+	stats := <-done
+	assert.Ge(t, int64(stats.Duration), int64(300*time.Millisecond))
+}
+
+func TestMaxConnectTime(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	piper.MaxConnectTime = 50 * time.Millisecond
+
+	// silent connection
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	_, err := piper.Run(context.Background(), downstream, upstream)
+	assert.True(t, errors.Is(err, netplus.ErrConnectTimeout))
+
+	// the first byte arrives in time, the session outlives MaxConnectTime
+	client, downstream = net.Pipe()
+	upstream, server = net.Pipe()
+	go io.Copy(io.Discard, server)
+	go func() {
+		client.Write([]byte("x"))
+		time.Sleep(100 * time.Millisecond)
+		client.Close()
+	}()
+	stats := <-piper.RunAsync(context.Background(), downstream, upstream)
+	assert.Nil(t, stats.Err)
+	assert.Ge(t, int64(stats.Duration), int64(100*time.Millisecond))
+}
+
+func TestWaitIdle(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	assert.Nil(t, piper.WaitIdle(context.Background()))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer server.Close()
+	piper.RunAsync(context.Background(), downstream, upstream)
+	for piper.ActiveConnections() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, piper.WaitIdle(ctx), context.DeadlineExceeded)
+
+	time.AfterFunc(20*time.Millisecond, func() { client.Close() })
+	assert.Nil(t, piper.WaitIdle(context.Background()))
+	assert.Equal(t, piper.ActiveConnections(), int64(0))
+}
+
+func TestDurationHistogram(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	for i := 0; i < 2; i++ {
+		client, downstream := net.Pipe()
+		upstream, server := net.Pipe()
+		defer server.Close()
+		client.Close()
+		piper.Run(context.Background(), downstream, upstream)
+	}
+	assert.Equal(t, piper.Stats().DurationHistogram, []netplus.HistogramBucket{{Min: 0, Max: 1, Count: 2}})
+
+	piper.ResetStats()
+	assert.Len(t, piper.Stats().DurationHistogram, 0)
+}
+
+func TestReadSizeHistogram(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	runChunks(t, piper, "abc")
+	assert.Len(t, piper.Stats().ReadSizeHistogram, 0)
+
+	piper = netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithReadSizeHistogram(true))
+	runChunks(t, piper, "a", "abc")
+	assert.Equal(t, piper.Stats().ReadSizeHistogram, []netplus.HistogramBucket{
+		{Min: 0, Max: 1, Count: 1},
+		{Min: 2, Max: 3, Count: 1},
+	})
+	piper.ResetStats()
+	assert.Len(t, piper.Stats().ReadSizeHistogram, 0)
+}
+
+// runChunks pipes every chunk in its own write from downstream to upstream
+func runChunks(t *testing.T, piper *netplus.Piper, chunks ...string) {
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	go io.Copy(io.Discard, server)
+	go func() {
+		for _, c := range chunks {
+			client.Write([]byte(c))
+		}
+		client.Close()
+	}()
+	_, err := piper.Run(context.Background(), downstream, upstream)
+	assert.Nil(t, err)
+}
+
+func TestTimeoutJitter(t *testing.T) {
+	timeout := 50 * time.Millisecond
+	piper := netplus.NewPiper(&recordingLogger{}, timeout, netplus.WithTimeoutJitter(1))
+
+	var wg sync.WaitGroup
+	durations := make([]time.Duration, 8)
+	for i := range durations {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client, downstream := net.Pipe()
+			upstream, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			stats := <-piper.RunAsync(context.Background(), downstream, upstream)
+			durations[i] = stats.Duration
+		}(i)
+	}
+	wg.Wait()
+
+	spread := false
+	for _, d := range durations {
+		assert.Ge(t, int64(d), int64(timeout))
+		assert.Lt(t, int64(d), int64(2*timeout+time.Second))
+		if d > timeout+10*time.Millisecond {
+			spread = true
+		}
+	}
+	assert.True(t, spread)
+}
+
+// wouldBlockConn fails its first reads with EAGAIN
+type wouldBlockConn struct {
+	net.Conn
+	failures int32
+}
+
+func (c *wouldBlockConn) Read(b []byte) (int, error) {
+	if atomic.AddInt32(&c.failures, -1) >= 0 {
+		return 0, syscall.EAGAIN
+	}
+	return c.Conn.Read(b)
+}
+
+func TestReadRetry(t *testing.T) {
+	run := func(piper *netplus.Piper) (string, error) {
+		client, downstream := net.Pipe()
+		upstream, server := net.Pipe()
+		received := make(chan string, 1)
+		go func() {
+			b, _ := io.ReadAll(server)
+			received <- string(b)
+		}()
+		go func() {
+			client.Write([]byte("hello"))
+			client.Close()
+		}()
+		_, err := piper.Run(context.Background(), &wouldBlockConn{Conn: downstream, failures: 2}, upstream)
+		server.Close()
+		client.Close()
+		return <-received, err
+	}
+
+	got, err := run(netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithReadRetry(3, time.Millisecond)))
+	assert.Nil(t, err)
+	assert.Equal(t, got, "hello")
+
+	_, err = run(netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithReadRetry(1, time.Millisecond)))
+	assert.True(t, errors.Is(err, syscall.EAGAIN))
+}
+
+func TestRunReturnsOnCancel(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	pairs := []func() (net.Conn, net.Conn){net.Pipe, func() (net.Conn, net.Conn) { return tcpPair(t) }}
+	runs := []func(ctx context.Context, d, u net.Conn){
+		func(ctx context.Context, d, u net.Conn) { piper.Run(ctx, d, u) },
+		func(ctx context.Context, d, u net.Conn) { piper.RunConn(ctx, d, u) },
+	}
+	for _, pair := range pairs {
+		for _, run := range runs {
+			client, downstream := pair()
+			upstream, server := pair()
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan time.Time, 1)
+			go func() {
+				run(ctx, downstream, upstream)
+				done <- time.Now()
+			}()
+			time.Sleep(20 * time.Millisecond)
+			cancelled := time.Now()
+			cancel()
+			// both sockets are closed, neither copy waits the 1s drain for the other
+			assert.Lt(t, int64((<-done).Sub(cancelled)), int64(200*time.Millisecond))
+			client.Close()
+			server.Close()
+		}
+	}
+}
+
+// readCounter counts the reads of the connection
+type readCounter struct {
+	net.Conn
+	reads int32
+}
+
+func (c *readCounter) Read(b []byte) (int, error) {
+	atomic.AddInt32(&c.reads, 1)
+	return c.Conn.Read(b)
+}
+
+func TestBufferedIO(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithBufferedIO(64, 64))
+	assert.Nil(t, piper.UpdateConfig(netplus.PiperConfig{Timeout: time.Minute, BufferSize: 2}))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	received := make(chan string, 1)
+	go func() {
+		b, _ := io.ReadAll(server)
+		received <- string(b)
+	}()
+	go func() {
+		client.Write([]byte("abcdef"))
+		client.Close()
+	}()
+	r := &readCounter{Conn: downstream}
+	written, err := piper.Run(context.Background(), r, upstream)
+	assert.Nil(t, err)
+	assert.Equal(t, written, int64(6))
+	assert.Equal(t, <-received, "abcdef")
+	// one read fills the buffer and one sees EOF, instead of one per 2 bytes
+	assert.Equal(t, atomic.LoadInt32(&r.reads), int32(2))
+}
+
+// writerToConn records that the copy went through WriteTo
+type writerToConn struct {
+	net.Conn
+	used int32
+}
+
+func (c *writerToConn) WriteTo(w io.Writer) (int64, error) {
+	atomic.StoreInt32(&c.used, 1)
+	return io.Copy(w, c.Conn)
+}
+
+func TestWriterToSource(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	received := make(chan string, 1)
+	go func() {
+		b, _ := io.ReadAll(server)
+		received <- string(b)
+	}()
+	go func() {
+		client.Write([]byte("hello"))
+		client.Close()
+	}()
+	src := &writerToConn{Conn: downstream}
+	stats := <-piper.RunAsync(context.Background(), src, upstream)
+	assert.Nil(t, stats.Err)
+	assert.Equal(t, stats.BytesUpstream, int64(5))
+	assert.Equal(t, <-received, "hello")
+	assert.Equal(t, atomic.LoadInt32(&src.used), int32(1))
+}
+
+func TestWriterToSourceBuffer(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	cfg := piper.Config()
+	cfg.BufferSize = 3
+	assert.Nil(t, piper.UpdateConfig(cfg))
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	chunks := make(chan int, 16)
+	go func() {
+		defer close(chunks)
+		b := make([]byte, 64)
+		for {
+			n, err := server.Read(b)
+			if err != nil {
+				return
+			}
+			chunks <- n
+		}
+	}()
+	go func() {
+		client.Write([]byte("hello world"))
+		client.Close()
+	}()
+	src := &writerToConn{Conn: downstream}
+	stats := <-piper.RunAsync(context.Background(), src, upstream)
+	assert.Nil(t, stats.Err)
+	assert.Equal(t, atomic.LoadInt32(&src.used), int32(1))
+
+	// io.Copy inside WriteTo reads into the copy buffer of the configured size
+	total := 0
+	for n := range chunks {
+		assert.Le(t, n, 3)
+		total += n
+	}
+	assert.Equal(t, total, 11)
+}
+
+func TestDeadlineExtensionFactor(t *testing.T) {
+	run := func(piper *netplus.Piper, conn bool) string {
+		client, downstream := tcpPair(t)
+		upstream, server := tcpPair(t)
+		defer server.Close()
+		received := make(chan string, 1)
+		go func() {
+			b, _ := io.ReadAll(server)
+			received <- string(b)
+		}()
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			client.Write([]byte("a"))
+			// past the idle timeout after the first byte, within the extension
+			time.Sleep(150 * time.Millisecond)
+			client.Write([]byte("b"))
+			client.Close()
+		}()
+		if conn {
+			piper.RunConn(context.Background(), downstream, upstream)
+		} else {
+			piper.Run(context.Background(), downstream, upstream)
+		}
+		return <-received
+	}
+	for _, conn := range []bool{false, true} {
+		piper := netplus.NewPiper(&recordingLogger{}, 100*time.Millisecond)
+		assert.Equal(t, run(piper, conn), "a")
+
+		piper = netplus.NewPiper(&recordingLogger{}, 100*time.Millisecond, netplus.WithDeadlineExtensionFactor(1))
+		assert.Equal(t, run(piper, conn), "ab")
+	}
+}
+
+func TestOnFirstWrite(t *testing.T) {
+	var calls int32
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute,
+		netplus.WithOnFirstWrite(netplus.DirectionDownstream, func(conn io.ReadWriteCloser) {
+			atomic.AddInt32(&calls, 1)
+			conn.Write([]byte("220 banner\n"))
+		}))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	done := piper.RunAsync(context.Background(), downstream, upstream)
+
+	// the banner goes out once, ahead of the first forwarded byte
+	go func() {
+		server.Write([]byte("a"))
+		server.Write([]byte("b"))
+		server.Close()
+	}()
+	b, err := io.ReadAll(client)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "220 banner\nab")
+	<-done
+	assert.Equal(t, atomic.LoadInt32(&calls), int32(1))
+}
+
+func TestAvgWriteLatency(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+
+	// net.Pipe writes return once the server has read, which it does slowly
+	go func() {
+		b := make([]byte, 64)
+		for {
+			time.Sleep(10 * time.Millisecond)
+			if _, err := server.Read(b); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		for i := 0; i < 5; i++ {
+			client.Write([]byte("x"))
+		}
+		client.Close()
+	}()
+	stats := <-piper.RunAsync(context.Background(), downstream, upstream)
+	assert.Gt(t, stats.AvgWriteLatencyNs, int64(5*time.Millisecond))
+	assert.Lt(t, stats.AvgWriteLatencyNs, int64(time.Second))
+}
+
+// sleepLimiter makes every read wait d
+type sleepLimiter time.Duration
+
+func (l sleepLimiter) Wait(ctx context.Context, n int) error {
+	time.Sleep(time.Duration(l))
+	return nil
+}
+
+func TestAvgWriteLatencyWithoutLimiterWait(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute,
+		netplus.WithRateLimiter(netplus.DirectionBoth, sleepLimiter(20*time.Millisecond)))
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	go io.Copy(io.Discard, server)
+	go func() {
+		for i := 0; i < 5; i++ {
+			client.Write([]byte("x"))
+		}
+		client.Close()
+	}()
+	stats := <-piper.RunAsync(context.Background(), downstream, upstream)
+	assert.Gt(t, stats.AvgWriteLatencyNs, int64(0))
+	assert.Lt(t, stats.AvgWriteLatencyNs, int64(10*time.Millisecond))
+}
+
+func TestAdaptiveTimeout(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute,
+		netplus.WithAdaptiveTimeout(50*time.Millisecond, 400*time.Millisecond, 1<<20))
+	for _, size := range []int{0, 10 << 20} {
+		client, downstream := net.Pipe()
+		upstream, server := net.Pipe()
+		go io.Copy(io.Discard, server)
+		go client.Write(make([]byte, size))
+
+		start := time.Now()
+		piper.Run(context.Background(), downstream, upstream)
+		elapsed := time.Since(start)
+		if size == 0 {
+			// an idle session gets the shortest timeout
+			assert.Lt(t, elapsed, 300*time.Millisecond)
+		} else {
+			assert.Gt(t, elapsed, 350*time.Millisecond)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+// runTransfer pipes size bytes from downstream to upstream with one Run
+// over loopback TCP
+func runTransfer(b *testing.B, piper *netplus.Piper, chunk, sink []byte, size int) {
+	client, downstream, err := dialPair()
+	if err != nil {
+		b.Fatal(err)
+	}
+	upstream, server, err := dialPair()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		for sent := 0; sent < size; sent += len(chunk) {
+			client.Write(chunk)
+		}
+		client.Close()
+	}()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, err := server.Read(sink); err != nil {
+				return
+			}
+		}
+	}()
+	piper.Run(context.Background(), downstream, upstream)
+	<-done
+}
+
+func BenchmarkPiper_Run_Allocs(b *testing.B) {
+	const size = 1 << 20
+	piper := netplus.NewPiper(nil, time.Minute)
+	chunk, sink := make([]byte, 32*1024), make([]byte, 32*1024)
+	runTransfer(b, piper, chunk, sink, size)
+
+	// the copy loop allocates nothing, a Run of 1 MB costs what one of a
+	// single chunk does
+	single := testing.AllocsPerRun(20, func() { runTransfer(b, piper, chunk, sink, len(chunk)) })
+	full := testing.AllocsPerRun(20, func() { runTransfer(b, piper, chunk, sink, size) })
+	if full > single+2 {
+		b.Fatalf("the copy loop allocates: %.0f allocs for 1 MB, %.0f for one chunk", full, single)
+	}
+	// the copies read into pooled buffers, not into ones io.Copy allocates
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for i := 0; i < 20; i++ {
+		runTransfer(b, piper, chunk, sink, size)
+	}
+	runtime.ReadMemStats(&after)
+	if perRun := (after.TotalAlloc - before.TotalAlloc) / 20; perRun >= uint64(len(chunk)) {
+		b.Fatalf("a Run allocates %d bytes, a copy buffer is %d", perRun, len(chunk))
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runTransfer(b, piper, chunk, sink, size)
+	}
+}
+
+func TestIdleTimeoutAccuracy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing sensitive")
+	}
+	piper := netplus.NewPiper(nil, 100*time.Millisecond)
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	done := make(chan time.Time, 1)
+	go func() {
+		piper.Run(context.Background(), downstream, upstream)
+		done <- time.Now()
+	}()
+
+	// a single byte and then silence
+	client.Write([]byte("x"))
+	_, err := io.ReadFull(server, make([]byte, 1))
+	assert.Nil(t, err)
+	last := time.Now()
+
+	select {
+	case end := <-done:
+		elapsed := end.Sub(last)
+		assert.Ge(t, elapsed, 90*time.Millisecond)
+		assert.Le(t, elapsed, 130*time.Millisecond)
+	case <-time.After(time.Second):
+		t.Fatal("the idle timeout did not fire")
+	}
+}
+
+func TestContextCancellationLatency(t *testing.T) {
+	piper := netplus.NewPiper(nil, 10*time.Minute)
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan time.Time, 1)
+	go func() {
+		piper.Run(ctx, downstream, upstream)
+		done <- time.Now()
+	}()
+	client.Write([]byte("x"))
+	_, err := io.ReadFull(server, make([]byte, 1))
+	assert.Nil(t, err)
+
+	// no drain delay once the context is cancelled, the bound leaves room for
+	// a loaded machine and is still far below the 1s drain
+	cancelled := time.Now()
+	cancel()
+	select {
+	case end := <-done:
+		assert.Le(t, end.Sub(cancelled), 200*time.Millisecond)
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after the context was cancelled")
+	}
+}
@@ -0,0 +1,125 @@
+package netplus
+
+import (
+	"errors"
+	"io"
+)
+
+// errInvalidRead is the failure of a reader returning a negative count
+var errInvalidRead = errors.New("invalid read result")
+
+// PooledReader reads ahead from an io.Reader into a buffer taken from a
+// BufferPool so small reads, like those of a protocol parser, are served from
+// memory, the buffer goes back to the pool on Close, it is not safe for
+// concurrent use
+type PooledReader struct {
+	r    io.Reader
+	pool *BufferPool
+	buf  []byte // taken from the pool by the first read
+	// buf[start:end] is read ahead and not returned yet
+	start, end int
+	err        error // the read failure returned once the buffer is empty
+	closed     bool
+}
+
+// NewPooledReader returns a PooledReader reading from r with a buffer of
+// pool, a nil pool uses the package pool
+func NewPooledReader(r io.Reader, pool *BufferPool) *PooledReader {
+	if pool == nil {
+		pool = defaultPool
+	}
+	return &PooledReader{r: r, pool: pool}
+}
+
+// Read returns what is read ahead, or reads from r once it is all returned,
+// a read at least as large as the buffer goes to r as it is
+func (pr *PooledReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if pr.start == pr.end && !pr.closed && pr.err == nil && len(b) >= pr.pool.BufferSize() {
+		return pr.r.Read(b)
+	}
+	if err := pr.ahead(); err != nil {
+		return 0, err
+	}
+	n := copy(b, pr.buf[pr.start:pr.end])
+	pr.start += n
+	return n, nil
+}
+
+// ReadByte implements io.ByteReader
+func (pr *PooledReader) ReadByte() (byte, error) {
+	if err := pr.ahead(); err != nil {
+		return 0, err
+	}
+	c := pr.buf[pr.start]
+	pr.start++
+	return c, nil
+}
+
+// ahead makes sure some bytes are read ahead, or returns why none are
+func (pr *PooledReader) ahead() error {
+	if pr.closed {
+		return io.ErrClosedPipe
+	}
+	if pr.start < pr.end {
+		return nil
+	}
+	if pr.err == nil {
+		if pr.buf == nil {
+			pr.buf = pr.pool.Get()
+		}
+		pr.fill()
+		if pr.start < pr.end {
+			return nil
+		}
+	}
+	// the failure is returned once, like a Read of r would, EOF stays
+	err := pr.err
+	if err != io.EOF {
+		pr.err = nil
+	}
+	return err
+}
+
+// Buffered returns the number of bytes read ahead and not returned yet
+func (pr *PooledReader) Buffered() int {
+	return pr.end - pr.start
+}
+
+// fill reads into the empty buffer, retrying reads returning nothing a few
+// times like bufio.Reader
+func (pr *PooledReader) fill() {
+	pr.start, pr.end = 0, 0
+	for i := 0; i < 100; i++ {
+		n, err := pr.r.Read(pr.buf)
+		if n < 0 {
+			n, err = 0, errInvalidRead
+		}
+		pr.end = n
+		if err != nil {
+			pr.err = err
+			return
+		}
+		if n > 0 {
+			return
+		}
+	}
+	pr.err = io.ErrNoProgress
+}
+
+// Close gives the buffer back to the pool and drops what is read ahead, it
+// does not close r, later reads fail with io.ErrClosedPipe
+func (pr *PooledReader) Close() error {
+	if pr.closed {
+		return nil
+	}
+	pr.closed = true
+	pr.start, pr.end = 0, 0
+	if pr.buf != nil {
+		pr.pool.Put(pr.buf)
+		pr.buf = nil
+	}
+	return nil
+}
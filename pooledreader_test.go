@@ -0,0 +1,58 @@
+package netplus_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+// countingReader counts the reads it gets
+type countingReader struct {
+	io.Reader
+	reads int
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	r.reads++
+	return r.Reader.Read(b)
+}
+
+func TestPooledReader(t *testing.T) {
+	src := &countingReader{Reader: strings.NewReader("abcdefghij0123456789")}
+	pr := netplus.NewPooledReader(src, netplus.NewBufferPool(8))
+
+	c, err := pr.ReadByte()
+	assert.Nil(t, err)
+	assert.Equal(t, c, byte('a'))
+	buf := make([]byte, 3)
+	n, err := pr.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, string(buf[:n]), "bcd")
+	// both came from a single read ahead
+	assert.Equal(t, src.reads, 1)
+	assert.Equal(t, pr.Buffered(), 4)
+
+	rest, err := io.ReadAll(pr)
+	assert.Nil(t, err)
+	assert.Equal(t, string(rest), "efghij0123456789")
+	_, err = pr.ReadByte()
+	assert.Equal(t, err, io.EOF)
+
+	assert.Nil(t, pr.Close())
+	_, err = pr.Read(buf)
+	assert.Equal(t, err, io.ErrClosedPipe)
+}
+
+func TestPooledReaderLargeRead(t *testing.T) {
+	src := &countingReader{Reader: strings.NewReader("abcdefghij")}
+	pr := netplus.NewPooledReader(src, netplus.NewBufferPool(8))
+	buf := make([]byte, 16)
+	n, err := pr.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, string(buf[:n]), "abcdefghij")
+	assert.Equal(t, pr.Buffered(), 0)
+	pr.Close()
+}
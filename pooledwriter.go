@@ -0,0 +1,101 @@
+package netplus
+
+import "io"
+
+// PooledWriter batches small writes to an io.Writer in a buffer taken from a
+// BufferPool, the buffer is written out once full or on Flush and goes back
+// to the pool on Close, it is not safe for concurrent use
+type PooledWriter struct {
+	w    io.Writer
+	pool *BufferPool
+	buf  []byte // taken from the pool by the first Write
+	n    int
+	err  error // the first write failure, returned by every call afterwards
+	// closed is set by Close, the buffer is back in the pool
+	closed bool
+}
+
+// NewPooledWriter returns a PooledWriter writing to w with a buffer of pool,
+// a nil pool uses the package pool
+func NewPooledWriter(w io.Writer, pool *BufferPool) *PooledWriter {
+	if pool == nil {
+		pool = defaultPool
+	}
+	return &PooledWriter{w: w, pool: pool}
+}
+
+// Write buffers b, writing the buffer out each time it is full, a write at
+// least as large as an empty buffer goes to w as it is
+func (pw *PooledWriter) Write(b []byte) (int, error) {
+	if pw.err != nil {
+		return 0, pw.err
+	}
+	if pw.buf == nil {
+		pw.buf = pw.pool.Get()
+	}
+	written := 0
+	for len(b) > 0 {
+		if pw.n == 0 && len(b) >= len(pw.buf) {
+			n, err := pw.w.Write(b)
+			written += n
+			if err == nil && n < len(b) {
+				err = io.ErrShortWrite
+			}
+			pw.err = err
+			return written, err
+		}
+		n := copy(pw.buf[pw.n:], b)
+		pw.n += n
+		written += n
+		b = b[n:]
+		if pw.n == len(pw.buf) {
+			if err := pw.Flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush writes the buffered bytes to w
+func (pw *PooledWriter) Flush() error {
+	if pw.err != nil {
+		return pw.err
+	}
+	if pw.n == 0 {
+		return nil
+	}
+	n, err := pw.w.Write(pw.buf[:pw.n])
+	if err == nil && n < pw.n {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		pw.err = err
+		return err
+	}
+	pw.n = 0
+	return nil
+}
+
+// Buffered returns the number of bytes waiting for the next Flush
+func (pw *PooledWriter) Buffered() int {
+	return pw.n
+}
+
+// Close flushes the buffered bytes and gives the buffer back to the pool,
+// it does not close w, later writes fail with io.ErrClosedPipe
+func (pw *PooledWriter) Close() error {
+	if pw.closed {
+		return nil
+	}
+	pw.closed = true
+	err := pw.Flush()
+	if pw.err == nil {
+		pw.err = io.ErrClosedPipe
+	}
+	if pw.buf != nil {
+		pw.pool.Put(pw.buf)
+		pw.buf = nil
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package netplus_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+// countingWriter counts the writes it gets
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(b)
+}
+
+func TestPooledWriter(t *testing.T) {
+	dst := &countingWriter{}
+	pw := netplus.NewPooledWriter(dst, netplus.NewBufferPool(8))
+
+	for i := 0; i < 5; i++ {
+		n, err := pw.Write([]byte("abc"))
+		assert.Nil(t, err)
+		assert.Equal(t, n, 3)
+	}
+	// one full buffer went out, the rest waits
+	assert.Equal(t, dst.writes, 1)
+	assert.Equal(t, pw.Buffered(), 7)
+	assert.Nil(t, pw.Flush())
+	assert.Equal(t, dst.writes, 2)
+
+	// a large write skips the buffer
+	pw.Write([]byte("0123456789"))
+	assert.Equal(t, dst.writes, 3)
+
+	pw.Write([]byte("end"))
+	assert.Nil(t, pw.Close())
+	assert.Equal(t, dst.String(), "abcabcabcabcabc0123456789end")
+	assert.Nil(t, pw.Close())
+	_, err := pw.Write([]byte("x"))
+	assert.Equal(t, err, io.ErrClosedPipe)
+}
+
+func TestPooledWriterError(t *testing.T) {
+	pw := netplus.NewPooledWriter(&failingWriter{}, nil)
+	_, err := pw.Write([]byte("abc"))
+	assert.Nil(t, err)
+	assert.Equal(t, pw.Flush(), errors.New("disk on fire"))
+	_, err = pw.Write([]byte("abc"))
+	assert.Equal(t, err, errors.New("disk on fire"))
+	assert.Equal(t, pw.Close(), errors.New("disk on fire"))
+}
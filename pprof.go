@@ -0,0 +1,75 @@
+package netplus
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"runtime/pprof"
+	"strings"
+	"sync/atomic"
+)
+
+// PoolStats reports how the copy buffer pool is being used
+type PoolStats struct {
+	Gets    uint64  `json:"gets"`
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+	// Reclaimed counts the buffers dropped by WithPoolReclaimInterval
+	Reclaimed uint64 `json:"reclaimed"`
+}
+
+var poolGets, poolMisses, poolReclaimed uint64
+
+func getPoolStats() PoolStats {
+	gets := atomic.LoadUint64(&poolGets)
+	misses := atomic.LoadUint64(&poolMisses)
+	// misses can briefly run ahead of gets while both are being updated
+	if misses > gets {
+		misses = gets
+	}
+	s := PoolStats{Gets: gets, Hits: gets - misses, Misses: misses, Reclaimed: atomic.LoadUint64(&poolReclaimed)}
+	if gets > 0 {
+		s.HitRate = float64(s.Hits) / float64(gets)
+	}
+	return s
+}
+
+// RegisterPprof registers netplus specific debug handlers on mux under prefix, e.g. /debug/netplus/
+//
+//	pool        buffer pool hits and misses as JSON
+//	goroutines  stack traces of the goroutines running netplus code
+//	histogram   copy loop read sizes of this Piper as JSON
+//
+// Registering turns on the read size histogram as WithReadSizeHistogram does
+func (p *Piper) RegisterPprof(mux *http.ServeMux, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	atomic.StoreInt32(&p.readSizeHistogram, 1)
+
+	mux.HandleFunc(prefix+"/pool", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, getPoolStats())
+	})
+	mux.HandleFunc(prefix+"/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := pprof.Lookup("goroutine").WriteTo(&buf, 2); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, g := range strings.Split(buf.String(), "\n\n") {
+			if strings.Contains(g, "go.ideatocode.tech/netplus.") {
+				w.Write([]byte(g + "\n\n"))
+			}
+		}
+	})
+	mux.HandleFunc(prefix+"/histogram", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, p.readSizes.buckets())
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
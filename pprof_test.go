@@ -0,0 +1,95 @@
+package netplus_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/log"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestRegisterPprof(t *testing.T) {
+	logger := log.NewZero(os.Stderr)
+	piper := netplus.NewPiper(logger, time.Minute)
+
+	mux := http.NewServeMux()
+	piper.RegisterPprof(mux, "/debug/netplus/")
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		piper.Run(context.Background(), downstream, upstream)
+		close(done)
+	}()
+	go server.Write([]byte("hello"))
+	_, err := io.ReadFull(client, make([]byte, 5))
+	assert.Nil(t, err)
+
+	resp, err := http.Get(srv.URL + "/debug/netplus/goroutines")
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Contains(t, string(body), "netplus.(*Piper)")
+
+	client.Close()
+	<-done
+
+	resp, err = http.Get(srv.URL + "/debug/netplus/histogram")
+	assert.Nil(t, err)
+	var buckets []netplus.HistogramBucket
+	err = json.NewDecoder(resp.Body).Decode(&buckets)
+	resp.Body.Close()
+	assert.Nil(t, err)
+	assert.Len(t, buckets, 3)
+	assert.Equal(t, buckets[2], netplus.HistogramBucket{Min: 4, Max: 7, Count: 1})
+
+	resp, err = http.Get(srv.URL + "/debug/netplus/pool")
+	assert.Nil(t, err)
+	var stats netplus.PoolStats
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	resp.Body.Close()
+	assert.Nil(t, err)
+	assert.Ge(t, stats.Gets, uint64(2))
+}
+
+func TestPoolReclaimInterval(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithPoolReclaimInterval(10*time.Millisecond))
+	mux := http.NewServeMux()
+	piper.RegisterPprof(mux, "/debug/netplus/")
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var stats netplus.PoolStats
+	for i := 0; i < 500 && stats.Reclaimed == 0; i++ {
+		client, downstream := net.Pipe()
+		upstream, server := net.Pipe()
+		client.Close()
+		server.Close()
+		piper.Run(context.Background(), downstream, upstream)
+		time.Sleep(time.Millisecond)
+
+		resp, err := http.Get(srv.URL + "/debug/netplus/pool")
+		assert.Nil(t, err)
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(&stats))
+		resp.Body.Close()
+	}
+	assert.Gt(t, stats.Reclaimed, uint64(0))
+}
+
+func TestPoolReclaimIntervalWithoutClose(t *testing.T) {
+	// the reclaim rounds need no goroutine a Piper that is never closed would leak
+	verify := checkLeaks(t)
+	netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithPoolReclaimInterval(time.Millisecond))
+	verify()
+}
@@ -0,0 +1,47 @@
+package netplus
+
+import (
+	"context"
+	"io"
+)
+
+// CopyWithProgress copies src to dst like io.Copy with a pooled Piper buffer
+// and calls progress with the total bytes written so far after every write
+// ctx is checked between the reads, it cannot interrupt a blocked Read
+func CopyWithProgress(ctx context.Context, dst io.Writer, src io.Reader, progress func(n int64)) (int64, error) {
+	buf := defaultPool.Get()
+	defer defaultPool.Put(buf)
+
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		nr, er := src.Read(buf)
+		if nr > 0 {
+			nw, ew := dst.Write(buf[:nr])
+			if nw < 0 || nr < nw {
+				nw = 0
+				if ew == nil {
+					ew = errInvalidWrite
+				}
+			}
+			written += int64(nw)
+			if progress != nil {
+				progress(written)
+			}
+			if ew != nil {
+				return written, ew
+			}
+			if nr != nw {
+				return written, ErrShortWrite
+			}
+		}
+		if er == io.EOF {
+			return written, nil
+		}
+		if er != nil {
+			return written, er
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package netplus_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestCopyWithProgress(t *testing.T) {
+	var dst bytes.Buffer
+	var totals []int64
+	src := iotest.OneByteReader(strings.NewReader("abc"))
+	n, err := netplus.CopyWithProgress(context.Background(), &dst, src, func(n int64) {
+		totals = append(totals, n)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, n, int64(3))
+	assert.Equal(t, dst.String(), "abc")
+	assert.Equal(t, totals, []int64{1, 2, 3})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n, err = netplus.CopyWithProgress(ctx, &dst, strings.NewReader("abc"), nil)
+	assert.Equal(t, err, context.Canceled)
+	assert.Equal(t, n, int64(0))
+}
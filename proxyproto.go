@@ -0,0 +1,187 @@
+package netplus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrInvalidPROXYHeader is returned for a PROXY protocol header that cannot be parsed
+var ErrInvalidPROXYHeader = errors.New("invalid PROXY protocol header")
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// maxPROXYV1Len is the longest version 1 header, CRLF included
+const maxPROXYV1Len = 107
+
+// PROXYHeader is a parsed PROXY protocol header
+type PROXYHeader struct {
+	// Version is 1 for the text format and 2 for the binary one
+	Version int
+	// Local is set for version 2 LOCAL commands, such as health checks of the
+	// load balancer, whose addresses are those of the connection itself
+	Local bool
+	// SrcAddr and DstAddr are the addresses of the original connection, nil
+	// when the header does not carry them
+	SrcAddr net.Addr
+	DstAddr net.Addr
+}
+
+// SrcAddrPort returns SrcAddr as a netip.AddrPort, the zero value when there is none
+func (h *PROXYHeader) SrcAddrPort() netip.AddrPort {
+	ap, _ := addrPortFromNetAddr(h.SrcAddr)
+	return ap
+}
+
+// DstAddrPort returns DstAddr as a netip.AddrPort, the zero value when there is none
+func (h *PROXYHeader) DstAddrPort() netip.AddrPort {
+	ap, _ := addrPortFromNetAddr(h.DstAddr)
+	return ap
+}
+
+// ReadOptionalPROXYHeader reads a PROXY protocol header from conn if it starts
+// with one, waiting at most timeout for the first 6 bytes
+// with a header the returned conn reports its addresses as RemoteAddr and
+// LocalAddr, without one the header is nil and the returned conn replays the
+// bytes peeked from conn, in both cases it is the one to read from
+func ReadOptionalPROXYHeader(conn net.Conn, timeout time.Duration) (*PROXYHeader, net.Conn, error) {
+	peek := make([]byte, len(proxyV1Prefix))
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	n, err := io.ReadFull(conn, peek)
+	conn.SetReadDeadline(time.Time{})
+	peek = peek[:n]
+	switch {
+	case bytes.Equal(peek, proxyV1Prefix):
+		return readPROXYV1(conn)
+	case n == len(proxyV1Prefix) && bytes.Equal(peek, proxyV2Signature[:n]):
+		return readPROXYV2(conn, peek)
+	}
+	// a silent client or one that closed early has no header either
+	if err != nil && !isTimeoutErr(err) && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, conn, err
+	}
+	return nil, &prefixConn{Conn: conn, prefix: peek}, nil
+}
+
+// readPROXYV1 reads the rest of a text header byte by byte so nothing after it is consumed
+func readPROXYV1(conn net.Conn) (*PROXYHeader, net.Conn, error) {
+	line := append([]byte(nil), proxyV1Prefix...)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxPROXYV1Len {
+			return nil, conn, ErrInvalidPROXYHeader
+		}
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil, conn, err
+		}
+		line = append(line, b[0])
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	h := &PROXYHeader{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, conn, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, conn, ErrInvalidPROXYHeader
+	}
+	src, err1 := parsePROXYAddr(fields[2], fields[4])
+	dst, err2 := parsePROXYAddr(fields[3], fields[5])
+	if err1 != nil || err2 != nil {
+		return nil, conn, ErrInvalidPROXYHeader
+	}
+	h.SrcAddr, h.DstAddr = src, dst
+	return h, &proxyConn{Conn: conn, header: h}, nil
+}
+
+func parsePROXYAddr(host, port string) (*net.TCPAddr, error) {
+	addr, err1 := netip.ParseAddr(host)
+	p, err2 := strconv.ParseUint(port, 10, 16)
+	if err1 != nil || err2 != nil {
+		return nil, ErrInvalidPROXYHeader
+	}
+	return &net.TCPAddr{IP: ipFromAddr(addr), Port: int(p)}, nil
+}
+
+// readPROXYV2 reads the rest of a binary header whose first bytes are peek
+func readPROXYV2(conn net.Conn, peek []byte) (*PROXYHeader, net.Conn, error) {
+	// signature, version and command, family, length
+	head := make([]byte, 16)
+	copy(head, peek)
+	if _, err := io.ReadFull(conn, head[len(peek):]); err != nil {
+		return nil, conn, err
+	}
+	if !bytes.Equal(head[:12], proxyV2Signature) || head[12]>>4 != 2 {
+		return nil, conn, ErrInvalidPROXYHeader
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:16]))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, conn, err
+	}
+	h := &PROXYHeader{Version: 2}
+	switch head[12] & 0x0f {
+	case 0:
+		h.Local = true
+		return h, conn, nil
+	case 1:
+	default:
+		return nil, conn, ErrInvalidPROXYHeader
+	}
+
+	var ipLen int
+	switch head[13] >> 4 {
+	case 1:
+		ipLen = net.IPv4len
+	case 2:
+		ipLen = net.IPv6len
+	default:
+		// unspecified or unix addresses are not reported
+		return h, conn, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, conn, ErrInvalidPROXYHeader
+	}
+	srcIP := net.IP(append([]byte(nil), body[:ipLen]...))
+	dstIP := net.IP(append([]byte(nil), body[ipLen:2*ipLen]...))
+	srcPort := int(binary.BigEndian.Uint16(body[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(body[2*ipLen+2:]))
+	if head[13]&0x0f == 2 {
+		h.SrcAddr = &net.UDPAddr{IP: srcIP, Port: srcPort}
+		h.DstAddr = &net.UDPAddr{IP: dstIP, Port: dstPort}
+	} else {
+		h.SrcAddr = &net.TCPAddr{IP: srcIP, Port: srcPort}
+		h.DstAddr = &net.TCPAddr{IP: dstIP, Port: dstPort}
+	}
+	return h, &proxyConn{Conn: conn, header: h}, nil
+}
+
+// proxyConn reports the addresses of a PROXY protocol header
+type proxyConn struct {
+	net.Conn
+	header *PROXYHeader
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.header.SrcAddr
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	return c.header.DstAddr
+}
+
+// SyscallConn exposes the socket of the wrapped connection for socket options
+func (c *proxyConn) SyscallConn() (syscall.RawConn, error) {
+	if sc, ok := c.Conn.(syscall.Conn); ok {
+		return sc.SyscallConn()
+	}
+	return nil, errSockoptUnsupported
+}
@@ -0,0 +1,75 @@
+package netplus_test
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+// proxyHeaderConn returns the server side of a pipe whose client sends data
+func proxyHeaderConn(data string) net.Conn {
+	client, server := net.Pipe()
+	go func() {
+		client.Write([]byte(data))
+		client.Close()
+	}()
+	return server
+}
+
+func TestReadOptionalPROXYHeaderV1(t *testing.T) {
+	conn := proxyHeaderConn("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET /")
+	h, conn, err := netplus.ReadOptionalPROXYHeader(conn, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, h.Version, 1)
+	assert.Equal(t, h.SrcAddr.String(), "192.0.2.1:56324")
+	assert.Equal(t, h.DstAddr.String(), "198.51.100.1:443")
+	assert.Equal(t, h.SrcAddrPort(), netip.MustParseAddrPort("192.0.2.1:56324"))
+	assert.Equal(t, conn.RemoteAddr().String(), "192.0.2.1:56324")
+	rest, _ := io.ReadAll(conn)
+	assert.Equal(t, string(rest), "GET /")
+}
+
+func TestReadOptionalPROXYHeaderV2(t *testing.T) {
+	header := "\r\n\r\n\x00\r\nQUIT\n" + "\x21\x21\x00\x24" +
+		"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+		"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02" +
+		"\x1f\x90\x01\xbb"
+	h, conn, err := netplus.ReadOptionalPROXYHeader(proxyHeaderConn(header+"data"), time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, h.Version, 2)
+	assert.False(t, h.Local)
+	assert.Equal(t, h.SrcAddr.String(), "[2001:db8::1]:8080")
+	assert.Equal(t, conn.LocalAddr().String(), "[2001:db8::2]:443")
+	assert.Equal(t, h.DstAddrPort(), netip.MustParseAddrPort("[2001:db8::2]:443"))
+	rest, _ := io.ReadAll(conn)
+	assert.Equal(t, string(rest), "data")
+
+	// LOCAL commands carry no addresses
+	h, _, err = netplus.ReadOptionalPROXYHeader(proxyHeaderConn("\r\n\r\n\x00\r\nQUIT\n\x20\x00\x00\x00"), time.Second)
+	assert.Nil(t, err)
+	assert.True(t, h.Local)
+	assert.False(t, h.SrcAddrPort().IsValid())
+}
+
+func TestReadOptionalPROXYHeaderAbsent(t *testing.T) {
+	h, conn, err := netplus.ReadOptionalPROXYHeader(proxyHeaderConn("GET / HTTP/1.1\r\n"), time.Second)
+	assert.Nil(t, err)
+	assert.True(t, h == nil)
+	rest, _ := io.ReadAll(conn)
+	assert.Equal(t, string(rest), "GET / HTTP/1.1\r\n")
+
+	// a client waiting for the server to speak first
+	client, server := net.Pipe()
+	defer client.Close()
+	h, _, err = netplus.ReadOptionalPROXYHeader(server, 10*time.Millisecond)
+	assert.Nil(t, err)
+	assert.True(t, h == nil)
+
+	_, _, err = netplus.ReadOptionalPROXYHeader(proxyHeaderConn("PROXY TCP4 nonsense\r\n"), time.Second)
+	assert.Equal(t, err, netplus.ErrInvalidPROXYHeader)
+}
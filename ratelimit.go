@@ -0,0 +1,141 @@
+package netplus
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a token bucket whose rate can change between calls
+// the zero value is an empty bucket
+type tokenBucket struct {
+	mux    sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last call, b.mux must be held
+func (b *tokenBucket) refill(rate float64, burst float64) {
+	now := time.Now()
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+}
+
+// snapshot returns the tokens left, ok is false for a bucket never used
+func (b *tokenBucket) snapshot() (tokens float64, ok bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.tokens, !b.last.IsZero()
+}
+
+// restore sets the tokens left as of now
+func (b *tokenBucket) restore(tokens float64) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.tokens = tokens
+	b.last = time.Now()
+}
+
+// reset returns the bucket to its zero value, full on its next use
+func (b *tokenBucket) reset() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.tokens = 0
+	b.last = time.Time{}
+}
+
+// reserve takes n tokens from a bucket refilled at rate tokens per second,
+// holding at most burst tokens, and returns how long the caller has to wait
+// for the tokens to be paid back
+func (b *tokenBucket) reserve(n int, rate float64, burst float64) time.Duration {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.refill(rate, burst)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// allow takes one token if one is available right away
+func (b *tokenBucket) allow(rate float64, burst float64) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.refill(rate, burst)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// wait blocks until n bytes may pass at rate bytes per second or ctx is done
+// one second worth of bytes can pass in a burst
+func (b *tokenBucket) wait(ctx context.Context, n int, rate float64) error {
+	d := b.reserve(n, rate, rate)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limiterWindow is how far ahead of its rate a RateLimiter may get
+const limiterWindow = 100 * time.Millisecond
+
+// RateLimiter paces the bytes going through it, see WithRateLimiter
+type RateLimiter interface {
+	// Wait blocks until n more bytes may pass or ctx is done
+	Wait(ctx context.Context, n int) error
+}
+
+// NewTokenBucketLimiter returns a RateLimiter letting burstBPS through for up
+// to burstDuration after a quiet period and sustainedBPS after that, the burst
+// allowance comes back at the sustained rate while less than it is used
+func NewTokenBucketLimiter(sustainedBPS, burstBPS float64, burstDuration time.Duration) RateLimiter {
+	if burstBPS < sustainedBPS {
+		burstBPS = sustainedBPS
+	}
+	return &tokenBucketLimiter{
+		sustained: sustainedBPS,
+		peak:      burstBPS,
+		// the sustained bucket holds what a burst takes beyond the sustained rate
+		allowance: (burstBPS-sustainedBPS)*burstDuration.Seconds() + sustainedBPS*limiterWindow.Seconds(),
+	}
+}
+
+// tokenBucketLimiter is a two level token bucket, the sustained bucket bounds
+// the average and the peak bucket how fast its allowance is spent
+type tokenBucketLimiter struct {
+	sustained, peak float64
+	allowance       float64
+	sustainedBucket tokenBucket
+	peakBucket      tokenBucket
+}
+
+func (l *tokenBucketLimiter) Wait(ctx context.Context, n int) error {
+	d := l.sustainedBucket.reserve(n, l.sustained, l.allowance)
+	if pd := l.peakBucket.reserve(n, l.peak, l.peak*limiterWindow.Seconds()); pd > d {
+		d = pd
+	}
+	if d <= 0 {
+		return nil
+	}
+	return sleepContext(ctx, d)
+}
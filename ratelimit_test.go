@@ -0,0 +1,85 @@
+package netplus_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestTokenBucketLimiter(t *testing.T) {
+	l := netplus.NewTokenBucketLimiter(100<<10, 1<<20, 100*time.Millisecond)
+	ctx := context.Background()
+
+	// after a quiet period the burst goes through at the peak rate
+	start := time.Now()
+	for i := 0; i < 12; i++ {
+		assert.Nil(t, l.Wait(ctx, 8<<10))
+	}
+	assert.Lt(t, time.Since(start), 60*time.Millisecond)
+
+	// then the sustained rate applies
+	start = time.Now()
+	assert.Nil(t, l.Wait(ctx, 30<<10))
+	assert.Gt(t, time.Since(start), 200*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, l.Wait(ctx, 100<<10), context.DeadlineExceeded)
+}
+
+func TestWithRateLimiter(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute,
+		netplus.WithRateLimiter(netplus.DirectionUpstream, netplus.NewTokenBucketLimiter(100<<10, 100<<10, 0)))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	go io.Copy(io.Discard, server)
+	go func() {
+		client.Write(make([]byte, 30<<10))
+		client.Close()
+	}()
+	start := time.Now()
+	written, err := piper.Run(context.Background(), downstream, upstream)
+	assert.Nil(t, err)
+	assert.Equal(t, written, int64(30<<10))
+	// 10 KB go right away and the other 20 KB at 100 KB/s
+	assert.Gt(t, time.Since(start), 150*time.Millisecond)
+}
+
+func TestRateLimiterAccuracy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("takes 10 seconds")
+	}
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithReadRateLimit(10*1024, 10*1024))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	data := make([]byte, 100*1024)
+	for _, c := range []net.Conn{client, server} {
+		go c.Write(data)
+	}
+	got := make(chan int, 2)
+	for _, c := range []net.Conn{client, server} {
+		go func(c net.Conn) {
+			n, _ := io.ReadFull(c, make([]byte, len(data)))
+			got <- n
+		}(c)
+	}
+	start := time.Now()
+	done := piper.RunAsync(context.Background(), downstream, upstream)
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, <-got, len(data))
+	}
+	elapsed := time.Since(start)
+	client.Close()
+	<-done
+
+	// both directions at 10 KB/s at once
+	assert.Gt(t, elapsed, 9500*time.Millisecond)
+	assert.Lt(t, elapsed, 10500*time.Millisecond)
+}
@@ -0,0 +1,65 @@
+package netplus
+
+import (
+	"io"
+	"sync"
+)
+
+// ReaderAtAdapter streams r sequentially from offset
+// Close closes r when it is an io.Closer
+func ReaderAtAdapter(r io.ReaderAt, offset int64) io.ReadCloser {
+	return &readerAt{r: r, off: offset}
+}
+
+// WriterAtAdapter writes sequentially to w from offset
+// Close closes w when it is an io.Closer
+func WriterAtAdapter(w io.WriterAt, offset int64) io.WriteCloser {
+	return &writerAt{w: w, off: offset}
+}
+
+type readerAt struct {
+	mux sync.Mutex
+	r   io.ReaderAt
+	off int64
+}
+
+func (ra *readerAt) Read(b []byte) (int, error) {
+	ra.mux.Lock()
+	defer ra.mux.Unlock()
+	n, err := ra.r.ReadAt(b, ra.off)
+	ra.off += int64(n)
+	// ReadAt reports a short read at the end with io.EOF, keep the bytes
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (ra *readerAt) Close() error {
+	return closeIfCloser(ra.r)
+}
+
+type writerAt struct {
+	mux sync.Mutex
+	w   io.WriterAt
+	off int64
+}
+
+func (wa *writerAt) Write(b []byte) (int, error) {
+	wa.mux.Lock()
+	defer wa.mux.Unlock()
+	n, err := wa.w.WriteAt(b, wa.off)
+	wa.off += int64(n)
+	return n, err
+}
+
+func (wa *writerAt) Close() error {
+	return closeIfCloser(wa.w)
+}
+
+func closeIfCloser(v interface{}) error {
+	if c, ok := v.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package netplus_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestReaderAtAdapter(t *testing.T) {
+	r := netplus.ReaderAtAdapter(strings.NewReader("0123456789"), 4)
+	b, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "456789")
+	assert.Nil(t, r.Close())
+}
+
+func TestWriterAtAdapter(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "data"))
+	assert.Nil(t, err)
+	_, err = f.WriteString("0123456789")
+	assert.Nil(t, err)
+
+	w := netplus.WriterAtAdapter(f, 2)
+	_, err = io.Copy(w, strings.NewReader("abc"))
+	assert.Nil(t, err)
+	_, err = w.Write([]byte("de"))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	b, err := os.ReadFile(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "01abcde789")
+}
@@ -0,0 +1,53 @@
+package netplus
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// WithPoolReclaimInterval lets the garbage collector reclaim idle copy buffers
+// without waiting for the pool to be cleared, every d up to half of the buffers
+// returned by the sessions of the Piper during the previous d are dropped,
+// a random subset, instead of going back to the pool
+func WithPoolReclaimInterval(d time.Duration) Option {
+	return func(p *Piper) {
+		p.reclaimInterval = d
+	}
+}
+
+// nextReclaimRound starts a round of WithPoolReclaimInterval once d has passed
+// since the last one, the rounds move on with the returned buffers so no
+// goroutine outlives a Piper that is never closed
+func (p *Piper) nextReclaimRound() {
+	now := time.Now().UnixNano()
+	start := atomic.LoadInt64(&p.reclaimRound)
+	d := int64(p.reclaimInterval)
+	if now-start < d || !atomic.CompareAndSwapInt64(&p.reclaimRound, start, now) {
+		return
+	}
+	returned := atomic.SwapInt64(&p.returnedBufs, 0)
+	if now-start >= 2*d {
+		// nothing was returned during the previous d
+		returned = 0
+	}
+	atomic.StoreInt64(&p.reclaimBudget, returned/2)
+}
+
+// getBuf takes a copy buffer from the pool
+func (p *Piper) getBuf() []byte {
+	return p.bufPool.Get()
+}
+
+// putBuf returns buf to the pool unless this reclaim round drops it
+func (p *Piper) putBuf(buf []byte) {
+	if p.reclaimInterval > 0 {
+		p.nextReclaimRound()
+		atomic.AddInt64(&p.returnedBufs, 1)
+		if atomic.LoadInt64(&p.reclaimBudget) > 0 && rand.Intn(2) == 0 && atomic.AddInt64(&p.reclaimBudget, -1) >= 0 {
+			atomic.AddUint64(&poolReclaimed, 1)
+			return
+		}
+	}
+	p.bufPool.Put(buf)
+}
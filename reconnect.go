@@ -0,0 +1,127 @@
+package netplus
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// initialReconnectBackoff is the cap of the first wait between upstream dial attempts
+const initialReconnectBackoff = 100 * time.Millisecond
+
+// defaultMaxBackoff is used when AutoProxy.MaxBackoff is zero
+const defaultMaxBackoff = 5 * time.Second
+
+// reconnectState keeps the consecutive dial failures per upstream address
+// and the time until which accepting is paused
+type reconnectState struct {
+	mux         sync.Mutex
+	failures    map[string]int
+	pausedUntil time.Time
+}
+
+// failed records a failure for upstream and returns the full jitter wait
+// before the next attempt, a random duration in [0, cap]
+// where cap doubles from 100ms with the failures, up to max
+func (s *reconnectState) failed(upstream string, max time.Duration) time.Duration {
+	s.mux.Lock()
+	if s.failures == nil {
+		s.failures = map[string]int{}
+	}
+	s.failures[upstream]++
+	n := s.failures[upstream]
+	s.mux.Unlock()
+	ceil := ExponentialBackoff{Initial: initialReconnectBackoff, Max: max}.Backoff(n)
+	return time.Duration(rand.Int63n(int64(ceil) + 1))
+}
+
+func (s *reconnectState) succeeded(upstream string) {
+	s.mux.Lock()
+	delete(s.failures, upstream)
+	s.mux.Unlock()
+}
+
+func (s *reconnectState) pause(d time.Duration) {
+	s.mux.Lock()
+	s.pausedUntil = time.Now().Add(d)
+	s.mux.Unlock()
+}
+
+func (s *reconnectState) pauseRemaining() time.Duration {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return time.Until(s.pausedUntil)
+}
+
+// upstreamKey returns the address a dial error refers to, or "" when it is unknown
+func upstreamKey(err error) string {
+	var oe *net.OpError
+	if errors.As(err, &oe) {
+		return addrKey(oe.Addr)
+	}
+	return ""
+}
+
+// addrKey is the key of the failures of the upstream at addr, "" when it is unknown
+func addrKey(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// dialWithBackoff calls dial up to MaxRetries more times after the first
+// failure, waiting with full jitter between the attempts
+// once all attempts fail accepting is paused for MaxBackoff
+func (ap *AutoProxy) dialWithBackoff(dial DialFunc, conn net.Conn) (net.Conn, error) {
+	max := ap.MaxBackoff
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+	// the failures of this dial are reset on success even when the error did
+	// not name the address the conn ends up at
+	var failed []string
+	for attempt := 0; ; attempt++ {
+		upstream, err := dial(ap.ctx, conn)
+		if err == nil {
+			if upstream != nil {
+				ap.reconnect.succeeded(addrKey(upstream.RemoteAddr()))
+			}
+			for _, key := range failed {
+				ap.reconnect.succeeded(key)
+			}
+			return upstream, nil
+		}
+		if ap.MaxRetries <= 0 {
+			return nil, err
+		}
+		if attempt >= ap.MaxRetries {
+			ap.reconnect.pause(max)
+			return nil, err
+		}
+		key := upstreamKey(err)
+		failed = append(failed, key)
+		wait := ap.reconnect.failed(key, max)
+		if ap.Piper.debugEnabled(0) {
+			ap.Piper.debug("netplus: dialling upstream failed:", err, "retrying in", wait)
+		}
+		if err := sleepContext(ap.ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,157 @@
+package netplus
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// WithFailover lets RunWithReplay replace a failed upstream with one from dial,
+// at most max times per session
+func WithFailover(dial func(ctx context.Context) (io.ReadWriteCloser, error), max int) Option {
+	return func(p *Piper) {
+		p.failoverDial = dial
+		p.maxFailovers = max
+	}
+}
+
+// RunWithReplay is Run with a failover of the upstream, see WithFailover
+// the last replayBuf bytes written to the upstream are kept and sent again to
+// every new upstream, since the old one may have lost them
+// an upstream fails when a write to it fails or a read from it returns any
+// error including EOF, the session ends once no new upstream can be dialled
+func (p *Piper) RunWithReplay(ctx context.Context, downstream, upstream io.ReadWriteCloser, replayBuf int) (RunStats, error) {
+	fc := &failoverConn{ctx: ctx, p: p, conn: upstream, ring: replayRing{buf: make([]byte, replayBuf)}}
+	stats, err := p.run(ctx, downstream, fc)
+	stats.Failovers = fc.failovers
+	return stats, err
+}
+
+// failoverConn is the upstream of RunWithReplay, it swaps the connection
+// underneath when it fails
+type failoverConn struct {
+	ctx context.Context
+	p   *Piper
+
+	// failoverMux serializes the failovers, mux is not held while dialling
+	failoverMux sync.Mutex
+
+	mux       sync.Mutex
+	conn      io.ReadWriteCloser
+	gen       int // incremented on every failover
+	ring      replayRing
+	closed    bool
+	failovers int
+}
+
+func (c *failoverConn) Read(b []byte) (int, error) {
+	for {
+		c.mux.Lock()
+		conn, gen := c.conn, c.gen
+		c.mux.Unlock()
+		n, err := conn.Read(b)
+		if err == nil || n > 0 {
+			return n, nil
+		}
+		if !c.failover(gen) {
+			return 0, err
+		}
+	}
+}
+
+func (c *failoverConn) Write(b []byte) (int, error) {
+	// recorded first so a failover meanwhile replays b as well
+	c.mux.Lock()
+	c.ring.write(b)
+	conn, gen := c.conn, c.gen
+	c.mux.Unlock()
+	if _, err := conn.Write(b); err != nil {
+		if !c.failover(gen) {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (c *failoverConn) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.closed = true
+	return c.conn.Close()
+}
+
+// failover replaces the connection of generation gen and replays the ring to
+// the new one, it reports whether there is a working connection to go on with
+func (c *failoverConn) failover(gen int) bool {
+	c.failoverMux.Lock()
+	defer c.failoverMux.Unlock()
+	c.mux.Lock()
+	if c.gen != gen {
+		// the other direction already failed over
+		c.mux.Unlock()
+		return true
+	}
+	if c.closed || c.p.failoverDial == nil || c.failovers >= c.p.maxFailovers {
+		c.mux.Unlock()
+		return false
+	}
+	c.conn.Close()
+	c.mux.Unlock()
+
+	// the writes meanwhile fail on the old conn and still go to the ring
+	conn, err := c.p.failoverDial(c.ctx)
+	if err != nil {
+		c.p.logError("netplus: failover dial failed:", err)
+		return false
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.closed {
+		conn.Close()
+		return false
+	}
+	if replay := c.ring.bytes(); len(replay) > 0 {
+		if _, err := conn.Write(replay); err != nil {
+			c.p.logError("netplus: failover replay failed:", err)
+			conn.Close()
+			return false
+		}
+	}
+	c.conn = conn
+	c.gen++
+	c.failovers++
+	return true
+}
+
+// replayRing keeps the last len(buf) bytes written to it
+type replayRing struct {
+	buf  []byte
+	next int
+	full bool
+}
+
+func (r *replayRing) write(b []byte) {
+	size := len(r.buf)
+	if size == 0 {
+		return
+	}
+	if len(b) >= size {
+		copy(r.buf, b[len(b)-size:])
+		r.next, r.full = 0, true
+		return
+	}
+	n := copy(r.buf[r.next:], b)
+	copy(r.buf, b[n:])
+	if r.next+len(b) >= size {
+		r.full = true
+	}
+	r.next = (r.next + len(b)) % size
+}
+
+// bytes returns the kept bytes, oldest first
+func (r *replayRing) bytes() []byte {
+	if !r.full {
+		return append([]byte(nil), r.buf[:r.next]...)
+	}
+	return append(append([]byte(nil), r.buf[r.next:]...), r.buf[:r.next]...)
+}
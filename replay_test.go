@@ -0,0 +1,93 @@
+package netplus_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestRunWithReplay(t *testing.T) {
+	upstream2, server2 := net.Pipe()
+	dialled := make(chan struct{})
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithFailover(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		close(dialled)
+		return upstream2, nil
+	}, 1))
+
+	client, downstream := net.Pipe()
+	upstream1, server1 := net.Pipe()
+	done := make(chan netplus.RunStats, 1)
+	go func() {
+		stats, _ := piper.RunWithReplay(context.Background(), downstream, upstream1, 4)
+		done <- stats
+	}()
+
+	client.Write([]byte("hello"))
+	_, err := io.ReadFull(server1, make([]byte, 5))
+	assert.Nil(t, err)
+	server1.Close()
+
+	// the new upstream gets the tail of what the old one was sent, then the rest
+	b := make([]byte, 4)
+	_, err = io.ReadFull(server2, b)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "ello")
+	<-dialled
+	go client.Write([]byte("world"))
+	b = make([]byte, 5)
+	_, err = io.ReadFull(server2, b)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "world")
+
+	go server2.Write([]byte("ok"))
+	b = make([]byte, 2)
+	_, err = io.ReadFull(client, b)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "ok")
+
+	// no failover left, the second upstream closing ends the session
+	server2.Close()
+	stats := <-done
+	assert.Equal(t, stats.Failovers, 1)
+	assert.Equal(t, stats.BytesUpstream, int64(10))
+	client.Close()
+}
+
+func TestRunWithReplayCloseWhileDialling(t *testing.T) {
+	upstream2, server2 := net.Pipe()
+	dialling := make(chan struct{})
+	release := make(chan struct{})
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithFailover(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		close(dialling)
+		<-release
+		return upstream2, nil
+	}, 1))
+
+	client, downstream := net.Pipe()
+	upstream1, server1 := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		piper.RunWithReplay(context.Background(), downstream, upstream1, 4)
+		close(done)
+	}()
+	server1.Close()
+	<-dialling
+
+	// closing the session does not wait for the dial
+	client.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close waited for the failover dial")
+	}
+
+	// the upstream dialled for the closed session is closed right away
+	close(release)
+	_, err := server2.Read(make([]byte, 1))
+	assert.Equal(t, err, io.EOF)
+}
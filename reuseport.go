@@ -0,0 +1,40 @@
+package netplus
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// ListenReusePort returns n listeners bound to addr with SO_REUSEPORT, the
+// kernel spreads the incoming connections over them, so each can go to its own
+// AutoProxy for accept loops running in parallel, other processes may bind addr
+// the same way
+// a zero port in addr is picked once and shared by all n listeners
+// platforms without SO_REUSEPORT fail with an error
+func ListenReusePort(network, addr string, n int) ([]net.Listener, error) {
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var ferr error
+		if err := c.Control(func(fd uintptr) {
+			ferr = setReusePortFD(fd)
+		}); err != nil {
+			return err
+		}
+		return ferr
+	}}
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		l, err := lc.Listen(context.Background(), network, addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		if i == 0 {
+			addr = l.Addr().String()
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,9 @@
+//go:build aix || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package netplus
+
+import "syscall"
+
+func setReusePortFD(fd uintptr) error {
+	return setsockoptIntFD(fd, syscall.SOL_SOCKET, syscall.SO_REUSEPORT, 1)
+}
@@ -0,0 +1,10 @@
+package netplus
+
+import "syscall"
+
+// soReusePort is SO_REUSEPORT, which the syscall package has no name for on Linux
+const soReusePort = 0xf
+
+func setReusePortFD(fd uintptr) error {
+	return setsockoptIntFD(fd, syscall.SOL_SOCKET, soReusePort, 1)
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !aix && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package netplus
+
+func setReusePortFD(fd uintptr) error {
+	return errSockoptUnsupported
+}
@@ -0,0 +1,46 @@
+package netplus_test
+
+import (
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestListenReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT spreads connections only on linux")
+	}
+	listeners, err := netplus.ListenReusePort("tcp", "127.0.0.1:0", 4)
+	assert.Nil(t, err)
+	assert.Len(t, listeners, 4)
+	addr := listeners[0].Addr().String()
+	accepted := make(chan int, 64)
+	for i, l := range listeners {
+		defer l.Close()
+		assert.Equal(t, l.Addr().String(), addr)
+		go func(i int, l net.Listener) {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+				accepted <- i
+			}
+		}(i, l)
+	}
+
+	// the connections come from different source ports and land on more than
+	// one of the listeners
+	seen := map[int]bool{}
+	for i := 0; i < 64; i++ {
+		conn, err := net.Dial("tcp", addr)
+		assert.Nil(t, err)
+		conn.Close()
+		seen[<-accepted] = true
+	}
+	assert.Gt(t, len(seen), 1)
+}
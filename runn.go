@@ -0,0 +1,264 @@
+package netplus
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RunN pipes downstream to all upstreams at once, the reads of downstream are
+// written to the upstreams round-robin and whatever an upstream sends is written
+// back to downstream
+// an upstream failing a read or a write leaves the rotation, the session ends
+// when downstream finishes, every upstream failed, the idle Timeout passes or
+// ctx is done, all connections are then closed
+func (p *Piper) RunN(ctx context.Context, downstream io.ReadWriteCloser, upstreams []io.ReadWriteCloser) (RunStats, error) {
+	if len(upstreams) == 0 {
+		downstream.Close()
+		return RunStats{Err: ErrNoUpstream}, ErrNoUpstream
+	}
+	if p.isClosed() {
+		return RunStats{Err: ErrPiperClosed}, ErrPiperClosed
+	}
+	ctx, cancel := p.withClose(ctx)
+	defer cancel()
+	if err := p.acquireSlot(ctx); err != nil {
+		return RunStats{Err: err}, err
+	}
+	defer p.releaseSlot()
+
+	// the session shows in Sessions and ends with Close like the ones of Run
+	s := p.newSession()
+	cfg := p.Config()
+	s.stateMux.Lock()
+	if override, ok := SessionFromContext(ctx); ok {
+		cfg = cfg.merge(override)
+		s.override = &override
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 2 * time.Hour
+	}
+	atomic.AddInt64(&p.active, 1)
+	defer p.sessionEnded()
+	s.start = time.Now()
+	s.src, s.dst = remoteAddr(downstream), remoteAddr(upstreams[0])
+	s.stateMux.Unlock()
+	p.sessions.Store(s.id, s)
+	defer p.sessions.Delete(s.id)
+
+	r := &roundRobin{
+		p:          p,
+		s:          s,
+		timeout:    cfg.Timeout,
+		downstream: downstream,
+		upstreams:  upstreams,
+		alive:      int32(len(upstreams)),
+		failed:     make([]int32, len(upstreams)),
+		done:       make(chan struct{}),
+	}
+	start := time.Now()
+	go r.watch(ctx)
+
+	var wg sync.WaitGroup
+	for i := range upstreams {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r.readUpstream(i)
+		}(i)
+	}
+	err := r.readDownstream()
+	r.close()
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	} else if err == nil && atomic.LoadInt32(&r.exhausted) == 1 {
+		err = r.upstreamErr()
+	}
+	stats := RunStats{
+		BytesUpstream:   s.BytesWritten(DirectionUpstream),
+		BytesDownstream: s.BytesWritten(DirectionDownstream),
+		Duration:        time.Since(start),
+		Err:             err,
+	}
+	s.stats = stats
+	atomic.StoreInt32(&s.running, 0)
+	close(s.done)
+	return stats, err
+}
+
+// roundRobin is the state shared by the copies of a RunN session
+type roundRobin struct {
+	p          *Piper
+	s          *Session // counts the bytes and keeps the last activity
+	timeout    time.Duration
+	downstream io.ReadWriteCloser
+	upstreams  []io.ReadWriteCloser
+
+	writeMux sync.Mutex // serializes the writes to downstream
+	next     int        // the upstream the next downstream read goes to
+	alive    int32
+	failed   []int32
+	// exhausted is set when the session closes because every upstream failed
+	exhausted int32
+	errMux    sync.Mutex
+	err       error // the first upstream failure
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// watch closes the session when ctx is done or nothing was read for the idle Timeout
+func (r *roundRobin) watch(ctx context.Context) {
+	timer := r.s.clock.NewTimer(r.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ctx.Done():
+			r.close()
+			return
+		case <-timer.C():
+			left := r.timeout - r.s.clock.Now().Sub(r.s.LastActivity())
+			if left <= 0 {
+				if r.p.debugEnabled(0) {
+					r.p.debug("runn: timeout reached")
+				}
+				r.close()
+				return
+			}
+			timer.Reset(left)
+		}
+	}
+}
+
+func (r *roundRobin) close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		if err := r.downstream.Close(); err != nil && !isClosedErr(err) {
+			r.p.logError("netplus: closing downstream:", err)
+		}
+		for _, u := range r.upstreams {
+			if err := u.Close(); err != nil && !isClosedErr(err) {
+				r.p.logError("netplus: closing upstream:", err)
+			}
+		}
+	})
+}
+
+func (r *roundRobin) closed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// fail takes upstream i out of the rotation, the session closes with the last one
+func (r *roundRobin) fail(i int, err error) {
+	if !atomic.CompareAndSwapInt32(&r.failed[i], 0, 1) {
+		return
+	}
+	if err != nil && !r.closed() {
+		if r.p.debugEnabled(0) {
+			r.p.debug("runn: upstream", i, "failed:", err)
+		}
+		r.errMux.Lock()
+		if r.err == nil {
+			r.err = err
+		}
+		r.errMux.Unlock()
+	}
+	r.upstreams[i].Close()
+	if atomic.AddInt32(&r.alive, -1) == 0 && !r.closed() {
+		atomic.StoreInt32(&r.exhausted, 1)
+		r.close()
+	}
+}
+
+func (r *roundRobin) upstreamErr() error {
+	r.errMux.Lock()
+	defer r.errMux.Unlock()
+	return r.err
+}
+
+// readDownstream copies downstream to the upstreams until downstream finishes
+// or no upstream is left
+func (r *roundRobin) readDownstream() error {
+	buf := r.p.getBuf()
+	defer r.p.putBuf(buf)
+	for {
+		nr, er := r.downstream.Read(buf)
+		if nr > 0 {
+			r.s.read(DirectionUpstream, nr)
+			if !r.writeUpstream(buf[:nr]) {
+				return nil
+			}
+			r.s.wrote(DirectionUpstream, nr)
+		}
+		if er != nil {
+			if er == io.EOF || r.closed() {
+				return nil
+			}
+			return er
+		}
+	}
+}
+
+// writeUpstream writes b to the next upstream in the rotation, skipping the
+// failed ones, and reports whether one took it
+func (r *roundRobin) writeUpstream(b []byte) bool {
+	for tries := 0; tries < len(r.upstreams); tries++ {
+		i := r.next
+		r.next = (r.next + 1) % len(r.upstreams)
+		if atomic.LoadInt32(&r.failed[i]) == 1 {
+			continue
+		}
+		nw, err := r.upstreams[i].Write(b)
+		if err == nil && nw != len(b) {
+			err = ErrShortWrite
+		}
+		if err == nil {
+			return true
+		}
+		r.fail(i, err)
+	}
+	return false
+}
+
+// readUpstream copies upstream i to downstream until it fails
+func (r *roundRobin) readUpstream(i int) {
+	buf := r.p.getBuf()
+	defer r.p.putBuf(buf)
+	for {
+		nr, er := r.upstreams[i].Read(buf)
+		if nr > 0 {
+			r.s.read(DirectionDownstream, nr)
+			r.writeMux.Lock()
+			nw, ew := r.downstream.Write(buf[:nr])
+			r.writeMux.Unlock()
+			if ew == nil && nw != nr {
+				ew = ErrShortWrite
+			}
+			if ew != nil {
+				// downstream is gone, so is the session
+				r.fail(i, nil)
+				r.close()
+				return
+			}
+			r.s.wrote(DirectionDownstream, nw)
+		}
+		if er != nil {
+			if er == io.EOF {
+				er = nil
+			}
+			r.fail(i, er)
+			return
+		}
+	}
+}
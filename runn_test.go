@@ -0,0 +1,113 @@
+package netplus_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestRunN(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+
+	lonely, _ := net.Pipe()
+	_, err := piper.RunN(context.Background(), lonely, nil)
+	assert.Equal(t, err, netplus.ErrNoUpstream)
+
+	client, downstream := net.Pipe()
+	var upstreams []io.ReadWriteCloser
+	received := make([]chan string, 3)
+	for i := range received {
+		upstream, server := net.Pipe()
+		upstreams = append(upstreams, upstream)
+		received[i] = make(chan string, 1)
+		go func(c chan string) {
+			var got []byte
+			b := make([]byte, 16)
+			for {
+				n, err := server.Read(b)
+				if err != nil {
+					break
+				}
+				got = append(got, b[:n]...)
+				server.Write(bytes.ToUpper(b[:n]))
+			}
+			c <- string(got)
+		}(received[i])
+	}
+	// the second upstream is gone from the start
+	upstreams[1].Close()
+
+	go func() {
+		b := make([]byte, 1)
+		for _, c := range "abc" {
+			client.Write([]byte(string(c)))
+			io.ReadFull(client, b)
+		}
+		client.Close()
+	}()
+	stats, err := piper.RunN(context.Background(), downstream, upstreams)
+	assert.Nil(t, err)
+	assert.Equal(t, stats.BytesUpstream, int64(3))
+	assert.Equal(t, stats.BytesDownstream, int64(3))
+	assert.Equal(t, <-received[0], "ac")
+	assert.Equal(t, <-received[1], "")
+	assert.Equal(t, <-received[2], "b")
+}
+
+func TestRunNUpstreamsGone(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	client, downstream := net.Pipe()
+	defer client.Close()
+	var upstreams []io.ReadWriteCloser
+	for i := 0; i < 2; i++ {
+		upstream, server := net.Pipe()
+		server.Close()
+		upstreams = append(upstreams, upstream)
+	}
+	// the upstreams closing cleanly end the session like in Run
+	_, err := piper.RunN(context.Background(), downstream, upstreams)
+	assert.Nil(t, err)
+	_, err = client.Read(make([]byte, 1))
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestRunNSession(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	assert.Nil(t, piper.UpdateConfig(netplus.PiperConfig{Timeout: 100 * time.Millisecond}))
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := piper.RunN(context.Background(), downstream, []io.ReadWriteCloser{upstream})
+		done <- err
+	}()
+	for piper.ActiveConnections() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the session is listed like the ones of Run
+	rec := httptest.NewRecorder()
+	piper.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	var sessions []netplus.ActiveSession
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &sessions))
+	assert.Len(t, sessions, 1)
+
+	// and times out with the updated config
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("no timeout after the idle timeout")
+	}
+}
@@ -0,0 +1,140 @@
+package netplus
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// schedulerQuantum is the bytes a flow of weight 1 may send per round
+const schedulerQuantum = 16 * 1024
+
+// Scheduler shares a total bandwidth between the sessions of the Pipers using
+// it, given WithScheduler, in proportion to their weight with deficit round-robin
+// every direction of a session is a flow, sessions started by Run have weight 1
+type Scheduler struct {
+	rate float64
+
+	mux     sync.Mutex
+	active  []*flow // flows with a pending request, in round-robin order
+	current *flow   // the flow whose turn it is, it keeps it while it has credit
+	running bool
+	bucket  tokenBucket
+}
+
+// NewScheduler returns a Scheduler letting bytesPerSecond through in total
+func NewScheduler(bytesPerSecond float64) *Scheduler {
+	return &Scheduler{rate: bytesPerSecond}
+}
+
+// WithScheduler makes the sessions of the Piper share the bandwidth of sched
+func WithScheduler(sched *Scheduler) Option {
+	return func(p *Piper) {
+		p.scheduler = sched
+	}
+}
+
+// RunWeighted is Run for a session getting weight times the bandwidth of a
+// weight 1 session from the Scheduler of the Piper, without one weight is ignored
+func (p *Piper) RunWeighted(ctx context.Context, downstream, upstream io.ReadWriteCloser, weight float64) (RunStats, error) {
+	s := p.newSession()
+	if weight > 0 {
+		for _, f := range s.flows {
+			if f != nil {
+				f.weight = weight
+			}
+		}
+	}
+	return p.runSession(ctx, s, downstream, upstream)
+}
+
+// flow is one direction of a scheduled session
+type flow struct {
+	weight  float64
+	deficit float64
+	pending *grantRequest
+}
+
+type grantRequest struct {
+	n         int
+	granted   chan struct{}
+	cancelled bool
+}
+
+func newFlow() *flow {
+	return &flow{weight: 1}
+}
+
+// wait blocks until the scheduler lets n bytes of f through or ctx is done
+func (sc *Scheduler) wait(ctx context.Context, f *flow, n int) error {
+	req := &grantRequest{n: n, granted: make(chan struct{})}
+	sc.mux.Lock()
+	f.pending = req
+	if f == sc.current {
+		sc.active = append([]*flow{f}, sc.active...)
+	} else {
+		sc.active = append(sc.active, f)
+	}
+	if !sc.running {
+		sc.running = true
+		go sc.run()
+	}
+	sc.mux.Unlock()
+
+	select {
+	case <-req.granted:
+		return nil
+	case <-ctx.Done():
+		sc.mux.Lock()
+		req.cancelled = true
+		sc.mux.Unlock()
+		return ctx.Err()
+	}
+}
+
+// run serves the active flows round after round until none is left
+func (sc *Scheduler) run() {
+	for {
+		sc.mux.Lock()
+		if len(sc.active) == 0 {
+			sc.running = false
+			sc.mux.Unlock()
+			return
+		}
+		f := sc.active[0]
+		sc.active = sc.active[1:]
+		req := f.pending
+		if req == nil || req.cancelled {
+			f.pending, f.deficit = nil, 0
+			if sc.current == f {
+				sc.current = nil
+			}
+			sc.mux.Unlock()
+			continue
+		}
+		if sc.current != f {
+			// a new turn
+			sc.current = f
+			f.deficit += schedulerQuantum * f.weight
+		}
+		if float64(req.n) > f.deficit {
+			// not enough credit left, back of the line
+			sc.current = nil
+			sc.active = append(sc.active, f)
+			sc.mux.Unlock()
+			continue
+		}
+		f.deficit -= float64(req.n)
+		f.pending = nil
+		sc.mux.Unlock()
+
+		// the grant is paid for after it is sent, so the flow can ask again
+		// while it still has its turn, without a burst the flows cannot take
+		// turns outside of the rounds
+		close(req.granted)
+		if d := sc.bucket.reserve(req.n, sc.rate, schedulerQuantum); d > 0 {
+			time.Sleep(d)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package netplus_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestRunWeighted(t *testing.T) {
+	sched := netplus.NewScheduler(4 << 20)
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute, netplus.WithScheduler(sched))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	results := make(chan [2]float64, 2)
+	for _, weight := range []float64{1, 2} {
+		client, downstream := net.Pipe()
+		upstream, server := net.Pipe()
+		go io.Copy(io.Discard, server)
+		go func() {
+			b := make([]byte, 32*1024)
+			for {
+				if _, err := client.Write(b); err != nil {
+					return
+				}
+			}
+		}()
+		go func(weight float64) {
+			stats, _ := piper.RunWeighted(ctx, downstream, upstream, weight)
+			client.Close()
+			server.Close()
+			results <- [2]float64{weight, float64(stats.BytesUpstream)}
+		}(weight)
+	}
+	bytes := map[float64]float64{}
+	for i := 0; i < 2; i++ {
+		r := <-results
+		bytes[r[0]] = r[1]
+	}
+	// about 2 MB in total, split 1:2
+	assert.Lt(t, bytes[1]+bytes[2], float64(4<<20))
+	ratio := bytes[2] / bytes[1]
+	assert.Gt(t, ratio, 1.5)
+	assert.Lt(t, ratio, 2.5)
+}
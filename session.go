@@ -0,0 +1,301 @@
+package netplus
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Inspector exposes the live state of a pipe session
+type Inspector interface {
+	// BytesRead returns the bytes read so far by the copy in direction
+	// DirectionBoth sums the two copies
+	BytesRead(direction Direction) int64
+	// BytesWritten returns the bytes written so far by the copy in direction
+	BytesWritten(direction Direction) int64
+	IsRunning() bool
+	// LastActivity returns the time of the last successful read in either direction
+	LastActivity() time.Time
+}
+
+// Session is one pipe started by Start, it implements Inspector
+type Session struct {
+	id       string
+	timeline *timeline
+	// deadlines makes the copies enforce the idle timeout with read deadlines
+	deadlines   bool
+	idleTimeout time.Duration
+	// iterations is the read size histogram of WithIterationHistogram
+	iterations *histogram
+	// override is set when the Run context carries a WithSession override, it
+	// is merged over the live config on every read
+	override *PiperConfig
+	// labels are set by RunLabeled, labelPrefix is how they appear in the logs
+	labels      map[string]string
+	labelPrefix string
+	// mirror receives the upstream data with WithMirror
+	mirror *mirror
+	// start, src and dst are set once the session runs
+	start    time.Time
+	src, dst string
+
+	bytesRead    [2]int64
+	reads        [2]int64
+	bytesWritten [2]int64
+	draining     [2]int32
+	firstWrite   [2]int32
+	timerStarted int32    // set by the first read with TimerStartOnFirstByte deadlines
+	writeStart   [2]int64 // unix nanoseconds of the write in progress, 0 when none
+	writeLatency uint64   // float64 bits of the write latency average in nanoseconds
+	lastActivity int64    // unix nanoseconds
+	running      int32
+	// idleJitter extends the idle window until the first read, see WithTimeoutJitter
+	idleJitter time.Duration
+	// probing is set once WithKeepaliveOnTimeout turned the probes on and probeEnd
+	// is when the current probe window closes, in unix nanoseconds
+	probing  int32
+	probeEnd int64
+	// rates counts the bytes copied per second for SessionStats
+	rates rateRing
+	// buckets enforce the rate limits of each direction
+	buckets [2]tokenBucket
+	// resumed sessions come from ImportSession and carry on their idle window
+	resumed bool
+	// stateMux guards the fields runSession sets once the session runs for Export
+	stateMux sync.Mutex
+	// idleExtension is added to the idle window after a partial read, see
+	// WithDeadlineExtensionFactor
+	idleExtension int64
+	// flows are the directions of the session in the Scheduler of the Piper
+	flows [2]*flow
+	// adaptive replaces the idle timeout, see WithAdaptiveTimeout
+	adaptive *adaptiveTimeout
+	// clock stamps the activity the idle timer goes by, see WithClock
+	clock Clock
+	// readLimiters pace the directions with WithReadRateLimit
+	readLimiters [2]RateLimiter
+	// errs receives the non-fatal errors of the session, see RunAsyncWithErrors
+	errMux sync.Mutex
+	errs   chan error
+	// hijack stops the copies for Hijack while idleTimeoutPipe runs, hijacking
+	// is set once it does and hijackBuf holds the bytes left from downstream
+	hijack    func() (hijackedConns, error)
+	hijacking int32
+	hijackBuf []byte
+	// stopping is set while the copies are stopped with expired deadlines,
+	// by Hijack or for WithCloseFunc, so they do not extend the deadlines again,
+	// stoppingWrites once the write deadlines are expired too
+	stopping       int32
+	stoppingWrites int32
+	// stop ends the session for Piper.Close while idleTimeoutPipe runs
+	stop func()
+	// goroutines are the IDs of the copy goroutines for DebugDumpOnSignal
+	goroutines [2]int64
+
+	// downstreamTurn is held by the writes to downstream with WithFlushOnClose,
+	// flushed is set under it once the hook ran
+	downstreamTurn chan struct{}
+	flushed        bool
+
+	done  chan struct{}
+	stats RunStats
+}
+
+func (p *Piper) newSession() *Session {
+	s := &Session{
+		id:           p.nextID(),
+		lastActivity: p.clock.Now().UnixNano(),
+		running:      1,
+		done:         make(chan struct{}),
+		clock:        p.clock,
+		adaptive:     p.adaptiveTimeout,
+	}
+	if p.timelineResolution > 0 {
+		s.timeline = newTimeline(p.timelineResolution, p.timelineSize)
+	}
+	if p.flushOnClose != nil {
+		s.downstreamTurn = make(chan struct{}, 1)
+	}
+	if p.scheduler != nil {
+		s.flows = [2]*flow{newFlow(), newFlow()}
+	}
+	for dir, rate := range p.readRateLimit {
+		if rate > 0 {
+			s.readLimiters[dir] = NewTokenBucketLimiter(rate, rate, 0)
+		}
+	}
+	if p.iterationHistogram {
+		s.iterations = &histogram{}
+	}
+	return s
+}
+
+// Start runs the pipe in the background like RunAsync and returns the session
+// so its state can be inspected while it runs
+func (p *Piper) Start(ctx context.Context, downstream io.ReadWriteCloser, upstream io.ReadWriteCloser) *Session {
+	s := p.newSession()
+	go func() {
+		s.stats, _ = p.runSession(ctx, s, downstream, upstream)
+		close(s.done)
+	}()
+	return s
+}
+
+// nonFatal hands err to the error channel of the session without blocking,
+// the error is dropped when nobody keeps up with the channel
+func (s *Session) nonFatal(err error) {
+	s.errMux.Lock()
+	defer s.errMux.Unlock()
+	if s.errs == nil {
+		return
+	}
+	select {
+	case s.errs <- err:
+	default:
+	}
+}
+
+// closeErrs closes the error channel once the session is over
+func (s *Session) closeErrs() {
+	s.errMux.Lock()
+	defer s.errMux.Unlock()
+	if s.errs != nil {
+		close(s.errs)
+		s.errs = nil
+	}
+}
+
+// ID returns the connection ID of the session, as used in PipeError and events
+func (s *Session) ID() string {
+	return s.id
+}
+
+// BytesRead implements Inspector
+func (s *Session) BytesRead(direction Direction) int64 {
+	return loadDirection(&s.bytesRead, direction)
+}
+
+// BytesWritten implements Inspector
+func (s *Session) BytesWritten(direction Direction) int64 {
+	return loadDirection(&s.bytesWritten, direction)
+}
+
+// IsRunning implements Inspector
+func (s *Session) IsRunning() bool {
+	return atomic.LoadInt32(&s.running) == 1
+}
+
+// LastActivity implements Inspector
+func (s *Session) LastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.lastActivity))
+}
+
+// Done is closed once the session has finished
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Wait waits for the session to finish and returns its stats
+func (s *Session) Wait() RunStats {
+	<-s.done
+	return s.stats
+}
+
+// loadDirection reads the counter of direction, or the sum of both
+func loadDirection(c *[2]int64, direction Direction) int64 {
+	switch direction {
+	case DirectionUpstream, DirectionDownstream:
+		return atomic.LoadInt64(&c[direction])
+	case DirectionBoth:
+		return atomic.LoadInt64(&c[DirectionUpstream]) + atomic.LoadInt64(&c[DirectionDownstream])
+	}
+	return 0
+}
+
+// idleDeadline is when the session times out without further reads
+func (s *Session) idleDeadline(idle time.Duration) time.Time {
+	if s.adaptive != nil {
+		idle = s.adaptive.timeout(s.rates.recent(s.clock.Now(), adaptiveWindow))
+	}
+	deadline := s.LastActivity().Add(idle + time.Duration(atomic.LoadInt64(&s.idleExtension)))
+	if loadDirection(&s.reads, DirectionBoth) == 0 {
+		deadline = deadline.Add(s.idleJitter)
+	}
+	if end := time.Unix(0, atomic.LoadInt64(&s.probeEnd)); end.After(deadline) {
+		deadline = end
+	}
+	return deadline
+}
+
+// connDeadline is idleDeadline on the real clock the connections enforce
+// their deadlines with
+func (s *Session) connDeadline(idle time.Duration) time.Time {
+	return time.Now().Add(s.idleDeadline(idle).Sub(s.clock.Now()))
+}
+
+func (s *Session) read(dir Direction, n int) {
+	atomic.AddInt64(&s.bytesRead[dir], int64(n))
+	atomic.AddInt64(&s.reads[dir], 1)
+	if s.iterations != nil {
+		s.iterations.add(int64(n))
+	}
+	atomic.StoreInt64(&s.lastActivity, s.clock.Now().UnixNano())
+}
+
+// writeLatencyAlpha is the weight of a new sample in the write latency average
+const writeLatencyAlpha = 0.1
+
+// observeWriteLatency adds d to the exponential moving average of the write
+// latency, the first sample starts it
+func (s *Session) observeWriteLatency(d time.Duration) {
+	for {
+		old := atomic.LoadUint64(&s.writeLatency)
+		avg := float64(d)
+		if old != 0 {
+			avg = writeLatencyAlpha*float64(d) + (1-writeLatencyAlpha)*math.Float64frombits(old)
+		}
+		if atomic.CompareAndSwapUint64(&s.writeLatency, old, math.Float64bits(avg)) {
+			return
+		}
+	}
+}
+
+func (s *Session) wrote(dir Direction, n int) {
+	atomic.AddInt64(&s.bytesWritten[dir], int64(n))
+	s.timeline.add(dir, n)
+	s.rates.add(s.clock.Now(), n)
+}
+
+// SessionStats is a snapshot of the counters of an active session
+type SessionStats struct {
+	// BytesUpstream is the number of bytes copied from downstream to upstream so far
+	BytesUpstream int64
+	// BytesDownstream is the number of bytes copied from upstream to downstream so far
+	BytesDownstream int64
+	LastActivity    time.Time
+	// Labels are the labels given to RunLabeled
+	Labels map[string]string
+	// LastMinuteBPS are the bytes copied in both directions in each of the last
+	// 60 seconds, oldest first, the last one is the second in progress
+	LastMinuteBPS [60]float64
+}
+
+// SessionStats returns a snapshot of the counters of the active session id
+// it returns false once the session has finished
+func (p *Piper) SessionStats(id string) (SessionStats, bool) {
+	v, ok := p.sessions.Load(id)
+	if !ok {
+		return SessionStats{}, false
+	}
+	s := v.(*Session)
+	return SessionStats{
+		BytesUpstream:   s.BytesWritten(DirectionUpstream),
+		BytesDownstream: s.BytesWritten(DirectionDownstream),
+		LastActivity:    s.LastActivity(),
+		Labels:          s.labels,
+		LastMinuteBPS:   s.rates.perSecond(s.clock.Now()),
+	}, true
+}
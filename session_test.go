@@ -0,0 +1,72 @@
+package netplus_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestSessionInspector(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	start := time.Now()
+	var s netplus.Inspector = piper.Start(context.Background(), downstream, upstream)
+	assert.True(t, s.IsRunning())
+
+	go client.Write([]byte("hello"))
+	_, err := io.ReadFull(server, make([]byte, 5))
+	assert.Nil(t, err)
+	for s.BytesWritten(netplus.DirectionUpstream) < 5 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, s.BytesRead(netplus.DirectionUpstream), int64(5))
+	assert.Equal(t, s.BytesRead(netplus.DirectionDownstream), int64(0))
+	assert.Equal(t, s.BytesRead(netplus.DirectionBoth), int64(5))
+	assert.Equal(t, netplus.DirectionBoth.String(), "both")
+	assert.False(t, s.LastActivity().Before(start))
+	assert.True(t, s.IsRunning())
+
+	client.Close()
+	stats := s.(*netplus.Session).Wait()
+	assert.Equal(t, stats.BytesUpstream, int64(5))
+	assert.False(t, s.IsRunning())
+}
+
+func TestSessionStats(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	s := piper.Start(context.Background(), downstream, upstream)
+
+	go server.Write([]byte("hello"))
+	_, err := io.ReadFull(client, make([]byte, 5))
+	assert.Nil(t, err)
+	var stats netplus.SessionStats
+	for stats.BytesDownstream < 5 {
+		var ok bool
+		stats, ok = piper.SessionStats(s.ID())
+		assert.True(t, ok)
+	}
+	assert.Equal(t, stats.BytesUpstream, int64(0))
+	assert.False(t, stats.LastActivity.IsZero())
+	// the bytes land in the current second, or the previous one right after a tick
+	assert.Equal(t, stats.LastMinuteBPS[58]+stats.LastMinuteBPS[59], float64(5))
+	var total float64
+	for _, bps := range stats.LastMinuteBPS {
+		total += bps
+	}
+	assert.Equal(t, total, float64(5))
+
+	client.Close()
+	s.Wait()
+	_, ok := piper.SessionStats(s.ID())
+	assert.False(t, ok)
+}
@@ -0,0 +1,227 @@
+package netplus
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NewLossyConn returns conn with every write dropped with probability
+// lossProbability, a dropped write reports success but sends nothing
+// to test how protocols on top of a Piper cope with lost data
+// a nil rng uses the math/rand default source
+func NewLossyConn(conn io.ReadWriteCloser, lossProbability float64, rng *rand.Rand) io.ReadWriteCloser {
+	return &lossyConn{ReadWriteCloser: conn, p: lossProbability, rng: rng}
+}
+
+type lossyConn struct {
+	io.ReadWriteCloser
+	p float64
+
+	mux sync.Mutex // rand.Rand is not safe for concurrent use
+	rng *rand.Rand
+}
+
+func (c *lossyConn) Write(b []byte) (int, error) {
+	if c.float64() < c.p {
+		return len(b), nil
+	}
+	return c.ReadWriteCloser.Write(b)
+}
+
+func (c *lossyConn) float64() float64 {
+	if c.rng == nil {
+		return rand.Float64()
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.rng.Float64()
+}
+
+// NewThrottledConn returns conn with reads limited to readBPS and writes to
+// writeBPS bytes per second, zero leaves a direction unlimited
+// calls sleep until their bytes are paid for, one second worth of bytes can pass in a burst
+func NewThrottledConn(conn io.ReadWriteCloser, readBPS, writeBPS float64) io.ReadWriteCloser {
+	return &throttledConn{ReadWriteCloser: conn, readBPS: readBPS, writeBPS: writeBPS}
+}
+
+type throttledConn struct {
+	io.ReadWriteCloser
+	readBPS, writeBPS     float64
+	readLimit, writeLimit tokenBucket
+}
+
+func (c *throttledConn) Read(b []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(b)
+	if n > 0 && c.readBPS > 0 {
+		c.readLimit.wait(context.Background(), n, c.readBPS)
+	}
+	return n, err
+}
+
+func (c *throttledConn) Write(b []byte) (int, error) {
+	if c.writeBPS > 0 {
+		c.writeLimit.wait(context.Background(), len(b), c.writeBPS)
+	}
+	return c.ReadWriteCloser.Write(b)
+}
+
+// NewReorderingConn returns conn with every write held back for a random delay
+// of up to maxDelay before it is forwarded, so writes can arrive out of order
+// Write returns right away, a failed forward is returned by the next Write
+// Close drops the writes still held back and closes conn, ending a forward
+// blocked on it, before waiting for the forwards under way
+// a nil rng uses the math/rand default source
+func NewReorderingConn(conn io.ReadWriteCloser, maxDelay time.Duration, rng *rand.Rand) io.ReadWriteCloser {
+	return &reorderingConn{ReadWriteCloser: conn, maxDelay: maxDelay, rng: rng, timers: make(map[*time.Timer]struct{})}
+}
+
+type reorderingConn struct {
+	io.ReadWriteCloser
+	maxDelay time.Duration
+
+	mux     sync.Mutex
+	rng     *rand.Rand
+	err     error
+	timers  map[*time.Timer]struct{}
+	pending sync.WaitGroup
+	// writeMux keeps the forwarded writes from interleaving
+	writeMux sync.Mutex
+}
+
+func (c *reorderingConn) Write(b []byte) (int, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if err := c.err; err != nil {
+		return 0, err
+	}
+	var delay time.Duration
+	if c.maxDelay > 0 {
+		if c.rng == nil {
+			delay = time.Duration(rand.Int63n(int64(c.maxDelay)))
+		} else {
+			delay = time.Duration(c.rng.Int63n(int64(c.maxDelay)))
+		}
+	}
+	c.pending.Add(1)
+
+	data := append([]byte(nil), b...)
+	var t *time.Timer
+	t = time.AfterFunc(delay, func() {
+		defer c.pending.Done()
+		c.mux.Lock()
+		delete(c.timers, t)
+		c.mux.Unlock()
+		c.writeMux.Lock()
+		_, err := c.ReadWriteCloser.Write(data)
+		c.writeMux.Unlock()
+		if err != nil {
+			c.mux.Lock()
+			if c.err == nil {
+				c.err = err
+			}
+			c.mux.Unlock()
+		}
+	})
+	c.timers[t] = struct{}{}
+	return len(b), nil
+}
+
+func (c *reorderingConn) Close() error {
+	c.mux.Lock()
+	if c.err == nil {
+		c.err = io.ErrClosedPipe
+	}
+	for t := range c.timers {
+		if t.Stop() {
+			c.pending.Done()
+		}
+		delete(c.timers, t)
+	}
+	c.mux.Unlock()
+	err := c.ReadWriteCloser.Close()
+	c.pending.Wait()
+	return err
+}
+
+// NewEchoConn returns a connection reading back what was written to it, so a
+// Piper can be tested without a peer, RunOnce copies it out without another
+// goroutine once it was written and closed
+// reads block until there is data, after Close they return what is left and
+// then io.EOF, writes fail with io.ErrClosedPipe
+func NewEchoConn() io.ReadWriteCloser {
+	c := &echoConn{}
+	c.cond = sync.NewCond(&c.mux)
+	return c
+}
+
+type echoConn struct {
+	mux    sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	closed bool
+}
+
+func (c *echoConn) Read(b []byte) (int, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for len(c.buf) == 0 && !c.closed {
+		c.cond.Wait()
+	}
+	if len(c.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *echoConn) Write(b []byte) (int, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	c.buf = append(c.buf, b...)
+	c.cond.Broadcast()
+	return len(b), nil
+}
+
+func (c *echoConn) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.closed = true
+	c.cond.Broadcast()
+	return nil
+}
+
+// NewDrainConn returns a connection that has nothing to send and drops what is
+// written to it, for a side of a Piper that stops sending right away
+// reads return io.EOF, writes succeed until Close and then fail with
+// io.ErrClosedPipe
+func NewDrainConn() io.ReadWriteCloser {
+	return &drainConn{}
+}
+
+type drainConn struct {
+	closed int32
+}
+
+func (c *drainConn) Read(b []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (c *drainConn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return 0, io.ErrClosedPipe
+	}
+	return len(b), nil
+}
+
+func (c *drainConn) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return nil
+}
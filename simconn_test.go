@@ -0,0 +1,159 @@
+package netplus_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestLossyConn(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	received := make(chan int, 1)
+	go func() {
+		n, _ := io.Copy(io.Discard, b)
+		received <- int(n)
+	}()
+
+	c := netplus.NewLossyConn(a, 0.5, rand.New(rand.NewSource(1)))
+	for i := 0; i < 1000; i++ {
+		n, err := c.Write([]byte("x"))
+		assert.Nil(t, err)
+		assert.Equal(t, n, 1)
+	}
+	c.Close()
+	got := <-received
+	assert.Gt(t, got, 400)
+	assert.Lt(t, got, 600)
+
+	// nothing is lost at probability zero
+	a, b = net.Pipe()
+	defer b.Close()
+	go io.Copy(io.Discard, b)
+	c = netplus.NewLossyConn(a, 0, nil)
+	_, err := c.Write([]byte("x"))
+	assert.Nil(t, err)
+	c.Close()
+}
+
+func TestThrottledConn(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	go io.Copy(io.Discard, b)
+
+	c := netplus.NewThrottledConn(a, 0, 1000)
+	defer c.Close()
+	start := time.Now()
+	// the first 1000 bytes are the burst, the next 500 take half a second
+	for i := 0; i < 15; i++ {
+		_, err := c.Write(make([]byte, 100))
+		assert.Nil(t, err)
+	}
+	elapsed := time.Since(start)
+	assert.Ge(t, int64(elapsed), int64(400*time.Millisecond))
+	assert.Lt(t, int64(elapsed), int64(time.Second))
+}
+
+func TestReorderingConn(t *testing.T) {
+	a, b := net.Pipe()
+	received := make(chan []byte, 1)
+	go func() {
+		got := make([]byte, 50)
+		io.ReadFull(b, got)
+		received <- got
+	}()
+
+	c := netplus.NewReorderingConn(a, 20*time.Millisecond, rand.New(rand.NewSource(1)))
+	sent := make([]byte, 50)
+	for i := range sent {
+		sent[i] = byte(i)
+		_, err := c.Write(sent[i : i+1])
+		assert.Nil(t, err)
+	}
+	got := <-received
+	assert.Nil(t, c.Close())
+
+	// everything arrives, just not in the order it was written
+	assert.Len(t, got, len(sent))
+	assert.NotEqual(t, got, sent)
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+	assert.Equal(t, got, sent)
+}
+
+func TestReorderingConnClose(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := netplus.NewReorderingConn(a, time.Millisecond, nil)
+	for i := 0; i < 3; i++ {
+		_, err := c.Write([]byte("x"))
+		assert.Nil(t, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// the forward blocked on a peer that does not read does not hold up Close
+	done := make(chan struct{})
+	go func() {
+		c.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked on a pending write")
+	}
+	_, err := c.Write([]byte("x"))
+	assert.Equal(t, err, io.ErrClosedPipe)
+}
+
+func TestEchoConn(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	echo := netplus.NewEchoConn()
+	go echo.Write([]byte("hello "))
+	go echo.Write([]byte("hello "))
+
+	b := make([]byte, 12)
+	_, err := io.ReadFull(echo, b)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "hello hello ")
+
+	echo.Write([]byte("world"))
+	assert.Nil(t, echo.Close())
+	_, err = echo.Write([]byte("late"))
+	assert.Equal(t, err, io.ErrClosedPipe)
+
+	// what was written before Close is still read back
+	var out bytes.Buffer
+	written, err := piper.RunOnce(context.Background(), echo, &out)
+	assert.Nil(t, err)
+	assert.Equal(t, written, int64(5))
+	assert.Equal(t, out.String(), "world")
+}
+
+func TestDrainConn(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	drain := netplus.NewDrainConn()
+	client, downstream := net.Pipe()
+
+	// the EOF of the drain side ends the session without an error
+	go func() {
+		client.Write([]byte("dropped"))
+		client.Close()
+	}()
+	stats := <-piper.RunAsync(context.Background(), downstream, drain)
+	assert.Nil(t, stats.Err)
+	assert.Equal(t, stats.BytesDownstream, int64(0))
+
+	n, err := drain.Read(make([]byte, 1))
+	assert.Equal(t, n, 0)
+	assert.Equal(t, err, io.EOF)
+	_, err = drain.Write([]byte("late"))
+	assert.Equal(t, err, io.ErrClosedPipe)
+}
@@ -0,0 +1,100 @@
+package netplus
+
+import (
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sniReadTimeout bounds the wait for the ClientHello of a connection routed by SNI
+const sniReadTimeout = 5 * time.Second
+
+// SetSNIRoutes routes connections by the server name of their TLS ClientHello
+// keys are host names or wildcards such as *.example.com matching any subdomain,
+// the most specific match wins
+// connections without a matching name, or that are not TLS, use the DialFunc given
+// to NewAutoProxy
+// the table can be replaced at any time, active sessions are not affected
+// a nil or empty table turns SNI routing off
+func (ap *AutoProxy) SetSNIRoutes(routes map[string]DialFunc) {
+	table := make(map[string]DialFunc, len(routes))
+	for name, dial := range routes {
+		table[strings.ToLower(name)] = dial
+	}
+	ap.sniRoutes.Store(table)
+}
+
+// peekClientHello reads the ClientHello of conn when SNI routing or the
+// fingerprint hook need it and returns the connection to pass on, which
+// replays the bytes read, hello is nil for connections that are not TLS
+func (ap *AutoProxy) peekClientHello(conn net.Conn) (*clientHello, net.Conn) {
+	table, _ := ap.sniRoutes.Load().(map[string]DialFunc)
+	if len(table) == 0 && ap.fingerprintHook == nil {
+		return nil, conn
+	}
+	conn.SetReadDeadline(time.Now().Add(sniReadTimeout))
+	hello, peeked, err := readClientHello(conn)
+	conn.SetReadDeadline(time.Time{})
+	conn = &prefixConn{Conn: conn, prefix: peeked}
+	if err != nil {
+		if ap.Piper.debugEnabled(0) {
+			ap.Piper.debug("netplus: no ClientHello from", conn.RemoteAddr(), ":", err)
+		}
+		return nil, conn
+	}
+	return hello, conn
+}
+
+// sniRoute returns the dial function for a connection that sent hello
+func (ap *AutoProxy) sniRoute(hello *clientHello) DialFunc {
+	table, _ := ap.sniRoutes.Load().(map[string]DialFunc)
+	if hello == nil || len(table) == 0 {
+		return ap.dialUpstream
+	}
+	if dial := matchSNI(table, hello.serverName); dial != nil {
+		return dial
+	}
+	return ap.dialUpstream
+}
+
+// matchSNI looks name up in table, first as is then as a wildcard of each parent domain
+func matchSNI(table map[string]DialFunc, name string) DialFunc {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return nil
+	}
+	if dial, ok := table[name]; ok {
+		return dial
+	}
+	for i := strings.IndexByte(name, '.'); i >= 0; i = strings.IndexByte(name, '.') {
+		name = name[i+1:]
+		if dial, ok := table["*."+name]; ok {
+			return dial
+		}
+	}
+	return nil
+}
+
+// prefixConn is a net.Conn whose reads return prefix before reading from Conn
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// SyscallConn exposes the socket of the wrapped connection for socket options
+func (c *prefixConn) SyscallConn() (syscall.RawConn, error) {
+	if sc, ok := c.Conn.(syscall.Conn); ok {
+		return sc.SyscallConn()
+	}
+	return nil, errSockoptUnsupported
+}
@@ -0,0 +1,136 @@
+package netplus_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+// firstByteServer sends the first byte of every accepted connection to the returned channel
+func firstByteServer(t *testing.T) (net.Listener, chan byte) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	got := make(chan byte, 4)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				b := make([]byte, 1)
+				if _, err := c.Read(b); err == nil {
+					got <- b[0]
+				}
+			}()
+		}
+	}()
+	return ln, got
+}
+
+func dialTo(ln net.Listener) netplus.DialFunc {
+	return func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", ln.Addr().String())
+	}
+}
+
+func TestAutoProxySNIRoutes(t *testing.T) {
+	def, defGot := firstByteServer(t)
+	defer def.Close()
+	exact, exactGot := firstByteServer(t)
+	defer exact.Close()
+	wild, wildGot := firstByteServer(t)
+	defer wild.Close()
+
+	ap, ln, _ := startAutoProxy(t, def, func(ap *netplus.AutoProxy) {
+		ap.SetSNIRoutes(map[string]netplus.DialFunc{
+			"a.example.com": dialTo(exact),
+			"*.example.com": dialTo(wild),
+		})
+	})
+	defer ap.Stop(context.Background())
+
+	hello := func(name string) {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		assert.Nil(t, err)
+		tc := tls.Client(c, &tls.Config{ServerName: name, InsecureSkipVerify: true})
+		tc.SetDeadline(time.Now().Add(time.Second))
+		go func() {
+			tc.Handshake()
+			tc.Close()
+		}()
+	}
+	for name, got := range map[string]chan byte{
+		"a.example.com":   exactGot,
+		"b.c.example.com": wildGot,
+		"example.org":     defGot,
+	} {
+		hello(name)
+		select {
+		case b := <-got:
+			// the ClientHello is replayed to the upstream
+			assert.Equal(t, b, byte(0x16), name)
+		case <-time.After(time.Second):
+			t.Fatal("no connection routed for", name)
+		}
+	}
+}
+
+func TestAutoProxyFingerprintHook(t *testing.T) {
+	upstream, got := firstByteServer(t)
+	defer upstream.Close()
+
+	fingerprints := make(chan string, 4)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	reject := errors.New("blocked client")
+	var calls int32
+	ap := netplus.NewAutoProxy(ln, dialTo(upstream), netplus.NewPiper(&recordingLogger{}, time.Minute),
+		netplus.WithTLSFingerprintHook(func(fingerprint string, conn net.Conn) error {
+			fingerprints <- fingerprint
+			if atomic.AddInt32(&calls, 1) > 1 {
+				return reject
+			}
+			return nil
+		}))
+	go ap.Serve()
+	defer ap.Stop(context.Background())
+
+	hello := func() net.Conn {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		assert.Nil(t, err)
+		tc := tls.Client(c, &tls.Config{ServerName: "example.com", InsecureSkipVerify: true})
+		tc.SetDeadline(time.Now().Add(time.Second))
+		go tc.Handshake()
+		return c
+	}
+
+	c := hello()
+	defer c.Close()
+	first := <-fingerprints
+	assert.Len(t, first, 32)
+	assert.Equal(t, <-got, byte(0x16))
+
+	// the same client sends the same fingerprint and is dropped this time
+	c = hello()
+	defer c.Close()
+	assert.Equal(t, <-fingerprints, first)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = c.Read(make([]byte, 1))
+	assert.Equal(t, err, io.EOF)
+	select {
+	case <-got:
+		t.Fatal("rejected connection reached the upstream")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
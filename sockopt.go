@@ -0,0 +1,89 @@
+package netplus
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// errSockoptUnsupported is returned for socket options the platform does not have
+var errSockoptUnsupported = errors.New("socket option not supported on this platform")
+
+// controlFD runs fn on the file descriptor of c if it exposes one
+func controlFD(c interface{}, fn func(fd uintptr) error) error {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return errSockoptUnsupported
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	if err := raw.Control(func(fd uintptr) {
+		ferr = fn(fd)
+	}); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// shutdownConn shuts down both directions of the socket behind c,
+// which unblocks pending reads and writes even when Close would hang
+func shutdownConn(c interface{}) error {
+	return controlFD(c, shutdownFD)
+}
+
+// setNoDelay sets TCP_NODELAY on the socket behind c
+func setNoDelay(c interface{}, on bool) error {
+	if tc, ok := c.(*net.TCPConn); ok {
+		return tc.SetNoDelay(on)
+	}
+	return controlFD(c, func(fd uintptr) error {
+		return setNoDelayFD(fd, on)
+	})
+}
+
+// setCork sets TCP_CORK, or TCP_NOPUSH on BSDs, on the socket behind c
+func setCork(c interface{}, on bool) error {
+	return controlFD(c, func(fd uintptr) error {
+		return setCorkFD(fd, on)
+	})
+}
+
+// setBuffer sets the receive buffer of the socket behind c when rcv is true
+// and the send buffer otherwise
+func setBuffer(c interface{}, rcv bool, size int) error {
+	return controlFD(c, func(fd uintptr) error {
+		return setBufferFD(fd, rcv, size)
+	})
+}
+
+// setKeepalive turns TCP keepalive on for c, probing after idle and then every
+// interval until count probes went unanswered
+// interval and count are only supported on some platforms
+func setKeepalive(c interface{}, idle, interval time.Duration, count int) error {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return errSockoptUnsupported
+	}
+	if err := tc.SetKeepAlive(true); err != nil {
+		return err
+	}
+	if idle > 0 {
+		if err := tc.SetKeepAlivePeriod(idle); err != nil {
+			return err
+		}
+	}
+	return controlFD(tc, func(fd uintptr) error {
+		return setKeepaliveProbesFD(fd, interval, count)
+	})
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,19 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris && !windows
+
+package netplus
+
+func shutdownFD(fd uintptr) error {
+	return errSockoptUnsupported
+}
+
+func setsockoptIntFD(fd uintptr, level, opt, value int) error {
+	return errSockoptUnsupported
+}
+
+func setNoDelayFD(fd uintptr, on bool) error {
+	return errSockoptUnsupported
+}
+
+func setBufferFD(fd uintptr, rcv bool, size int) error {
+	return errSockoptUnsupported
+}
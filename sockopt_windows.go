@@ -0,0 +1,26 @@
+//go:build windows
+
+package netplus
+
+import "syscall"
+
+func shutdownFD(fd uintptr) error {
+	return syscall.Shutdown(syscall.Handle(fd), syscall.SHUT_RDWR)
+}
+
+func setsockoptIntFD(fd uintptr, level, opt, value int) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), level, opt, value)
+}
+
+func setNoDelayFD(fd uintptr, on bool) error {
+	return setsockoptIntFD(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, boolInt(on))
+}
+
+// setBufferFD sets SO_RCVBUF when rcv is true and SO_SNDBUF otherwise
+func setBufferFD(fd uintptr, rcv bool, size int) error {
+	opt := syscall.SO_SNDBUF
+	if rcv {
+		opt = syscall.SO_RCVBUF
+	}
+	return setsockoptIntFD(fd, syscall.SOL_SOCKET, opt, size)
+}
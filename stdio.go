@@ -0,0 +1,31 @@
+package netplus
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// RunStdio is Run with os.Stdin and os.Stdout as the downstream, for tools like
+// netcat piping a terminal or a shell pipeline to a connection
+// os.Stdin is closed once the session ends, os.Stdout is left open
+func (p *Piper) RunStdio(ctx context.Context, upstream io.ReadWriteCloser) (RunStats, error) {
+	return p.run(ctx, &stdio{in: os.Stdin, out: os.Stdout}, upstream)
+}
+
+// stdio reads one file and writes another
+type stdio struct {
+	in, out *os.File
+}
+
+func (s *stdio) Read(b []byte) (int, error) {
+	return s.in.Read(b)
+}
+
+func (s *stdio) Write(b []byte) (int, error) {
+	return s.out.Write(b)
+}
+
+func (s *stdio) Close() error {
+	return s.in.Close()
+}
@@ -0,0 +1,46 @@
+package netplus_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestRunStdio(t *testing.T) {
+	stdin, stdout := os.Stdin, os.Stdout
+	defer func() { os.Stdin, os.Stdout = stdin, stdout }()
+	inR, inW, err := os.Pipe()
+	assert.Nil(t, err)
+	outR, outW, err := os.Pipe()
+	assert.Nil(t, err)
+	defer outR.Close()
+	os.Stdin, os.Stdout = inR, outW
+
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	upstream, server := net.Pipe()
+	go func() {
+		b := make([]byte, 4)
+		io.ReadFull(server, b)
+		server.Write(append([]byte("re:"), b...))
+		server.Close()
+	}()
+	inW.Write([]byte("ping"))
+	stats, err := piper.RunStdio(context.Background(), upstream)
+	assert.Nil(t, err)
+	assert.Equal(t, stats.BytesUpstream, int64(4))
+	assert.Equal(t, stats.BytesDownstream, int64(7))
+	inW.Close()
+
+	// stdout stays open after the session
+	outW.Write([]byte("\n"))
+	outW.Close()
+	b, err := io.ReadAll(outR)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), "re:ping\n")
+}
@@ -0,0 +1,50 @@
+package netplus
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd
+const listenFDsStart = 3
+
+// ListenSystemd returns the listeners systemd passed to the process with socket
+// activation, the ones whose FileDescriptorName is name or all of them for an
+// empty name, so a new process can take over the sockets of an old one and hand
+// them to AutoProxy.AddListener without refusing connections meanwhile
+// it returns no listeners when the process was not started with any
+func ListenSystemd(name string) ([]net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("netplus: invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	var listeners []net.Listener
+	for i := 0; i < n; i++ {
+		fdName := ""
+		if i < len(names) {
+			fdName = names[i]
+		}
+		if name != "" && fdName != name {
+			continue
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), fdName)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("netplus: systemd socket %d %q: %w", listenFDsStart+i, fdName, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
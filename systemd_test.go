@@ -0,0 +1,66 @@
+package netplus_test
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestListenSystemd(t *testing.T) {
+	if os.Getenv("NETPLUS_SYSTEMD_CHILD") == "1" {
+		// systemd sets the pid after forking, the child has to do it here
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		listeners, err := netplus.ListenSystemd("web")
+		if err != nil || len(listeners) != 1 {
+			os.Exit(1)
+		}
+		conn, err := listeners[0].Accept()
+		if err != nil {
+			os.Exit(1)
+		}
+		conn.Write([]byte("hello\n"))
+		conn.Close()
+		os.Exit(0)
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("no socket activation on windows")
+	}
+
+	listeners, err := netplus.ListenSystemd("")
+	assert.Nil(t, err)
+	assert.Len(t, listeners, 0)
+
+	web, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer web.Close()
+	admin, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer admin.Close()
+	adminFile, err := admin.(*net.TCPListener).File()
+	assert.Nil(t, err)
+	webFile, err := web.(*net.TCPListener).File()
+	assert.Nil(t, err)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestListenSystemd$")
+	cmd.Env = append(os.Environ(), "NETPLUS_SYSTEMD_CHILD=1", "LISTEN_FDS=2", "LISTEN_FDNAMES=admin:web")
+	cmd.ExtraFiles = []*os.File{adminFile, webFile}
+	assert.Nil(t, cmd.Start())
+	adminFile.Close()
+	webFile.Close()
+
+	// the child accepts on the socket named web
+	conn, err := net.Dial("tcp", web.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, line, "hello\n")
+	assert.Nil(t, cmd.Wait())
+}
@@ -0,0 +1,167 @@
+package netplus
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// connToggle is an on/off socket option for each side of a pipe
+type connToggle struct {
+	set        bool
+	upstream   bool
+	downstream bool
+}
+
+// WithTCPNoDelay sets TCP_NODELAY on the upstream and downstream connections of RunConn
+func WithTCPNoDelay(upstream, downstream bool) Option {
+	return func(p *Piper) {
+		p.noDelay = connToggle{true, upstream, downstream}
+	}
+}
+
+// WithTCPCork sets TCP_CORK, TCP_NOPUSH on BSDs, on the upstream and downstream
+// connections of RunConn, a corked connection is uncorked before it is closed
+// so the last partial segment is flushed
+func WithTCPCork(upstream, downstream bool) Option {
+	return func(p *Piper) {
+		p.cork = connToggle{true, upstream, downstream}
+	}
+}
+
+// WithSocketBuffers sets SO_RCVBUF and SO_SNDBUF on both connections of RunConn
+// a size of zero leaves that buffer alone, the kernel may round or cap the sizes
+func WithSocketBuffers(rcvBuf, sndBuf int) Option {
+	return func(p *Piper) {
+		p.rcvBuf = rcvBuf
+		p.sndBuf = sndBuf
+	}
+}
+
+// WithTCPKeepalive turns TCP keepalive on for both connections of RunConn so
+// half-open connections are noticed by the OS before the idle timeout fires
+// probes start after idle without traffic and are repeated every interval, count
+// unanswered probes drop the connection, interval and count are not supported
+// everywhere and zero values keep the system defaults
+func WithTCPKeepalive(idle, interval time.Duration, count int) Option {
+	return func(p *Piper) {
+		p.keepalive = &tcpKeepalive{idle, interval, count}
+	}
+}
+
+type tcpKeepalive struct {
+	idle     time.Duration
+	interval time.Duration
+	count    int
+}
+
+// WithKeepaliveOnTimeout turns TCP keepalive on once a session reaches its idle
+// timeout instead of closing it, probing every probeInterval, and gives the
+// probes probeCount+1 intervals, the first probe waits one, before the next check
+// the session ends when the OS drops a connection after probeCount unanswered
+// probes, connections that keep answering stay open however quiet they are
+// sessions whose connections are not TCP or do not support the probe settings close as usual
+func WithKeepaliveOnTimeout(probeInterval time.Duration, probeCount int) Option {
+	return func(p *Piper) {
+		p.timeoutKeepalive = &tcpKeepalive{probeInterval, probeInterval, probeCount}
+	}
+}
+
+// probeIdle reports whether the idle session s stays open for another probe
+// window of WithKeepaliveOnTimeout, the first call turns the probes on for conns
+func (p *Piper) probeIdle(s *Session, conns ...interface{}) bool {
+	ka := p.timeoutKeepalive
+	if ka == nil {
+		return false
+	}
+	if atomic.CompareAndSwapInt32(&s.probing, 0, 1) {
+		for _, c := range conns {
+			if err := setKeepalive(c, ka.idle, ka.interval, ka.count); err != nil {
+				p.logWarn(s.logArgs("netplus: probing idle connection failed:", err)...)
+				s.nonFatal(err)
+				atomic.StoreInt32(&s.probing, 2)
+				return false
+			}
+		}
+		if p.debugEnabled(0) {
+			p.debug(s.logArgs("idletimeoutpipe: timeout reached, probing")...)
+		}
+	} else if atomic.LoadInt32(&s.probing) != 1 {
+		return false
+	}
+	window := time.Duration(ka.count+1) * ka.interval
+	atomic.StoreInt64(&s.probeEnd, s.clock.Now().Add(window).UnixNano())
+	return true
+}
+
+// RunConn is Run for network connections, it applies the socket options of the
+// Piper to both connections before piping them
+// options a connection does not support are logged as warnings
+// the idle timeout is enforced with read and write deadlines instead of a timer
+// goroutine, which saves one goroutine per session when ctx cannot be cancelled
+// connections whose deadlines fail, like WrapAsConn over a plain stream, keep the timer
+func (p *Piper) RunConn(ctx context.Context, downstream, upstream net.Conn) (written int64, err error) {
+	downstream = p.applySockopts(downstream, "downstream", p.noDelay.downstream, p.cork.downstream)
+	upstream = p.applySockopts(upstream, "upstream", p.noDelay.upstream, p.cork.upstream)
+	s := p.newSession()
+	s.deadlines = hasDeadlines(downstream) && hasDeadlines(upstream)
+	stats, err := p.runSession(ctx, s, downstream, upstream)
+	return stats.BytesUpstream + stats.BytesDownstream, err
+}
+
+// hasDeadlines reports whether c accepts read and write deadlines, clearing them
+func hasDeadlines(c net.Conn) bool {
+	return c.SetReadDeadline(time.Time{}) == nil && c.SetWriteDeadline(time.Time{}) == nil
+}
+
+// applySockopts sets the socket options on c and returns the connection to pipe
+func (p *Piper) applySockopts(c net.Conn, side string, noDelay, cork bool) net.Conn {
+	if p.noDelay.set {
+		if err := setNoDelay(c, noDelay); err != nil {
+			p.logWarn("netplus: setting TCP_NODELAY on", side, "failed:", err)
+		}
+	}
+	if p.rcvBuf > 0 {
+		if err := setBuffer(c, true, p.rcvBuf); err != nil {
+			p.logWarn("netplus: setting SO_RCVBUF on", side, "failed:", err)
+		}
+	}
+	if p.sndBuf > 0 {
+		if err := setBuffer(c, false, p.sndBuf); err != nil {
+			p.logWarn("netplus: setting SO_SNDBUF on", side, "failed:", err)
+		}
+	}
+	if ka := p.keepalive; ka != nil {
+		if err := setKeepalive(c, ka.idle, ka.interval, ka.count); err != nil {
+			p.logWarn("netplus: setting TCP keepalive on", side, "failed:", err)
+		}
+	}
+	if p.cork.set {
+		if err := setCork(c, cork); err != nil {
+			p.logWarn("netplus: setting TCP_CORK on", side, "failed:", err)
+		} else if cork {
+			return &uncorkConn{c}
+		}
+	}
+	return c
+}
+
+// uncorkConn clears TCP_CORK before closing the connection
+type uncorkConn struct {
+	net.Conn
+}
+
+func (c *uncorkConn) Close() error {
+	setCork(c.Conn, false)
+	return c.Conn.Close()
+}
+
+// SyscallConn exposes the socket of the wrapped connection for socket options
+func (c *uncorkConn) SyscallConn() (syscall.RawConn, error) {
+	if sc, ok := c.Conn.(syscall.Conn); ok {
+		return sc.SyscallConn()
+	}
+	return nil, errSockoptUnsupported
+}
@@ -0,0 +1,279 @@
+package netplus_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	c, accepted, err := dialPair()
+	assert.Nil(t, err)
+	return c, accepted
+}
+
+// dialPair returns both ends of a new loopback TCP connection
+func dialPair() (net.Conn, net.Conn, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, <-accepted, nil
+}
+
+func TestRunConnSockopts(t *testing.T) {
+	logger := &recordingLogger{}
+	piper := netplus.NewPiper(logger, time.Minute, netplus.WithTCPNoDelay(true, false), netplus.WithTCPCork(true, true))
+
+	client, downstream := tcpPair(t)
+	upstream, server := tcpPair(t)
+	done := make(chan error, 1)
+	go func() {
+		_, err := piper.RunConn(context.Background(), downstream, upstream)
+		done <- err
+	}()
+
+	_, err := client.Write([]byte("ping"))
+	assert.Nil(t, err)
+	b := make([]byte, 4)
+	_, err = io.ReadFull(server, b)
+	assert.Nil(t, err)
+	client.Close()
+	assert.Nil(t, <-done)
+	// corked data still reaches the peer once the connection closes
+	_, err = server.Read(b)
+	assert.Equal(t, err, io.EOF)
+	assert.Len(t, logger.Lines(), 0)
+}
+
+func TestRunConnSockoptsUnsupported(t *testing.T) {
+	logger := &recordingLogger{}
+	piper := netplus.NewPiper(logger, time.Minute, netplus.WithTCPNoDelay(true, true))
+
+	client, downstream := net.Pipe()
+	upstream, _ := net.Pipe()
+	client.Close()
+	piper.RunConn(context.Background(), downstream, upstream)
+	assert.Len(t, logger.Lines(), 2)
+	assert.Contains(t, logger.Lines()[0], "TCP_NODELAY")
+}
+
+func TestRunConnSocketBuffers(t *testing.T) {
+	logger := &recordingLogger{}
+	piper := netplus.NewPiper(logger, time.Minute, netplus.WithSocketBuffers(256*1024, 256*1024))
+
+	client, downstream := tcpPair(t)
+	upstream, server := tcpPair(t)
+	defer server.Close()
+	go func() {
+		client.Write([]byte("ping"))
+		client.Close()
+	}()
+	_, err := piper.RunConn(context.Background(), downstream, upstream)
+	assert.Nil(t, err)
+	assert.Len(t, logger.Lines(), 0)
+
+	// connections without a socket only get warnings
+	downstream, _ = net.Pipe()
+	upstream, _ = net.Pipe()
+	downstream.Close()
+	piper.RunConn(context.Background(), downstream, upstream)
+	assert.Len(t, logger.Lines(), 4)
+	assert.Contains(t, logger.Lines()[0], "SO_RCVBUF")
+}
+
+func TestRunConnKeepalive(t *testing.T) {
+	logger := &recordingLogger{}
+	piper := netplus.NewPiper(logger, time.Minute, netplus.WithTCPKeepalive(time.Minute, 0, 0))
+
+	client, downstream := tcpPair(t)
+	upstream, server := tcpPair(t)
+	defer server.Close()
+	go func() {
+		client.Write([]byte("ping"))
+		client.Close()
+	}()
+	_, err := piper.RunConn(context.Background(), downstream, upstream)
+	assert.Nil(t, err)
+	assert.Len(t, logger.Lines(), 0)
+}
+
+func TestKeepaliveOnTimeout(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("keepalive probe settings are only checked on linux")
+	}
+	piper := netplus.NewPiper(&recordingLogger{}, 50*time.Millisecond, netplus.WithKeepaliveOnTimeout(time.Second, 2))
+	runs := []func(ctx context.Context, d, u net.Conn) error{
+		func(ctx context.Context, d, u net.Conn) error {
+			_, err := piper.Run(ctx, d, u)
+			return err
+		},
+		func(ctx context.Context, d, u net.Conn) error {
+			_, err := piper.RunConn(ctx, d, u)
+			return err
+		},
+	}
+	for _, run := range runs {
+		client, downstream := tcpPair(t)
+		upstream, server := tcpPair(t)
+		done := make(chan error, 1)
+		go func() {
+			done <- run(context.Background(), downstream, upstream)
+		}()
+		// the peers answer the probes, the quiet session outlives its idle timeout
+		time.Sleep(200 * time.Millisecond)
+		assert.Equal(t, piper.ActiveConnections(), int64(1))
+		client.Close()
+		assert.Nil(t, <-done)
+		server.Close()
+	}
+
+	// without TCP there is nothing to probe, the session times out as usual
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	start := time.Now()
+	piper.Run(context.Background(), downstream, upstream)
+	assert.Lt(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestRunConnIdleTimeout(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, 100*time.Millisecond)
+
+	client, downstream := net.Pipe()
+	upstream, server := net.Pipe()
+	go io.Copy(io.Discard, server)
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		_, err := piper.RunConn(context.Background(), downstream, upstream)
+		done <- err
+	}()
+
+	// traffic in one direction keeps the whole session alive
+	for i := 0; i < 5; i++ {
+		time.Sleep(50 * time.Millisecond)
+		_, err := client.Write([]byte("x"))
+		assert.Nil(t, err)
+	}
+	err := <-done
+	assert.Ge(t, int64(time.Since(start)), int64(350*time.Millisecond))
+	var ne net.Error
+	assert.True(t, errors.As(err, &ne) && ne.Timeout(), err)
+}
+
+// noDeadlineConn is a net.Conn whose deadlines are not supported
+type noDeadlineConn struct {
+	net.Conn
+}
+
+func (c noDeadlineConn) SetReadDeadline(time.Time) error  { return os.ErrNoDeadline }
+func (c noDeadlineConn) SetWriteDeadline(time.Time) error { return os.ErrNoDeadline }
+
+func TestRunConnNoDeadlines(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, 100*time.Millisecond)
+
+	_, downstream := net.Pipe()
+	upstream, _ := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		piper.RunConn(context.Background(), noDeadlineConn{downstream}, noDeadlineConn{upstream})
+		close(done)
+	}()
+
+	// the idle timer still ends the session
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle timeout without deadlines not enforced")
+	}
+}
+
+func TestRunConnContext(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+
+	_, downstream := net.Pipe()
+	upstream, _ := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	piper.RunConn(ctx, downstream, upstream)
+	assert.Lt(t, int64(time.Since(start)), int64(time.Second))
+}
+
+// benchmarkIdleTimeout pipes 10K concurrent in-memory sessions with run and
+// sends a round trip over every one of them
+func benchmarkIdleTimeout(b *testing.B, run func(p *netplus.Piper, d, u net.Conn)) {
+	const sessions = 10000
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		clients := make([]net.Conn, sessions)
+		done := make(chan struct{}, sessions)
+		for j := range clients {
+			client, downstream := net.Pipe()
+			upstream, server := net.Pipe()
+			go io.Copy(server, server)
+			clients[j] = client
+			go func() {
+				run(piper, downstream, upstream)
+				done <- struct{}{}
+			}()
+		}
+		for piper.ActiveConnections() < sessions {
+			time.Sleep(time.Millisecond)
+		}
+		b.ReportMetric(float64(runtime.NumGoroutine())/sessions, "goroutines/session")
+		var wg sync.WaitGroup
+		for _, c := range clients {
+			wg.Add(1)
+			go func(c net.Conn) {
+				defer wg.Done()
+				buf := []byte("x")
+				if _, err := c.Write(buf); err != nil {
+					b.Error(err)
+				} else if _, err := io.ReadFull(c, buf); err != nil {
+					b.Error(err)
+				}
+				c.Close()
+			}(c)
+		}
+		wg.Wait()
+		for range clients {
+			<-done
+		}
+	}
+}
+
+func BenchmarkIdleTimeoutTimer(b *testing.B) {
+	benchmarkIdleTimeout(b, func(p *netplus.Piper, d, u net.Conn) {
+		p.Run(context.Background(), d, u)
+	})
+}
+
+func BenchmarkIdleTimeoutDeadline(b *testing.B) {
+	benchmarkIdleTimeout(b, func(p *netplus.Piper, d, u net.Conn) {
+		p.RunConn(context.Background(), d, u)
+	})
+}
@@ -0,0 +1,40 @@
+package netplus
+
+import (
+	"io"
+	"sync"
+)
+
+// MultiTee returns a writer that writes to every w in parallel, each in its own
+// goroutine, and waits for all of them, so a slow sink does not delay the others
+// the error is the one of the first writer in the list that failed
+func MultiTee(w ...io.Writer) io.Writer {
+	return &multiTee{writers: append([]io.Writer(nil), w...)}
+}
+
+type multiTee struct {
+	writers []io.Writer
+}
+
+func (t *multiTee) Write(b []byte) (int, error) {
+	errs := make([]error, len(t.writers))
+	var wg sync.WaitGroup
+	for i, w := range t.writers {
+		wg.Add(1)
+		go func(i int, w io.Writer) {
+			defer wg.Done()
+			n, err := w.Write(b)
+			if err == nil && n != len(b) {
+				err = io.ErrShortWrite
+			}
+			errs[i] = err
+		}(i, w)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
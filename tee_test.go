@@ -0,0 +1,48 @@
+package netplus_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+// slowWriter takes d for every write
+type slowWriter struct {
+	d time.Duration
+}
+
+func (w slowWriter) Write(b []byte) (int, error) {
+	time.Sleep(w.d)
+	return len(b), nil
+}
+
+type errWriter struct {
+	err error
+}
+
+func (w errWriter) Write(b []byte) (int, error) {
+	return 0, w.err
+}
+
+func TestMultiTee(t *testing.T) {
+	var a, b bytes.Buffer
+	start := time.Now()
+	tee := netplus.MultiTee(&a, &b, slowWriter{50 * time.Millisecond}, slowWriter{50 * time.Millisecond})
+	n, err := io.WriteString(tee, "hello")
+	assert.Nil(t, err)
+	assert.Equal(t, n, 5)
+	assert.Equal(t, a.String(), "hello")
+	assert.Equal(t, b.String(), "hello")
+	// the slow writers ran in parallel
+	assert.Lt(t, int64(time.Since(start)), int64(90*time.Millisecond))
+
+	first, second := errors.New("first"), errors.New("second")
+	tee = netplus.MultiTee(&a, errWriter{first}, errWriter{second})
+	_, err = tee.Write([]byte("x"))
+	assert.Equal(t, err, first)
+}
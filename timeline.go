@@ -0,0 +1,161 @@
+package netplus
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TimelinePoint holds the bytes copied in each direction during one timeline interval
+type TimelinePoint struct {
+	// Timestamp is the end of the interval
+	Timestamp time.Time
+	UpBytes   int64
+	DownBytes int64
+}
+
+// defaultTimelineSize is the number of points kept when WithTimelineSize is not set
+const defaultTimelineSize = 1024
+
+// WithTimeline records the bytes copied every resolution in RunStats.Timeline
+// intervals without traffic are recorded as well, only the last points are
+// kept, see WithTimelineSize
+func WithTimeline(resolution time.Duration) Option {
+	return func(p *Piper) {
+		p.timelineResolution = resolution
+	}
+}
+
+// WithTimelineSize keeps the last n points of every timeline, older ones are dropped
+func WithTimelineSize(n int) Option {
+	return func(p *Piper) {
+		p.timelineSize = n
+	}
+}
+
+// timeline samples the byte counters of one session into a ring of points
+type timeline struct {
+	up, down int64
+	points   []TimelinePoint
+	next     int
+	full     bool
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newTimeline(resolution time.Duration, size int) *timeline {
+	if size <= 0 {
+		size = defaultTimelineSize
+	}
+	tl := &timeline{points: make([]TimelinePoint, size), stop: make(chan struct{})}
+	tl.wg.Add(1)
+	go func() {
+		defer tl.wg.Done()
+		ticker := time.NewTicker(resolution)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-tl.stop:
+				tl.sample(time.Now())
+				return
+			case now := <-ticker.C:
+				tl.sample(now)
+			}
+		}
+	}()
+	return tl
+}
+
+// add counts n bytes copied in dir, tl may be nil
+func (tl *timeline) add(dir Direction, n int) {
+	if tl == nil {
+		return
+	}
+	if dir == DirectionUpstream {
+		atomic.AddInt64(&tl.up, int64(n))
+	} else {
+		atomic.AddInt64(&tl.down, int64(n))
+	}
+}
+
+func (tl *timeline) sample(now time.Time) {
+	tl.points[tl.next] = TimelinePoint{
+		Timestamp: now,
+		UpBytes:   atomic.SwapInt64(&tl.up, 0),
+		DownBytes: atomic.SwapInt64(&tl.down, 0),
+	}
+	tl.next++
+	if tl.next == len(tl.points) {
+		tl.next, tl.full = 0, true
+	}
+}
+
+// finish stops the sampling and returns the kept points, oldest first
+func (tl *timeline) finish() []TimelinePoint {
+	close(tl.stop)
+	tl.wg.Wait()
+	if !tl.full {
+		return tl.points[:tl.next]
+	}
+	return append(tl.points[tl.next:], tl.points[:tl.next]...)
+}
+
+// rateRing counts the bytes copied in each of the last 60 seconds without locks,
+// every slot packs the second it belongs to in its top bits and the bytes below
+type rateRing struct {
+	slots [60]uint64
+}
+
+const (
+	rateSecondBits = 20
+	rateByteBits   = 64 - rateSecondBits
+	rateByteMask   = 1<<rateByteBits - 1
+	rateSecondMask = 1<<rateSecondBits - 1
+)
+
+func (r *rateRing) add(now time.Time, n int) {
+	sec := uint64(now.Unix())
+	slot := &r.slots[sec%60]
+	tag := sec & rateSecondMask << rateByteBits
+	for {
+		old := atomic.LoadUint64(slot)
+		v := tag | uint64(n)&rateByteMask
+		if old&^rateByteMask == tag {
+			// the same second, a new one overwrites the oldest sample
+			v = old + uint64(n)&rateByteMask
+		}
+		if atomic.CompareAndSwapUint64(slot, old, v) {
+			return
+		}
+	}
+}
+
+// perSecond returns the bytes of the last 60 seconds, oldest first, the last
+// one is the second in progress
+func (r *rateRing) perSecond(now time.Time) [60]float64 {
+	var bps [60]float64
+	sec := uint64(now.Unix())
+	for i := range bps {
+		s := sec - 59 + uint64(i)
+		v := atomic.LoadUint64(&r.slots[s%60])
+		if v>>rateByteBits == s&rateSecondMask {
+			bps[i] = float64(v & rateByteMask)
+		}
+	}
+	return bps
+}
+
+// recent returns the average bytes per second of the last n seconds, the one in
+// progress included
+func (r *rateRing) recent(now time.Time, n int) float64 {
+	var total uint64
+	sec := uint64(now.Unix())
+	for i := 0; i < n; i++ {
+		s := sec - uint64(i)
+		v := atomic.LoadUint64(&r.slots[s%60])
+		if v>>rateByteBits == s&rateSecondMask {
+			total += v & rateByteMask
+		}
+	}
+	return float64(total) / float64(n)
+}
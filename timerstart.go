@@ -0,0 +1,38 @@
+package netplus
+
+import "sync/atomic"
+
+// TimerStartMode selects when the idle timeout of a session starts counting,
+// see WithTimerStartMode
+type TimerStartMode int
+
+const (
+	// TimerStartOnRun starts the idle timeout when Run is called
+	TimerStartOnRun TimerStartMode = iota
+	// TimerStartOnFirstByte starts the idle timeout once the first byte has
+	// been read from either side, MaxConnectTime still bounds the wait for it
+	TimerStartOnFirstByte
+)
+
+func (m TimerStartMode) String() string {
+	switch m {
+	case TimerStartOnRun:
+		return "on run"
+	case TimerStartOnFirstByte:
+		return "on first byte"
+	}
+	return "unknown timer start mode"
+}
+
+// WithTimerStartMode sets when the idle timeout of a session starts, so a peer
+// slow to send its first byte still gets the whole Timeout for the transfer
+func WithTimerStartMode(mode TimerStartMode) Option {
+	return func(p *Piper) {
+		p.timerStartMode = mode
+	}
+}
+
+// waitsFirstByte reports whether the idle timeout of s has not started yet
+func (p *Piper) waitsFirstByte(s *Session) bool {
+	return p.timerStartMode == TimerStartOnFirstByte && !s.resumed && atomic.LoadInt64(&s.reads[DirectionUpstream])+atomic.LoadInt64(&s.reads[DirectionDownstream]) == 0
+}
@@ -0,0 +1,47 @@
+package netplus_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestTimerStartOnFirstByte(t *testing.T) {
+	runs := map[string]func(p *netplus.Piper, d, u net.Conn){
+		"Run":     func(p *netplus.Piper, d, u net.Conn) { p.Run(context.Background(), d, u) },
+		"RunConn": func(p *netplus.Piper, d, u net.Conn) { p.RunConn(context.Background(), d, u) },
+	}
+	for name, run := range runs {
+		piper := netplus.NewPiper(&recordingLogger{}, 100*time.Millisecond,
+			netplus.WithTimerStartMode(netplus.TimerStartOnFirstByte))
+		client, downstream := tcpPair(t)
+		upstream, server := tcpPair(t)
+		done := make(chan struct{})
+		start := time.Now()
+		go func() {
+			run(piper, downstream, upstream)
+			close(done)
+		}()
+
+		// longer than the idle timeout before the first byte
+		time.Sleep(200 * time.Millisecond)
+		client.Write([]byte("late"))
+		b := make([]byte, 4)
+		_, err := io.ReadFull(server, b)
+		assert.Nil(t, err, name)
+		assert.Equal(t, string(b), "late", name)
+
+		// the idle timeout applies from then on
+		<-done
+		elapsed := time.Since(start)
+		assert.Gt(t, elapsed, 280*time.Millisecond, name)
+		assert.Lt(t, elapsed, time.Second, name)
+		client.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,61 @@
+package netplus
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// UpgradeHandler returns an http.Handler piping HTTP/1.1 upgrade requests, of
+// any protocol in their Upgrade header, to the upstream dialled by dialFn, it
+// answers 101 Switching Protocols once the upstream is connected and then
+// takes the connection over from the server and runs it with p
+// requests that are no upgrade get 426 Upgrade Required
+func UpgradeHandler(p *Piper, dialFn func(r *http.Request) (net.Conn, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protocol := r.Header.Get("Upgrade")
+		if protocol == "" || !hasToken(r.Header["Connection"], "upgrade") {
+			w.Header().Set("Connection", "Upgrade")
+			http.Error(w, http.StatusText(http.StatusUpgradeRequired), http.StatusUpgradeRequired)
+			return
+		}
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "connection cannot be upgraded", http.StatusInternalServerError)
+			return
+		}
+		upstream, err := dialFn(r)
+		if err != nil {
+			p.logError("netplus: dialling upstream for upgrade to", protocol, "failed:", err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			p.logError("netplus: hijacking connection for upgrade failed:", err)
+			upstream.Close()
+			return
+		}
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: " + protocol + "\r\n\r\n")
+		if err := rw.Flush(); err != nil {
+			conn.Close()
+			upstream.Close()
+			return
+		}
+		// the client may have sent more than the request already
+		prefix, _ := rw.Reader.Peek(rw.Reader.Buffered())
+		p.Run(r.Context(), &prefixConn{Conn: conn, prefix: prefix}, upstream)
+	})
+}
+
+// hasToken reports whether the comma separated header values hold token
+func hasToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package netplus_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestUpgradeHandler(t *testing.T) {
+	piper := netplus.NewPiper(nil, time.Minute)
+	srv := httptest.NewServer(netplus.UpgradeHandler(piper, func(r *http.Request) (net.Conn, error) {
+		upstream, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			io.Copy(server, server)
+		}()
+		return upstream, nil
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	// the first bytes of the protocol come right with the request
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\nConnection: keep-alive, Upgrade\r\nUpgrade: echo\r\n\r\nhello"))
+	assert.Nil(t, err)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusSwitchingProtocols)
+	assert.Equal(t, resp.Header.Get("Upgrade"), "echo")
+
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(br, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, string(buf), "hello")
+	conn.Write([]byte("world"))
+	_, err = io.ReadFull(br, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, string(buf), "world")
+}
+
+func TestUpgradeHandlerNoUpgrade(t *testing.T) {
+	piper := netplus.NewPiper(nil, time.Minute)
+	srv := httptest.NewServer(netplus.UpgradeHandler(piper, func(r *http.Request) (net.Conn, error) {
+		t.Error("dialled without upgrade")
+		return nil, io.EOF
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusUpgradeRequired)
+	assert.True(t, strings.EqualFold(resp.Header.Get("Connection"), "upgrade"))
+}
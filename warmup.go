@@ -0,0 +1,70 @@
+package netplus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrNoUpstream is returned by Run for a nil upstream when WarmUp was not called
+// and by RunN without upstreams
+var ErrNoUpstream = errors.New("no upstream")
+
+// WarmUp dials n upstream connections with dialFn ahead of time and keeps them
+// in a ConnPool, Run called with a nil upstream then takes one from the pool
+// or dials a new one with dialFn once the pool is empty
+// a second WarmUp closes the connections left by the first one
+// the connections dialed before an error are kept and the first error is returned
+func (p *Piper) WarmUp(ctx context.Context, n int, dialFn func() (io.ReadWriteCloser, error)) error {
+	cp := NewConnPool(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		return dialFn()
+	}, n)
+
+	p.warmMux.Lock()
+	old := p.warm
+	p.warm, p.warmDial = cp, dialFn
+	p.warmMux.Unlock()
+	if old != nil {
+		old.Close()
+	}
+
+	// every connection is held until all are dialed, a Put before that would
+	// let another Get reuse it instead of dialing
+	conns := make([]io.ReadWriteCloser, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conns[i], errs[i] = cp.Get(ctx)
+		}(i)
+	}
+	wg.Wait()
+	var first error
+	for i, c := range conns {
+		err := errs[i]
+		if err == nil {
+			err = cp.Put(c)
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// warmUpstream returns a connection from the WarmUp pool or a new one
+func (p *Piper) warmUpstream() (io.ReadWriteCloser, error) {
+	p.warmMux.Lock()
+	cp, dial := p.warm, p.warmDial
+	p.warmMux.Unlock()
+	if cp == nil {
+		return nil, ErrNoUpstream
+	}
+	if c, ok := cp.take(); ok {
+		return c, nil
+	}
+	return dial()
+}
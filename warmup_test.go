@@ -0,0 +1,61 @@
+package netplus_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestWarmUp(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	_, err := piper.Run(context.Background(), nil, nil)
+	assert.Equal(t, err, netplus.ErrNoUpstream)
+
+	var dials int32
+	dial := func() (io.ReadWriteCloser, error) {
+		atomic.AddInt32(&dials, 1)
+		upstream, server := net.Pipe()
+		go func() {
+			io.Copy(server, server)
+			server.Close()
+		}()
+		return upstream, nil
+	}
+	assert.Nil(t, piper.WarmUp(context.Background(), 2, dial))
+	assert.Equal(t, atomic.LoadInt32(&dials), int32(2))
+
+	for i := 0; i < 3; i++ {
+		client, downstream := net.Pipe()
+		go func() {
+			client.Write([]byte("ping"))
+			b := make([]byte, 4)
+			io.ReadFull(client, b)
+			client.Close()
+		}()
+		n, err := piper.Run(context.Background(), downstream, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, n, int64(8))
+	}
+	// the third session found the pool empty
+	assert.Equal(t, atomic.LoadInt32(&dials), int32(3))
+}
+
+func TestWarmUpDialsAll(t *testing.T) {
+	piper := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	var dials int32
+	dial := func() (io.ReadWriteCloser, error) {
+		atomic.AddInt32(&dials, 1)
+		upstream, _ := net.Pipe()
+		return upstream, nil
+	}
+
+	// no connection warmed up is reused by another dial of the same WarmUp
+	assert.Nil(t, piper.WarmUp(context.Background(), 50, dial))
+	assert.Equal(t, atomic.LoadInt32(&dials), int32(50))
+}
@@ -0,0 +1,297 @@
+package netplus
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultPongTimeout is how long WebSocketKeepaliveWrapper waits for the Pong answering
+// one of its Pings before closing the connection, see WithPongTimeout
+const DefaultPongTimeout = 10 * time.Second
+
+// WithPongTimeout sets how long the wrappers of Piper.WebSocketKeepaliveWrapper
+// wait for a Pong, DefaultPongTimeout when not set
+func WithPongTimeout(d time.Duration) Option {
+	return func(p *Piper) {
+		p.pongTimeout = d
+	}
+}
+
+const (
+	wsOpcodePing = 0x9
+	wsOpcodePong = 0xA
+)
+
+// WebSocketKeepaliveWrapper wraps a WebSocket connection, before or after the HTTP
+// upgrade, and sends a Ping frame every pingInterval
+// Pong frames are passed through to the reader like any other data so the idle timeout
+// of a Piper running the connection resets on them even without application data,
+// an unsolicited Pong is allowed by RFC 6455 and ignored by the other peer
+// Pings are masked or not depending on the frames read from conn, and are only sent
+// between frames written to the connection
+// the connection is closed when a Pong does not arrive within DefaultPongTimeout
+func WebSocketKeepaliveWrapper(conn io.ReadWriteCloser, pingInterval time.Duration) io.ReadWriteCloser {
+	return newWSKeepalive(conn, pingInterval, DefaultPongTimeout)
+}
+
+// WebSocketKeepaliveWrapper is like the package function but waits for a Pong
+// as long as set WithPongTimeout
+func (p *Piper) WebSocketKeepaliveWrapper(conn io.ReadWriteCloser, pingInterval time.Duration) io.ReadWriteCloser {
+	timeout := p.pongTimeout
+	if timeout <= 0 {
+		timeout = DefaultPongTimeout
+	}
+	return newWSKeepalive(conn, pingInterval, timeout)
+}
+
+func newWSKeepalive(conn io.ReadWriteCloser, pingInterval, pongTimeout time.Duration) *wsKeepalive {
+	ws := &wsKeepalive{
+		conn:        conn,
+		pongTimeout: pongTimeout,
+		done:        make(chan struct{}),
+	}
+	go ws.pingLoop(pingInterval)
+	return ws
+}
+
+type wsKeepalive struct {
+	conn        io.ReadWriteCloser
+	pongTimeout time.Duration
+
+	// read is only used by Read
+	read wsFrameTracker
+
+	// writeMux serializes the writes to conn and guards write, it is never
+	// taken by Read so a stalled write does not hold up the other direction
+	writeMux sync.Mutex
+	write    wsFrameTracker
+
+	// mux guards the keepalive state and is never held across I/O
+	mux       sync.Mutex
+	peerRole  wsRole
+	handshake bool
+	pingSent  time.Time
+	awaiting  bool
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// wsRole tells whether the peer is a WebSocket client or server
+type wsRole int
+
+const (
+	wsRoleUnknown wsRole = iota
+	wsRoleClient
+	wsRoleServer
+)
+
+func (ws *wsKeepalive) Read(b []byte) (int, error) {
+	n, err := ws.conn.Read(b)
+	if n > 0 {
+		ws.read.feed(b[:n], ws.onPeerFrame)
+	}
+	return n, err
+}
+
+// onPeerFrame is called by the read tracker, role is only known for the handshake
+// otherwise masked frames come from a client
+func (ws *wsKeepalive) onPeerFrame(opcode byte, masked bool, role wsRole) {
+	ws.mux.Lock()
+	defer ws.mux.Unlock()
+	if role != wsRoleUnknown {
+		ws.peerRole = role
+		ws.handshake = true
+		return
+	}
+	if masked {
+		ws.peerRole = wsRoleClient
+	} else {
+		ws.peerRole = wsRoleServer
+	}
+	if opcode == wsOpcodePong {
+		ws.awaiting = false
+	}
+}
+
+func (ws *wsKeepalive) Write(b []byte) (int, error) {
+	ws.writeMux.Lock()
+	defer ws.writeMux.Unlock()
+	n, err := ws.conn.Write(b)
+	if n > 0 {
+		ws.write.feed(b[:n], nil)
+	}
+	return n, err
+}
+
+func (ws *wsKeepalive) Close() error {
+	err := io.ErrClosedPipe
+	ws.closeOnce.Do(func() {
+		close(ws.done)
+		err = ws.conn.Close()
+	})
+	return err
+}
+
+func (ws *wsKeepalive) pingLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ws.done:
+			return
+		case <-ticker.C:
+		}
+		ws.mux.Lock()
+		timedOut := ws.awaiting && time.Since(ws.pingSent) > ws.pongTimeout
+		role, handshake, awaiting := ws.peerRole, ws.handshake, ws.awaiting
+		ws.mux.Unlock()
+		if timedOut {
+			ws.Close()
+			return
+		}
+		if role == wsRoleUnknown || awaiting {
+			continue
+		}
+		ws.ping(role, handshake)
+	}
+}
+
+// ping writes a Ping frame if the written stream is between two frames
+func (ws *wsKeepalive) ping(role wsRole, handshake bool) {
+	ws.writeMux.Lock()
+	defer ws.writeMux.Unlock()
+	// before anything was written the upgrade response may still be due
+	if !ws.write.atBoundary() && (ws.write.started || handshake) {
+		return
+	}
+	ping := []byte{0x80 | wsOpcodePing, 0}
+	if role == wsRoleServer {
+		// frames sent by a client are masked, the key does not matter without payload
+		ping = []byte{0x80 | wsOpcodePing, 0x80, 0, 0, 0, 0}
+	}
+	// mark the Ping first, the Pong may be read before Write returns
+	ws.mux.Lock()
+	ws.pingSent = time.Now()
+	ws.awaiting = true
+	ws.mux.Unlock()
+	if _, err := ws.conn.Write(ping); err != nil {
+		ws.mux.Lock()
+		ws.awaiting = false
+		ws.mux.Unlock()
+	}
+}
+
+// wsFrameTracker follows the frame boundaries of one direction of a WebSocket stream
+// an HTTP upgrade request or response at the start is skipped
+type wsFrameTracker struct {
+	started   bool
+	http      bool
+	crlf      int
+	header    [14]byte
+	hlen      int
+	remaining uint64
+}
+
+// feed parses b, calling onFrame for every frame header when it is not nil
+func (t *wsFrameTracker) feed(b []byte, onFrame func(opcode byte, masked bool, role wsRole)) {
+	for len(b) > 0 {
+		if !t.started {
+			t.started = true
+			// neither G nor H is a valid first byte of a frame, they have RSV1 set
+			if b[0] == 'G' || b[0] == 'H' {
+				t.http = true
+				if onFrame != nil {
+					role := wsRoleServer
+					if b[0] == 'G' {
+						role = wsRoleClient
+					}
+					onFrame(0, false, role)
+				}
+			}
+		}
+		if t.http {
+			b = t.skipHTTP(b)
+			continue
+		}
+		if t.remaining > 0 {
+			n := uint64(len(b))
+			if n > t.remaining {
+				n = t.remaining
+			}
+			b = b[n:]
+			t.remaining -= n
+			continue
+		}
+		t.header[t.hlen] = b[0]
+		t.hlen++
+		b = b[1:]
+		if need := wsHeaderLen(t.header[:t.hlen]); need > 0 && t.hlen == need {
+			t.remaining = wsPayloadLen(t.header[:t.hlen])
+			t.hlen = 0
+			if onFrame != nil {
+				onFrame(t.header[0]&0x0f, t.header[1]&0x80 != 0, wsRoleUnknown)
+			}
+		}
+	}
+}
+
+// skipHTTP consumes b up to the end of the HTTP headers and returns the rest
+func (t *wsFrameTracker) skipHTTP(b []byte) []byte {
+	const end = "\r\n\r\n"
+	for i, c := range b {
+		switch {
+		case c == end[t.crlf]:
+			t.crlf++
+		case c == '\r':
+			t.crlf = 1
+		default:
+			t.crlf = 0
+		}
+		if t.crlf == len(end) {
+			t.http = false
+			return b[i+1:]
+		}
+	}
+	return nil
+}
+
+// atBoundary reports whether the stream is between two frames
+func (t *wsFrameTracker) atBoundary() bool {
+	return t.started && !t.http && t.hlen == 0 && t.remaining == 0
+}
+
+// wsHeaderLen returns the length of the frame header starting with h, 0 if h is too short to tell
+func wsHeaderLen(h []byte) int {
+	if len(h) < 2 {
+		return 0
+	}
+	n := 2
+	switch h[1] & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if h[1]&0x80 != 0 {
+		n += 4
+	}
+	return n
+}
+
+// wsPayloadLen returns the payload length of the complete frame header h
+func wsPayloadLen(h []byte) uint64 {
+	switch l := h[1] & 0x7f; l {
+	case 126:
+		return uint64(h[2])<<8 | uint64(h[3])
+	case 127:
+		var n uint64
+		for _, c := range h[2:10] {
+			n = n<<8 | uint64(c)
+		}
+		return n
+	default:
+		return uint64(l)
+	}
+}
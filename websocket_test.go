@@ -0,0 +1,95 @@
+package netplus_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestWebSocketKeepalive(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	ws := netplus.WebSocketKeepaliveWrapper(server, 10*time.Millisecond)
+	defer ws.Close()
+
+	// upgrade request then a masked text frame
+	request := "GET / HTTP/1.1\r\nUpgrade: websocket\r\n\r\n"
+	frame := []byte{0x81, 0x82, 0, 0, 0, 0, 'h', 'i'}
+	go func() {
+		client.Write([]byte(request))
+		client.Write(frame)
+	}()
+	b := make([]byte, len(request)+len(frame))
+	_, err := io.ReadFull(ws, b)
+	assert.Nil(t, err)
+	assert.Equal(t, string(b[:len(request)]), request)
+
+	// no Ping before the upgrade response
+	time.Sleep(30 * time.Millisecond)
+	response := "HTTP/1.1 101 Switching Protocols\r\n\r\n"
+	go ws.Write([]byte(response))
+	// the peer is a client so the Ping is not masked
+	got := make([]byte, len(response)+2)
+	_, err = io.ReadFull(client, got)
+	assert.Nil(t, err)
+	assert.Equal(t, string(got[:len(response)]), response)
+	assert.Equal(t, got[len(response):], []byte{0x89, 0x00})
+
+	// the Pong is passed through
+	pong := []byte{0x8a, 0x80, 0, 0, 0, 0}
+	go client.Write(pong)
+	b = make([]byte, len(pong))
+	_, err = io.ReadFull(ws, b)
+	assert.Nil(t, err)
+	assert.Equal(t, b, pong)
+}
+
+func TestWebSocketStalledWrite(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	ws := netplus.WebSocketKeepaliveWrapper(server, time.Hour)
+	defer ws.Close()
+
+	// nobody reads the client side so this write blocks
+	go ws.Write([]byte{0x81, 0x00})
+	time.Sleep(10 * time.Millisecond)
+
+	go client.Write([]byte{0x8a, 0x80, 0, 0, 0, 0})
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(ws, make([]byte, 6))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("read blocked by a pending write")
+	}
+}
+
+func TestWebSocketPongTimeout(t *testing.T) {
+	piper := netplus.NewPiper(nil, time.Minute, netplus.WithPongTimeout(20*time.Millisecond))
+	client, server := net.Pipe()
+	defer client.Close()
+	ws := piper.WebSocketKeepaliveWrapper(server, 10*time.Millisecond)
+	defer ws.Close()
+
+	// an unmasked frame from a server, the Ping is masked
+	go client.Write([]byte{0x81, 0x00})
+	_, err := io.ReadFull(ws, make([]byte, 2))
+	assert.Nil(t, err)
+	ping := make([]byte, 6)
+	_, err = io.ReadFull(client, ping)
+	assert.Nil(t, err)
+	assert.Equal(t, ping[:2], []byte{0x89, 0x80})
+
+	// no Pong, the connection gets closed
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = client.Read(make([]byte, 1))
+	assert.Equal(t, err, io.EOF)
+}
@@ -0,0 +1,66 @@
+package netplus
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// Deadliner is implemented by streams that support deadlines like net.Conn
+type Deadliner interface {
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// WrapAsConn returns a net.Conn reading, writing and closing rwc and reporting
+// localAddr and remoteAddr, the deadlines are passed on when rwc is a Deadliner
+// and fail with os.ErrNoDeadline otherwise
+func WrapAsConn(rwc io.ReadWriteCloser, localAddr, remoteAddr net.Addr) net.Conn {
+	return &rwcConn{ReadWriteCloser: rwc, local: localAddr, remote: remoteAddr}
+}
+
+type rwcConn struct {
+	io.ReadWriteCloser
+	local, remote net.Addr
+}
+
+func (c *rwcConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *rwcConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *rwcConn) SetDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(Deadliner); ok {
+		return d.SetDeadline(t)
+	}
+	return os.ErrNoDeadline
+}
+
+func (c *rwcConn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(Deadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return os.ErrNoDeadline
+}
+
+func (c *rwcConn) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(Deadliner); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return os.ErrNoDeadline
+}
+
+// Wrap pipes rwc through p in the background and returns the other end, reads
+// come from rwc and writes go to it with the limits, timeouts and stats of p
+// applied, so pipers can be chained, closing the result ends the Run and closes rwc
+func (p *Piper) Wrap(ctx context.Context, rwc io.ReadWriteCloser) io.ReadWriteCloser {
+	conn, downstream := net.Pipe()
+	p.RunAsync(ctx, downstream, rwc)
+	return conn
+}
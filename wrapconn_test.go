@@ -0,0 +1,73 @@
+package netplus_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+// rwc hides everything but Read, Write and Close of a connection
+type rwc struct {
+	io.ReadWriteCloser
+}
+
+func TestWrapAsConn(t *testing.T) {
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+
+	// without deadline support the deadlines fail
+	a, b := net.Pipe()
+	defer b.Close()
+	c := netplus.WrapAsConn(rwc{a}, local, remote)
+	assert.Equal(t, c.LocalAddr(), net.Addr(local))
+	assert.Equal(t, c.RemoteAddr(), net.Addr(remote))
+	assert.True(t, errors.Is(c.SetDeadline(time.Now()), os.ErrNoDeadline))
+	assert.True(t, errors.Is(c.SetReadDeadline(time.Now()), os.ErrNoDeadline))
+	assert.True(t, errors.Is(c.SetWriteDeadline(time.Now()), os.ErrNoDeadline))
+	go b.Write([]byte("hi"))
+	buf := make([]byte, 2)
+	_, err := io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, string(buf), "hi")
+	assert.Nil(t, c.Close())
+
+	// a Deadliner gets real deadlines
+	a, b = net.Pipe()
+	defer b.Close()
+	c = netplus.WrapAsConn(a, local, remote)
+	assert.Nil(t, c.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, err = c.Read(buf)
+	assert.True(t, os.IsTimeout(err), err)
+	c.Close()
+}
+
+func TestPiperWrap(t *testing.T) {
+	first := netplus.NewPiper(&recordingLogger{}, time.Minute)
+	second := netplus.NewPiper(&recordingLogger{}, time.Minute)
+
+	a, b := net.Pipe()
+	go func() {
+		io.Copy(b, b)
+		b.Close()
+	}()
+	c := second.Wrap(context.Background(), first.Wrap(context.Background(), a))
+	_, err := c.Write([]byte("ping"))
+	assert.Nil(t, err)
+	got := make([]byte, 4)
+	_, err = io.ReadFull(c, got)
+	assert.Nil(t, err)
+	assert.Equal(t, string(got), "ping")
+	assert.Equal(t, first.ActiveConnections(), int64(1))
+	assert.Equal(t, second.ActiveConnections(), int64(1))
+
+	c.Close()
+	assert.Nil(t, first.WaitIdle(context.Background()))
+	assert.Nil(t, second.WaitIdle(context.Background()))
+}
@@ -0,0 +1,36 @@
+package netplus
+
+import (
+	"io"
+	"net"
+)
+
+// VecWriter is the io.Writer returned by ScatterGatherWriter
+type VecWriter interface {
+	io.Writer
+	// WriteVec writes the segments of bufs in order as one stream and returns
+	// the bytes written, bufs is left untouched
+	WriteVec(bufs [][]byte) (int64, error)
+}
+
+// ScatterGatherWriter returns a writer to conn whose WriteVec gathers several
+// segments, such as a frame header and its payload, in a single writev call on
+// TCP and unix connections, other connections get one write per segment
+// assert the result to VecWriter to reach WriteVec
+func ScatterGatherWriter(conn net.Conn) io.Writer {
+	return &scatterGatherWriter{conn: conn}
+}
+
+type scatterGatherWriter struct {
+	conn net.Conn
+}
+
+func (w *scatterGatherWriter) Write(b []byte) (int, error) {
+	return w.conn.Write(b)
+}
+
+func (w *scatterGatherWriter) WriteVec(bufs [][]byte) (int64, error) {
+	// net.Buffers issues the writev and consumes the slice it is given
+	v := append(net.Buffers(nil), bufs...)
+	return v.WriteTo(w.conn)
+}
@@ -0,0 +1,47 @@
+package netplus_test
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+	"go.ideatocode.tech/netplus"
+)
+
+func TestScatterGatherWriter(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	peer, err := ln.Accept()
+	assert.Nil(t, err)
+	defer peer.Close()
+
+	w := netplus.ScatterGatherWriter(conn).(netplus.VecWriter)
+	bufs := [][]byte{[]byte("head"), []byte("er"), []byte("payload")}
+	n, err := w.WriteVec(bufs)
+	assert.Nil(t, err)
+	assert.Equal(t, n, int64(13))
+	assert.Equal(t, string(bufs[0]), "head")
+	_, err = w.Write([]byte("!"))
+	assert.Nil(t, err)
+	conn.Close()
+
+	got, err := io.ReadAll(peer)
+	assert.Nil(t, err)
+	assert.Equal(t, string(got), "headerpayload!")
+}
+
+func TestScatterGatherWriterPipe(t *testing.T) {
+	client, server := net.Pipe()
+	w := netplus.ScatterGatherWriter(client).(netplus.VecWriter)
+	go func() {
+		w.WriteVec([][]byte{[]byte("ab"), []byte("cd")})
+		client.Close()
+	}()
+	got, err := io.ReadAll(server)
+	assert.Nil(t, err)
+	assert.Equal(t, string(got), "abcd")
+}